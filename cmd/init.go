@@ -27,7 +27,7 @@ var initCmd = &cobra.Command{
 		ssmService := ssm.NewClient(configuration.AgentConfig)
 		registration := agent.NewRegistration(ssmService, configuration.ActivationConfig)
 		fsPersistence := state.NewFileSystemPersistence(configuration.StateConfig)
-		secret, err := k8s.NewSecretInCluster(configuration.StateConfig)
+		secret, err := k8s.NewSecretInCluster(configuration.ClusterConfig, configuration.StateConfig)
 		if err != nil {
 			klog.Fatalf("failed to initiate kubernetes client: %v", err)
 		}
@@ -65,9 +65,19 @@ func init() {
 	initCmd.Flags().String("state.secretNamePrefix",
 		"eks-connector-state",
 		"Prefix of Kubernetes Secret name used to persist eks-connector state")
+	initCmd.Flags().String("state.secretName",
+		"",
+		"Name of Kubernetes Secret used to persist eks-connector state, instead of the prefix suffixed with the StatefulSet pod index of env POD_NAME. "+
+			"Required outside of a StatefulSet")
 	initCmd.Flags().String("state.secretNamespace",
 		"eks-connector",
 		"Kubernetes namespace of the Secret used to persist eks-connector state")
+	initCmd.Flags().String("cluster.kubeconfig",
+		"",
+		"The kubeconfig of the cluster, when EKS connector runs outside of it. The in-cluster service account is used if empty")
+	initCmd.Flags().String("cluster.credentialsDir",
+		"",
+		"The directory of the service account token and CA bundle, e.g. a projected volume when automounting is disabled. The automounted service account is used if empty")
 	_ = initCmd.MarkFlagRequired("activation.id")
 	_ = initCmd.MarkFlagRequired("activation.code")
 
@@ -1,18 +1,36 @@
 package main
 
 import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"k8s.io/klog/v2"
 
+	"github.com/aws/amazon-eks-connector/pkg/audit"
 	"github.com/aws/amazon-eks-connector/pkg/config"
 	"github.com/aws/amazon-eks-connector/pkg/fsnotify"
+	"github.com/aws/amazon-eks-connector/pkg/health"
+	"github.com/aws/amazon-eks-connector/pkg/identity"
+	"github.com/aws/amazon-eks-connector/pkg/k8s"
+	"github.com/aws/amazon-eks-connector/pkg/kubeconfig"
+	"github.com/aws/amazon-eks-connector/pkg/metrics"
 	"github.com/aws/amazon-eks-connector/pkg/proxy"
+	"github.com/aws/amazon-eks-connector/pkg/recording"
 	"github.com/aws/amazon-eks-connector/pkg/server"
 	"github.com/aws/amazon-eks-connector/pkg/serviceaccount"
 	"github.com/aws/amazon-eks-connector/pkg/state"
 )
 
+// monitoringShutdownTimeout bounds the last metrics scrapes and health checks once the proxy is drained.
+const monitoringShutdownTimeout = 5 * time.Second
+
 var serverCmdViperFlag = viper.New()
 var serverCmd = &cobra.Command{
 	Use:     "server",
@@ -25,40 +43,395 @@ var serverCmd = &cobra.Command{
 			klog.Fatalf("failed to load configuration: %v", err)
 		}
 
+		if err = proxy.ValidateMode(configuration.ProxyConfig.Mode); err != nil {
+			klog.Fatalf("invalid configuration: %v", err)
+		}
+		if err = proxy.ValidateFailoverPolicy(configuration.ProxyConfig.Failover.Policy); err != nil {
+			klog.Fatalf("invalid configuration: %v", err)
+		}
+
 		secretProvider := serviceaccount.NewProvider()
+		secretDir := serviceaccount.BaseDir
+		if credentialsDir := configuration.ClusterConfig.CredentialsDir; credentialsDir != "" {
+			if configuration.ClusterConfig.Kubeconfig != "" {
+				klog.Fatalf("invalid configuration: cluster.kubeconfig and cluster.credentialsDir are exclusive")
+			}
+			secretProvider = serviceaccount.NewDirProvider(credentialsDir)
+			secretDir = credentialsDir
+		}
+		if kubeconfigPath := configuration.ClusterConfig.Kubeconfig; kubeconfigPath != "" {
+			clusterKubeconfig, err := kubeconfig.Load(kubeconfigPath)
+			if err != nil {
+				klog.Fatalf("failed to load kubeconfig: %v", err)
+			}
+			configuration.ProxyConfig.TargetProtocol, configuration.ProxyConfig.TargetHost, err = clusterKubeconfig.Target()
+			if err != nil {
+				klog.Fatalf("failed to load kubeconfig: %v", err)
+			}
+			secretProvider = serviceaccount.NewKubeconfigProvider(kubeconfigPath)
+			secretDir = filepath.Dir(kubeconfigPath)
+			klog.Infof("proxying to %s from kubeconfig %s", clusterKubeconfig.Server, kubeconfigPath)
+		}
+		secretProvider = serviceaccount.NewCachedProvider(secretProvider, secretDir)
+		if configuration.ProxyConfig.TokenRequest.ServiceAccount != "" {
+			client, err := k8s.NewClientset(configuration.ClusterConfig)
+			if err != nil {
+				klog.Fatalf("failed to initiate kubernetes client: %v", err)
+			}
+			secretProvider, err = serviceaccount.NewTokenRequestProvider(client, &configuration.ProxyConfig.TokenRequest, secretProvider)
+			if err != nil {
+				klog.Fatalf("invalid configuration: %v", err)
+			}
+		}
+
+		identityVerifier, err := proxy.NewSignatureVerifier(&configuration.ProxyConfig.Identity.Signature)
+		if err != nil {
+			klog.Fatalf("failed to load identity signature key: %v", err)
+		}
+
+		identityMapper := identity.NewPassthroughMapper()
+		if mappingFile := configuration.ProxyConfig.Identity.MappingFile; mappingFile != "" {
+			identityMapper, err = identity.NewMapperFromFile(mappingFile)
+			if err != nil {
+				klog.Fatalf("failed to load identity mapping: %v", err)
+			}
+			klog.Infof("loaded identity mapping from %s", mappingFile)
+		}
+
+		authorizer := proxy.NewAlwaysAllowAuthorizer()
+		if policyFile := configuration.ProxyConfig.PolicyFile; policyFile != "" {
+			authorizer, err = proxy.NewPolicyAuthorizer(policyFile)
+			if err != nil {
+				klog.Fatalf("failed to load authorization policy: %v", err)
+			}
+		}
+
+		auditor, err := audit.NewLogger(&configuration.ProxyConfig.Audit)
+		if err != nil {
+			klog.Fatalf("failed to setup audit log: %v", err)
+		}
 
-		server := server.Server{
+		recorder, err := recording.NewRecorder(&configuration.ProxyConfig.Sessions.Recording)
+		if err != nil {
+			klog.Fatalf("failed to setup session recording: %v", err)
+		}
+
+		proxyHandler := proxy.NewProxyHandler(configuration.ProxyConfig, secretProvider, identityVerifier, identityMapper, authorizer, auditor, recorder)
+		server := &server.Server{
 			ProxyConfig:  configuration.ProxyConfig,
-			ProxyHandler: proxy.NewProxyHandler(configuration.ProxyConfig, secretProvider),
+			ProxyHandler: proxyHandler,
+		}
+
+		// metrics and health checks listen through the proxy server, which hands them off in upgrades.
+		var metricsServer, healthServer *http.Server
+		if bindAddress := configuration.MetricsConfig.BindAddress; bindAddress != "" {
+			listener, err := server.ListenTCP("metrics", bindAddress)
+			if err != nil {
+				klog.Fatalf("failed to serve metrics: %v", err)
+			}
+			metricsServer = metrics.Serve(listener)
 		}
 
-		if err = fsnotify.NewWatcher(configuration.StateConfig); err != nil {
+		if bindAddress := configuration.HealthConfig.BindAddress; bindAddress != "" {
+			listener, err := server.ListenTCP("health", bindAddress)
+			if err != nil {
+				klog.Fatalf("failed to serve health checks: %v", err)
+			}
+			healthServer = health.Serve(listener,
+				health.Check{Name: "listener", Liveness: true, Run: server.CheckListener},
+				health.Check{Name: "serviceaccount", Run: func(ctx context.Context) error {
+					_, err := secretProvider.Get()
+					return err
+				}},
+				health.Check{Name: "apiserver", Run: proxyHandler.CheckUpstream},
+				health.Check{Name: "initialsync", Run: fsnotify.CheckInitialSync},
+			)
+		}
+
+		if err = fsnotify.NewWatcher(configuration.ClusterConfig, configuration.StateConfig); err != nil {
 			klog.Fatalf("failed to setup file watcher: %v", err)
 		}
 
+		stopped := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		upgrades := make(chan os.Signal, 1)
+		if len(upgradeSignals) > 0 {
+			signal.Notify(upgrades, upgradeSignals...)
+		}
+		go func() {
+			for {
+				select {
+				case sig := <-upgrades:
+					klog.Infof("received %s, upgrading to a new process of %s", sig, os.Args[0])
+					if err := server.Upgrade(configuration.ProxyConfig.Timeouts.Upgrade); err != nil {
+						klog.Errorf("upgrade failed, the proxy keeps serving: %v", err)
+						continue
+					}
+					klog.Infof("the new process is serving, shutting down")
+				case sig := <-signals:
+					klog.Infof("received %s, shutting down", sig)
+				}
+				// a second signal kills the proxy without draining.
+				signal.Stop(signals)
+				signal.Stop(upgrades)
+				shutdown(configuration.ProxyConfig.Timeouts.Shutdown, server, auditor, metricsServer, healthServer)
+				close(stopped)
+				return
+			}
+		}()
+
 		server.Run()
+		<-stopped
+		klog.Infof("eks connector proxy stopped")
 	},
 }
 
+// shutdown drains the proxy server within timeout, then flushes the audit log
+// and stops serving metrics and health checks.
+func shutdown(timeout time.Duration, proxyServer *server.Server, auditor audit.Logger, monitoringServers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := proxyServer.Shutdown(ctx); err != nil {
+		klog.Warningf("closed requests still in flight after %s: %v", timeout, err)
+	}
+	if err := auditor.Close(); err != nil {
+		klog.Errorf("failed to flush audit log: %v", err)
+	}
+
+	monitoringCtx, monitoringCancel := context.WithTimeout(context.Background(), monitoringShutdownTimeout)
+	defer monitoringCancel()
+	for _, monitoringServer := range monitoringServers {
+		if monitoringServer == nil {
+			continue
+		}
+		if err := monitoringServer.Shutdown(monitoringCtx); err != nil {
+			_ = monitoringServer.Close()
+		}
+	}
+}
+
 func init() {
 	serverCmd.Flags().String("proxy.socketType",
 		"unix",
-		"The socket type of proxy. Can be 'unix' or 'tcp'")
+		"The socket type of proxy. Can be 'unix', 'tcp', 'tls', 'systemd' or 'fd'. "+
+			"'systemd' adopts a socket passed by systemd socket activation, 'fd' a listening socket inherited as a file descriptor")
 	serverCmd.Flags().String("proxy.socketAddr",
 		"/var/eks/shared/connector.sock",
-		"The address of proxy, should be a FS path or network address depending on socket type")
+		"The address of proxy, should be a FS path or network address depending on socket type. "+
+			"The FileDescriptorName of the socket with 'systemd', the first passed socket if empty, "+
+			"and the file descriptor number with 'fd'")
+	serverCmd.Flags().StringSlice("proxy.listeners",
+		nil,
+		"Listeners served in addition to proxy.socketAddr, written <socket type>://<address>[?<options>], "+
+			"e.g. 'tcp://127.0.0.1:8080?mode=readonly&identity=trusted'. "+
+			"Options are mode, overriding proxy.mode, and identity=trusted, skipping identity signature verification")
+	serverCmd.Flags().String("proxy.unixSocket.mode",
+		"0700",
+		"The octal file mode of the proxy unix socket")
+	serverCmd.Flags().String("proxy.unixSocket.owner",
+		"",
+		"The user, by name or uid, the proxy unix socket belongs to. Defaults to the user of the proxy")
+	serverCmd.Flags().String("proxy.unixSocket.group",
+		"",
+		"The group, by name or gid, the proxy unix socket belongs to. Defaults to the group of the proxy")
+	serverCmd.Flags().IntSlice("proxy.unixSocket.allowedUids",
+		nil,
+		"The uids of the processes allowed to connect to the proxy unix socket, e.g. of the SSM agent. If not set, any uid is allowed")
+	serverCmd.Flags().IntSlice("proxy.unixSocket.allowedGids",
+		nil,
+		"The gids of the processes allowed to connect to the proxy unix socket. If not set, any gid is allowed")
+	serverCmd.Flags().StringSlice("proxy.unixSocket.allowedExecutables",
+		nil,
+		"The executable paths of the processes allowed to connect to the proxy unix socket. "+
+			"Requires a pid namespace shared with them. If not set, any executable is allowed")
+	serverCmd.Flags().String("proxy.tls.certFile",
+		"",
+		"Path of the PEM certificate served by the proxy with the 'tls' socket type. Reloaded when it changes")
+	serverCmd.Flags().String("proxy.tls.keyFile",
+		"",
+		"Path of the PEM key of proxy.tls.certFile")
+	serverCmd.Flags().String("proxy.tls.clientCAFile",
+		"",
+		"Path of the PEM CA bundle verifying client certificates. If set, clients must present a certificate signed by one of its CAs")
+	serverCmd.Flags().String("proxy.tls.minVersion",
+		"1.2",
+		"The minimum TLS version accepted by the proxy. Can be '1.2' or '1.3'")
+	serverCmd.Flags().StringSlice("proxy.tls.cipherSuites",
+		nil,
+		"The TLS 1.2 cipher suites accepted by the proxy, e.g. 'TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256'. If not set, the go defaults are used")
+	serverCmd.Flags().String("cluster.kubeconfig",
+		"",
+		"The kubeconfig of the cluster, when EKS connector runs outside of it. Replaces the in-cluster service account, proxy.targetHost and proxy.targetProtocol when set")
+	serverCmd.Flags().String("cluster.credentialsDir",
+		"",
+		"The directory of the service account token and CA bundle, e.g. a projected volume when automounting is disabled. The automounted service account is used if empty")
 	serverCmd.Flags().String("proxy.targetHost",
 		"kubernetes.default.svc:443",
 		"The target of the proxy, should be api server's address")
 	serverCmd.Flags().String("proxy.targetProtocol",
 		"https",
 		"The target protocol of the proxy. Can be 'https' or 'http'")
+	serverCmd.Flags().StringSlice("proxy.targetHosts",
+		nil,
+		"The api server addresses the proxy fails over between, e.g. of each control plane node. Replaces proxy.targetHost when set")
+	serverCmd.Flags().String("proxy.failover.policy",
+		"roundrobin",
+		"How requests are sent to healthy proxy.targetHosts. Can be 'roundrobin' or 'priority'")
+	serverCmd.Flags().Duration("proxy.failover.healthCheckInterval",
+		10*time.Second,
+		"How often /readyz of each of proxy.targetHosts is checked. 0 disables the health checks")
+	serverCmd.Flags().Duration("proxy.failover.healthCheckTimeout",
+		5*time.Second,
+		"The maximum time waiting for /readyz of one of proxy.targetHosts")
+	serverCmd.Flags().Duration("proxy.failover.ejectionDuration",
+		30*time.Second,
+		"How long one of proxy.targetHosts that refused a connection is skipped")
+	serverCmd.Flags().Int("proxy.transport.maxIdleConns",
+		100,
+		"The maximum number of idle keep-alive connections kept open to the api server")
+	serverCmd.Flags().Duration("proxy.transport.idleConnTimeout",
+		90*time.Second,
+		"How long an idle keep-alive connection to the api server is kept before it is closed")
+	serverCmd.Flags().Duration("proxy.transport.tlsHandshakeTimeout",
+		10*time.Second,
+		"The maximum time waiting for a TLS handshake with the api server")
+	serverCmd.Flags().Bool("proxy.transport.enableHTTP2",
+		false,
+		"Allow the proxy to negotiate HTTP/2 with the api server")
+	serverCmd.Flags().StringSlice("proxy.headers.allow",
+		nil,
+		"Additional client headers forwarded to the api server. A trailing '*' matches a header name prefix")
+	serverCmd.Flags().StringSlice("proxy.headers.deny",
+		nil,
+		"Client headers never forwarded to the api server, even if allowed. A trailing '*' matches a header name prefix")
+	serverCmd.Flags().String("proxy.identity.mappingFile",
+		"",
+		"Path of the aws-auth style identity mapping file, e.g. mounted from a ConfigMap. "+
+			"If not set, the requester IAM identity is impersonated verbatim")
+	serverCmd.Flags().StringSlice("proxy.identity.allowedPartitions",
+		nil,
+		"AWS partitions requester IAM identities may belong to, e.g. 'aws'. If not set, any partition is allowed")
+	serverCmd.Flags().StringSlice("proxy.identity.allowedAccountIds",
+		nil,
+		"AWS account IDs requester IAM identities may belong to. If not set, any account is allowed")
+	serverCmd.Flags().String("proxy.identity.signature.algorithm",
+		"",
+		"The algorithm of the signature required on requester IAM identities. Can be 'hmac-sha256', 'ecdsa-sha256' or 'ed25519'. "+
+			"If not set, anything that can connect to the proxy socket can assert any IAM identity")
+	serverCmd.Flags().String("proxy.identity.signature.keyFile",
+		"",
+		"Path of the key verifying requester IAM identity signatures, e.g. mounted from a Secret. "+
+			"The shared key of 'hmac-sha256', or the PEM public key of 'ecdsa-sha256' and 'ed25519'")
+	serverCmd.Flags().Duration("proxy.identity.signature.maxClockSkew",
+		5*time.Minute,
+		"How far the timestamp of a signed requester IAM identity may be from the proxy clock. Nonces are remembered for as long")
+	serverCmd.Flags().String("proxy.policyFile",
+		"",
+		"Path of the authorization policy file evaluated before requests reach the api server. "+
+			"The file is reloaded when it changes. If not set, all decisions are left to kubernetes RBAC")
+	serverCmd.Flags().String("proxy.mode",
+		proxy.ModeReadWrite,
+		"The mode of proxy. Can be 'readwrite' or 'readonly'. "+
+			"'readonly' rejects mutating, exec, attach, port-forward and pods, services or nodes proxy requests "+
+			"whatever RBAC allows")
+	serverCmd.Flags().Float64("proxy.limits.requestsPerSecond",
+		0,
+		"The sustained rate of requests allowed per requester IAM identity, shared by the sessions of an assumed role. 0 disables rate limiting. Disabled by default")
+	serverCmd.Flags().Int("proxy.limits.burst",
+		40,
+		"The number of requests a requester IAM identity may send at once above its sustained rate")
+	serverCmd.Flags().Int("proxy.limits.maxInFlight",
+		0,
+		"The maximum number of requests proxied concurrently, excluding long-running ones. 0 is unlimited, the default")
+	serverCmd.Flags().Int("proxy.limits.maxLongRunningInFlight",
+		0,
+		"The maximum number of watch, log follow, exec, attach and port-forward requests proxied concurrently. 0 is unlimited, the default")
+	serverCmd.Flags().String("proxy.tokenRequest.serviceAccount",
+		"",
+		"The namespace/name of the service account whose short-lived tokens authenticate the proxy, requested through the TokenRequest API. The token of the cluster credentials is used if empty")
+	serverCmd.Flags().StringSlice("proxy.tokenRequest.audiences",
+		nil,
+		"The audiences of the requested tokens. Defaults to the api server audiences")
+	serverCmd.Flags().Duration("proxy.tokenRequest.expiration",
+		10*time.Minute,
+		"The lifetime of the requested tokens, at least 10 minutes. Tokens are refreshed after 80% of their lifetime")
+	serverCmd.Flags().Duration("proxy.timeouts.request",
+		60*time.Second,
+		"The maximum time to proxy a request other than watch, log follow, exec, attach and port-forward. 0 disables the timeout")
+	serverCmd.Flags().Duration("proxy.timeouts.longRunningIdleTimeout",
+		10*time.Minute,
+		"End watch and log follow responses without data for this long. 0 disables the timeout")
+	serverCmd.Flags().Duration("proxy.timeouts.longRunningMaxDuration",
+		30*time.Minute,
+		"End watch and log follow responses open for this long. 0 disables the timeout")
+	serverCmd.Flags().Duration("proxy.timeouts.shutdown",
+		25*time.Second,
+		"How long in-flight requests are drained on SIGTERM before their connections are closed. "+
+			"Watch, log follow and sessions are ended at once. Should be below the pod termination grace period")
+	serverCmd.Flags().Duration("proxy.timeouts.upgrade",
+		30*time.Second,
+		"How long the new process started on SIGUSR2 may take to open the inherited listeners. "+
+			"The proxy hands its listeners off to the new process, then drains and exits. Otherwise the new process is killed")
+	serverCmd.Flags().Bool("proxy.sessions.enableExec",
+		false,
+		"Allow exec into containers through the proxy. Disabled by default")
+	serverCmd.Flags().Bool("proxy.sessions.enableAttach",
+		false,
+		"Allow attach to containers through the proxy. Disabled by default")
+	serverCmd.Flags().Bool("proxy.sessions.enablePortForward",
+		false,
+		"Allow port-forward to pods through the proxy. Disabled by default")
+	serverCmd.Flags().Duration("proxy.sessions.idleTimeout",
+		15*time.Minute,
+		"Close exec, attach and port-forward sessions without traffic for this long. 0 disables the timeout")
+	serverCmd.Flags().Duration("proxy.sessions.maxDuration",
+		4*time.Hour,
+		"Close exec, attach and port-forward sessions open for this long. 0 disables the timeout")
+	serverCmd.Flags().Int("proxy.sessions.maxPerIdentity",
+		0,
+		"The maximum number of concurrent exec, attach and port-forward sessions per requester IAM identity, shared by the sessions of an assumed role. 0 is unlimited, the default")
+	serverCmd.Flags().String("proxy.sessions.recording.dir",
+		"",
+		"The directory where exec and attach sessions are recorded in asciicast format. Sessions are not recorded if empty")
+	serverCmd.Flags().Int("proxy.sessions.recording.maxFileSizeMB",
+		10,
+		"The size in megabytes at which a session recording continues in a new file")
+	serverCmd.Flags().Int("proxy.sessions.recording.maxTotalSizeMB",
+		1024,
+		"The size in megabytes of the recording directory above which the oldest recordings are deleted. 0 is unlimited")
+	serverCmd.Flags().String("proxy.audit.level",
+		"None",
+		"The audit level of proxied requests. Can be 'None', 'Metadata' or 'Request'. "+
+			"'Request' also records request bodies, except for Secrets")
+	serverCmd.Flags().String("proxy.audit.path",
+		"",
+		"Path of the audit log file. If not set, no audit log file is written")
+	serverCmd.Flags().Int("proxy.audit.maxSizeMB",
+		100,
+		"The size in megabytes at which the audit log file is rotated")
+	serverCmd.Flags().Int("proxy.audit.maxBackups",
+		5,
+		"The number of rotated audit log files to keep")
+	serverCmd.Flags().Bool("proxy.audit.stdout",
+		false,
+		"Write audit events to standard output")
+	serverCmd.Flags().String("metrics.bindAddr",
+		":8080",
+		"The tcp address serving prometheus metrics on /metrics. Metrics are not served if empty")
+	serverCmd.Flags().String("health.bindAddr",
+		":8081",
+		"The tcp address serving /healthz and /readyz. They are not served if empty")
 	serverCmd.Flags().String("state.baseDir",
 		state.DirSsmVault,
 		"The vault folder of ssm agent container")
 	serverCmd.Flags().String("state.secretNamePrefix",
 		"eks-connector-state",
 		"Prefix of Kubernetes Secret name used to persist eks-connector state")
+	serverCmd.Flags().String("state.secretName",
+		"",
+		"Name of Kubernetes Secret used to persist eks-connector state, instead of the prefix suffixed with the StatefulSet pod index of env POD_NAME. "+
+			"Required outside of a StatefulSet")
 	serverCmd.Flags().String("state.secretNamespace",
 		"eks-connector",
 		"Kubernetes namespace of the Secret used to persist eks-connector state")
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals start a new process of the proxy, which the listeners are handed off to.
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,8 @@
+package main
+
+import (
+	"os"
+)
+
+// upgradeSignals is empty, listeners cannot be handed off to another process on windows.
+var upgradeSignals []os.Signal
@@ -13,7 +13,7 @@ import (
 
 const (
 	// format as expected by ssm agent - https://github.com/aws/amazon-ssm-agent/blob/897de484b0bb35d6327d66d9f5d7308b3585d7cc/agent/managedInstances/registration/instance_info.go#L51
-	defaultDateStringFormat = "2006-01-02 15:04:05.999999999 -0700 MST"
+	defaultDateStringFormat = state.PrivateKeyCreatedDateFormat
 )
 
 type Registration interface {
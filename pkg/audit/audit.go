@@ -0,0 +1,164 @@
+// Package audit records structured events of the requests proxied by eks connector.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+// Level controls how much of a request is recorded.
+type Level string
+
+const (
+	// LevelNone disables auditing.
+	LevelNone Level = "None"
+	// LevelMetadata records who did what, and the outcome, without payloads.
+	LevelMetadata Level = "Metadata"
+	// LevelRequest also records the request payload, except for Secrets.
+	LevelRequest Level = "Request"
+)
+
+// RedactedObject replaces request payloads that must not be recorded.
+var RedactedObject = json.RawMessage(`"[redacted]"`)
+
+// Event is the audit record of one proxied request.
+type Event struct {
+	Timestamp time.Time `json:"timestamp"`
+	// RequestID is also sent to api server as Audit-ID, to correlate with api server audit logs.
+	RequestID string `json:"requestID"`
+	Level     Level  `json:"level"`
+
+	IAMIdentity string   `json:"iamIdentity"`
+	User        string   `json:"user,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	UserAgent   string   `json:"userAgent,omitempty"`
+
+	Verb        string `json:"verb"`
+	RequestURI  string `json:"requestURI"`
+	APIGroup    string `json:"apiGroup,omitempty"`
+	APIVersion  string `json:"apiVersion,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name,omitempty"`
+
+	ResponseCode  int     `json:"responseCode"`
+	LatencyMillis float64 `json:"latencyMillis"`
+	RequestBytes  int64   `json:"requestBytes"`
+	ResponseBytes int64   `json:"responseBytes"`
+
+	// RequestObject is only recorded at LevelRequest.
+	RequestObject json.RawMessage `json:"requestObject,omitempty"`
+}
+
+// Logger records audit events.
+type Logger interface {
+	Level() Level
+	Log(event *Event)
+	// Close flushes pending events and releases the outputs of the logger.
+	Close() error
+}
+
+// NewLogger returns a Logger writing one json event per line to the outputs of auditConfig.
+func NewLogger(auditConfig *config.AuditConfig) (Logger, error) {
+	level := Level(auditConfig.Level)
+	switch level {
+	case "", LevelNone:
+		return NewNopLogger(), nil
+	case LevelMetadata, LevelRequest:
+	default:
+		return nil, fmt.Errorf("unknown audit level %q", auditConfig.Level)
+	}
+
+	var outputs []io.Writer
+	var closers []io.Closer
+	if auditConfig.Path != "" {
+		file, err := newRotatingFile(auditConfig.Path, int64(auditConfig.MaxSizeMB)*1024*1024, auditConfig.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, file)
+		closers = append(closers, file)
+	}
+	if auditConfig.Stdout {
+		outputs = append(outputs, os.Stdout)
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("audit level %s requires a file path or stdout output", level)
+	}
+
+	klog.Infof("auditing proxied requests at level %s", level)
+	return &jsonLogger{
+		level:   level,
+		output:  io.MultiWriter(outputs...),
+		closers: closers,
+	}, nil
+}
+
+// NewNopLogger returns a Logger that records nothing.
+func NewNopLogger() Logger {
+	return &nopLogger{}
+}
+
+type nopLogger struct {
+}
+
+func (l *nopLogger) Level() Level {
+	return LevelNone
+}
+
+func (l *nopLogger) Log(event *Event) {
+}
+
+func (l *nopLogger) Close() error {
+	return nil
+}
+
+type jsonLogger struct {
+	level Level
+
+	lock    sync.Mutex
+	output  io.Writer
+	closers []io.Closer
+}
+
+func (l *jsonLogger) Level() Level {
+	return l.level
+}
+
+func (l *jsonLogger) Log(event *Event) {
+	event.Level = l.level
+	line, err := json.Marshal(event)
+	if err != nil {
+		klog.Errorf("failed to serialize audit event %s: %v", event.RequestID, err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, err = l.output.Write(line); err != nil {
+		klog.Errorf("failed to write audit event %s: %v", event.RequestID, err)
+	}
+}
+
+func (l *jsonLogger) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var firstErr error
+	for _, closer := range l.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	l.closers = nil
+	return firstErr
+}
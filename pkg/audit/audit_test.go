@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+func TestAuditSuite(t *testing.T) {
+	suite.Run(t, new(AuditSuite))
+}
+
+type AuditSuite struct {
+	suite.Suite
+
+	dir string
+}
+
+func (suite *AuditSuite) SetupTest() {
+	suite.dir = suite.T().TempDir()
+}
+
+func (suite *AuditSuite) TestNewLoggerNone() {
+	// test
+	logger, err := NewLogger(&config.AuditConfig{})
+
+	// verify
+	suite.NoError(err)
+	suite.Equal(LevelNone, logger.Level())
+}
+
+func (suite *AuditSuite) TestNewLoggerUnknownLevel() {
+	// test
+	_, err := NewLogger(&config.AuditConfig{
+		Level:  "RequestResponse",
+		Stdout: true,
+	})
+
+	// verify
+	suite.Error(err)
+}
+
+func (suite *AuditSuite) TestNewLoggerWithoutOutput() {
+	// test
+	_, err := NewLogger(&config.AuditConfig{
+		Level: string(LevelMetadata),
+	})
+
+	// verify
+	suite.Error(err)
+}
+
+func (suite *AuditSuite) TestLogToFile() {
+	// prepare
+	path := filepath.Join(suite.dir, "audit.log")
+	logger, err := NewLogger(&config.AuditConfig{
+		Level: string(LevelMetadata),
+		Path:  path,
+	})
+	suite.NoError(err)
+
+	// test
+	logger.Log(&Event{
+		Timestamp:    time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC),
+		RequestID:    "8f4d6c3e-2b1a-4e5f-9d7c-6a5b4c3d2e1f",
+		IAMIdentity:  "arn:aws:iam::123456789012:role/coder",
+		User:         "coder",
+		Groups:       []string{"developers"},
+		Verb:         "list",
+		Resource:     "pods",
+		ResponseCode: 200,
+	})
+	logger.Log(&Event{
+		RequestID:    "1b2c3d4e-5f6a-4b7c-8d9e-0f1a2b3c4d5e",
+		Verb:         "get",
+		ResponseCode: 401,
+	})
+	suite.NoError(logger.Close())
+
+	// verify
+	data, err := os.ReadFile(path)
+	suite.NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	suite.Len(lines, 2)
+	event := &Event{}
+	suite.NoError(json.Unmarshal([]byte(lines[0]), event))
+	suite.Equal(LevelMetadata, event.Level)
+	suite.Equal("coder", event.User)
+	suite.Equal([]string{"developers"}, event.Groups)
+	suite.Equal("list", event.Verb)
+	suite.Equal(200, event.ResponseCode)
+	suite.Contains(lines[1], `"responseCode":401`)
+	suite.NotContains(lines[1], `"user"`)
+}
@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestRotatingFileSuite(t *testing.T) {
+	suite.Run(t, new(RotatingFileSuite))
+}
+
+type RotatingFileSuite struct {
+	suite.Suite
+
+	path string
+}
+
+func (suite *RotatingFileSuite) SetupTest() {
+	suite.path = filepath.Join(suite.T().TempDir(), "audit.log")
+}
+
+func (suite *RotatingFileSuite) TestWriteRotates() {
+	// prepare
+	file, err := newRotatingFile(suite.path, 10, 2)
+	suite.NoError(err)
+
+	// test
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = file.Write([]byte(line))
+		suite.NoError(err)
+	}
+	suite.NoError(file.Close())
+
+	// verify
+	suite.fileContent("fourth\n", suite.path)
+	suite.fileContent("third\n", suite.path+".1")
+	suite.fileContent("second\n", suite.path+".2")
+	suite.NoFileExists(suite.path + ".3")
+}
+
+func (suite *RotatingFileSuite) TestWriteWithoutBackups() {
+	// prepare
+	file, err := newRotatingFile(suite.path, 10, 0)
+	suite.NoError(err)
+
+	// test
+	_, err = file.Write([]byte("first\n"))
+	suite.NoError(err)
+	_, err = file.Write([]byte("second\n"))
+	suite.NoError(err)
+	suite.NoError(file.Close())
+
+	// verify
+	suite.fileContent("second\n", suite.path)
+	suite.NoFileExists(suite.path + ".1")
+}
+
+func (suite *RotatingFileSuite) TestAppendsToExistingFile() {
+	// prepare
+	suite.NoError(os.WriteFile(suite.path, []byte("before restart\n"), 0600))
+	file, err := newRotatingFile(suite.path, 100, 1)
+	suite.NoError(err)
+
+	// test
+	_, err = file.Write([]byte("after restart\n"))
+	suite.NoError(err)
+	suite.NoError(file.Close())
+
+	// verify
+	suite.fileContent("before restart\nafter restart\n", suite.path)
+}
+
+func (suite *RotatingFileSuite) TestWriteAfterClose() {
+	// prepare
+	file, err := newRotatingFile(suite.path, 100, 1)
+	suite.NoError(err)
+	suite.NoError(file.Close())
+
+	// test
+	_, err = file.Write([]byte("late\n"))
+
+	// verify
+	suite.ErrorIs(err, os.ErrClosed)
+}
+
+func (suite *RotatingFileSuite) fileContent(expected string, path string) {
+	data, err := os.ReadFile(path)
+	suite.NoError(err)
+	suite.Equal(expected, string(data))
+}
@@ -1,6 +1,8 @@
 // Package config contains structs to hold eks connector configurations
 package config
 
+import "time"
+
 // Config is the whole configuration of eks-connector.
 type Config struct {
 	AgentConfig      *AgentConfig      `mapstructure:"agent"`
@@ -8,6 +10,9 @@ type Config struct {
 	WatcherConfig    *WatcherConfig    `mapstructure:"watcher"`
 	ActivationConfig *ActivationConfig `mapstructure:"activation"`
 	StateConfig      *StateConfig      `mapstructure:"state"`
+	MetricsConfig    *MetricsConfig    `mapstructure:"metrics"`
+	HealthConfig     *HealthConfig     `mapstructure:"health"`
+	ClusterConfig    *ClusterConfig    `mapstructure:"cluster"`
 }
 
 type SocketType string
@@ -15,6 +20,12 @@ type SocketType string
 const (
 	TCP  SocketType = "tcp"
 	Unix SocketType = "unix"
+	TLS  SocketType = "tls"
+	// Systemd adopts the socket passed by systemd socket activation named by the socket address,
+	// or the first one if it is empty.
+	Systemd SocketType = "systemd"
+	// FD adopts the listening socket inherited as the file descriptor number of the socket address.
+	FD SocketType = "fd"
 )
 
 // AgentConfig is the sub-configuration for ssm agent.
@@ -29,11 +40,251 @@ type AgentConfig struct {
 
 // ProxyConfig is the sub-configuration for api server proxy.
 type ProxyConfig struct {
-	SocketType    SocketType `mapstructure:"socketType"`
-	SocketAddress string     `mapstructure:"socketAddr"`
+	SocketType    SocketType       `mapstructure:"socketType"`
+	SocketAddress string           `mapstructure:"socketAddr"`
+	UnixSocket    UnixSocketConfig `mapstructure:"unixSocket"`
+	TLS           ServerTLSConfig  `mapstructure:"tls"`
+	// Listeners are served in addition to the main socket, e.g. a loopback tcp port for debugging,
+	// written <socket type>://<address>[?mode=readonly&identity=trusted].
+	Listeners []string `mapstructure:"listeners"`
 
 	TargetHost     string `mapstructure:"targetHost"`
 	TargetProtocol string `mapstructure:"targetProtocol"`
+	// TargetHosts are the api server endpoints the proxy fails over between, e.g. the control plane nodes
+	// of a cluster without load balancer. If set, it replaces TargetHost.
+	TargetHosts []string       `mapstructure:"targetHosts"`
+	Failover    FailoverConfig `mapstructure:"failover"`
+
+	Transport TransportConfig `mapstructure:"transport"`
+	Headers   HeaderConfig    `mapstructure:"headers"`
+	Identity  IdentityConfig  `mapstructure:"identity"`
+
+	// PolicyFile is the path of the authorization policy file evaluated before requests reach api server.
+	// If not set, all authorization decisions are left to kubernetes RBAC.
+	PolicyFile string `mapstructure:"policyFile"`
+
+	// Mode is readwrite or readonly.
+	Mode string `mapstructure:"mode"`
+
+	Audit AuditConfig `mapstructure:"audit"`
+
+	Limits LimitsConfig `mapstructure:"limits"`
+
+	Sessions SessionConfig `mapstructure:"sessions"`
+
+	Timeouts TimeoutsConfig `mapstructure:"timeouts"`
+
+	TokenRequest TokenRequestConfig `mapstructure:"tokenRequest"`
+}
+
+// UnixSocketConfig is the sub-configuration for the permissions of the unix socket of the proxy.
+type UnixSocketConfig struct {
+	// Mode is the octal file mode of the socket, e.g. 0770.
+	Mode string `mapstructure:"mode"`
+	// Owner and Group are the user and group, by name or numeric ID, the socket belongs to.
+	// If not set, the socket belongs to the user and group of eks connector.
+	Owner string `mapstructure:"owner"`
+	Group string `mapstructure:"group"`
+
+	// AllowedUIDs and AllowedGIDs list the users and groups of the processes allowed to connect to the socket,
+	// as reported by SO_PEERCRED. If empty, any user or group is allowed.
+	AllowedUIDs []int `mapstructure:"allowedUids"`
+	AllowedGIDs []int `mapstructure:"allowedGids"`
+	// AllowedExecutables lists the executable paths of the processes allowed to connect to the socket.
+	// If empty, any executable is allowed.
+	AllowedExecutables []string `mapstructure:"allowedExecutables"`
+}
+
+// ServerTLSConfig is the sub-configuration for the tls socket type of the proxy.
+type ServerTLSConfig struct {
+	// CertFile and KeyFile are the PEM certificate and key served by the proxy, reloaded when they change.
+	CertFile string `mapstructure:"certFile"`
+	KeyFile  string `mapstructure:"keyFile"`
+	// ClientCAFile is the PEM CA bundle verifying client certificates.
+	// If set, clients must present a certificate signed by one of its CAs.
+	ClientCAFile string `mapstructure:"clientCAFile"`
+
+	// MinVersion is 1.2 or 1.3.
+	MinVersion string `mapstructure:"minVersion"`
+	// CipherSuites are the names of the TLS 1.2 cipher suites the proxy accepts. If empty, the go defaults are used.
+	CipherSuites []string `mapstructure:"cipherSuites"`
+}
+
+// TransportConfig is the sub-configuration for the connection pool between proxy and api server.
+type TransportConfig struct {
+	// MaxIdleConns is the maximum number of idle keep-alive connections kept open to api server.
+	// Zero falls back to the net/http default.
+	MaxIdleConns int `mapstructure:"maxIdleConns"`
+
+	// IdleConnTimeout is how long an idle keep-alive connection is kept before it is closed.
+	// Zero means no limit.
+	IdleConnTimeout time.Duration `mapstructure:"idleConnTimeout"`
+
+	// TLSHandshakeTimeout is the maximum time waiting for a TLS handshake with api server.
+	// Zero means no timeout.
+	TLSHandshakeTimeout time.Duration `mapstructure:"tlsHandshakeTimeout"`
+
+	// EnableHTTP2 allows the transport to negotiate HTTP/2 with api server.
+	EnableHTTP2 bool `mapstructure:"enableHTTP2"`
+}
+
+// HeaderConfig is the sub-configuration for client headers forwarded to api server.
+// Header names are case-insensitive, a trailing "*" matches any header with the preceding prefix.
+type HeaderConfig struct {
+	// Allow lists headers forwarded to api server in addition to the default allow list.
+	Allow []string `mapstructure:"allow"`
+
+	// Deny lists headers never forwarded to api server, even if they are allowed.
+	Deny []string `mapstructure:"deny"`
+}
+
+// IdentityConfig is the sub-configuration for mapping requester IAM identities to kubernetes identities.
+type IdentityConfig struct {
+	// MappingFile is the path of a yaml file with aws-auth style mapRoles and mapUsers rules.
+	// If not set, the requester IAM identity is impersonated verbatim.
+	MappingFile string `mapstructure:"mappingFile"`
+
+	// AllowedPartitions lists the AWS partitions requester IAM identities may belong to.
+	// If empty, any partition is allowed.
+	AllowedPartitions []string `mapstructure:"allowedPartitions"`
+
+	// AllowedAccountIDs lists the AWS accounts requester IAM identities may belong to.
+	// If empty, any account is allowed.
+	AllowedAccountIDs []string `mapstructure:"allowedAccountIds"`
+
+	Signature SignatureConfig `mapstructure:"signature"`
+}
+
+// SignatureConfig is the sub-configuration for the verification of signed requester IAM identities.
+type SignatureConfig struct {
+	// Algorithm is hmac-sha256, ecdsa-sha256 or ed25519. If not set, the requester IAM identity is trusted as is.
+	Algorithm string `mapstructure:"algorithm"`
+	// KeyFile is the shared key of hmac-sha256, or the PEM public key of ecdsa-sha256 and ed25519.
+	KeyFile string `mapstructure:"keyFile"`
+	// MaxClockSkew is how far the signature timestamp may be from the proxy clock.
+	MaxClockSkew time.Duration `mapstructure:"maxClockSkew"`
+}
+
+// LimitsConfig is the sub-configuration for the rate and concurrency limits of the proxy.
+// A limit of zero disables it.
+type LimitsConfig struct {
+	// RequestsPerSecond is the sustained request rate allowed per requester IAM identity, shared by the sessions of an assumed role.
+	RequestsPerSecond float64 `mapstructure:"requestsPerSecond"`
+	// Burst is the number of requests an IAM identity may send at once above RequestsPerSecond.
+	Burst int `mapstructure:"burst"`
+
+	// MaxInFlight is the maximum number of short requests proxied concurrently.
+	MaxInFlight int `mapstructure:"maxInFlight"`
+	// MaxLongRunningInFlight is the maximum number of watch, log follow, exec, attach and port-forward
+	// requests proxied concurrently.
+	MaxLongRunningInFlight int `mapstructure:"maxLongRunningInFlight"`
+}
+
+// FailoverConfig is the sub-configuration for the failover between several api server endpoints.
+type FailoverConfig struct {
+	// Policy is roundrobin, spreading requests over the healthy endpoints,
+	// or priority, sending them to the first healthy endpoint in order.
+	Policy string `mapstructure:"policy"`
+
+	// HealthCheckInterval is how often /readyz of each endpoint is checked. 0 disables the health checks.
+	HealthCheckInterval time.Duration `mapstructure:"healthCheckInterval"`
+	HealthCheckTimeout  time.Duration `mapstructure:"healthCheckTimeout"`
+
+	// EjectionDuration is how long an endpoint that refused a connection is skipped.
+	EjectionDuration time.Duration `mapstructure:"ejectionDuration"`
+}
+
+// TokenRequestConfig is the sub-configuration for the short-lived tokens that authenticate the proxy to api server,
+// requested through the TokenRequest API.
+type TokenRequestConfig struct {
+	// ServiceAccount is the namespace/name of the service account whose tokens are requested.
+	// If not set, the proxy uses the token of the cluster credentials.
+	ServiceAccount string `mapstructure:"serviceAccount"`
+	// Audiences of the requested tokens. If not set, they have the api server audiences.
+	Audiences []string `mapstructure:"audiences"`
+	// Expiration is the requested lifetime of the tokens. api server requires at least 10 minutes.
+	Expiration time.Duration `mapstructure:"expiration"`
+}
+
+// TimeoutsConfig is the sub-configuration for the timeouts of proxied requests. A timeout of zero disables it.
+type TimeoutsConfig struct {
+	// Request bounds short requests, i.e. anything but watches, log follows and sessions.
+	Request time.Duration `mapstructure:"request"`
+	// LongRunningIdleTimeout ends watch and log follow responses without data for that long.
+	LongRunningIdleTimeout time.Duration `mapstructure:"longRunningIdleTimeout"`
+	// LongRunningMaxDuration ends watch and log follow responses open for that long.
+	LongRunningMaxDuration time.Duration `mapstructure:"longRunningMaxDuration"`
+	// Shutdown is how long in-flight requests are drained once the proxy is asked to stop,
+	// before their connections are closed. Zero closes them at once.
+	Shutdown time.Duration `mapstructure:"shutdown"`
+	// Upgrade is how long the new process of an upgrade may take to open the listeners it inherits.
+	Upgrade time.Duration `mapstructure:"upgrade"`
+}
+
+// SessionConfig is the sub-configuration for interactive sessions, i.e. exec, attach and port-forward
+// requests upgraded to SPDY or WebSocket.
+type SessionConfig struct {
+	EnableExec        bool `mapstructure:"enableExec"`
+	EnableAttach      bool `mapstructure:"enableAttach"`
+	EnablePortForward bool `mapstructure:"enablePortForward"`
+
+	// IdleTimeout closes sessions without traffic in either direction for that long. Zero disables it.
+	IdleTimeout time.Duration `mapstructure:"idleTimeout"`
+	// MaxDuration closes sessions open for that long. Zero disables it.
+	MaxDuration time.Duration `mapstructure:"maxDuration"`
+	// MaxPerIdentity is the maximum number of concurrent sessions of a requester IAM identity,
+	// shared by the sessions of an assumed role. Zero is unlimited.
+	MaxPerIdentity int `mapstructure:"maxPerIdentity"`
+
+	Recording RecordingConfig `mapstructure:"recording"`
+}
+
+// RecordingConfig is the sub-configuration for the recording of exec and attach sessions.
+type RecordingConfig struct {
+	// Dir is where session recordings are written. Recording is disabled if it is empty.
+	Dir string `mapstructure:"dir"`
+	// MaxFileSizeMB is the size at which a recording continues in a new file.
+	MaxFileSizeMB int `mapstructure:"maxFileSizeMB"`
+	// MaxTotalSizeMB is the size of Dir above which the oldest recording files are deleted. Zero is unlimited.
+	MaxTotalSizeMB int `mapstructure:"maxTotalSizeMB"`
+}
+
+// AuditConfig is the sub-configuration for the audit log of proxied requests.
+type AuditConfig struct {
+	// Level is None, Metadata or Request.
+	Level string `mapstructure:"level"`
+
+	// Path is the audit log file. If not set, no file is written.
+	Path string `mapstructure:"path"`
+	// MaxSizeMB is the size in megabytes at which the audit log file is rotated.
+	MaxSizeMB int `mapstructure:"maxSizeMB"`
+	// MaxBackups is the number of rotated audit log files kept.
+	MaxBackups int `mapstructure:"maxBackups"`
+
+	// Stdout writes audit events to standard output.
+	Stdout bool `mapstructure:"stdout"`
+}
+
+// MetricsConfig is the sub-configuration for the metrics endpoint.
+type MetricsConfig struct {
+	// BindAddress is the tcp address serving /metrics. If not set, metrics are not served.
+	BindAddress string `mapstructure:"bindAddr"`
+}
+
+// HealthConfig is the sub-configuration for the liveness and readiness endpoints.
+type HealthConfig struct {
+	// BindAddress is the tcp address serving /healthz and /readyz. If not set, they are not served.
+	BindAddress string `mapstructure:"bindAddr"`
+}
+
+// ClusterConfig is the sub-configuration for the access to the kubernetes cluster.
+type ClusterConfig struct {
+	// Kubeconfig is the kubeconfig file whose current context is the cluster to connect,
+	// for eks connector running outside the cluster. If not set, the in-cluster service account is used.
+	Kubeconfig string `mapstructure:"kubeconfig"`
+	// CredentialsDir holds the service account token and CA bundle, laid out like the automounted service account,
+	// e.g. a projected volume when automounting is disabled. If not set, the automounted service account is used.
+	CredentialsDir string `mapstructure:"credentialsDir"`
 }
 
 // WatcherConfig is the sub-configuration for ssm agent watcher.
@@ -53,6 +304,9 @@ type StateConfig struct {
 	// SecretNamePrefix is the prefix of secret name that contains EKS connector state.
 	// EKS connector Pod ordinal index in StatefulSet is appended.
 	SecretNamePrefix string `mapstructure:"secretNamePrefix"`
+	// SecretName is the name of secret that contains EKS connector state, used instead of SecretNamePrefix
+	// when EKS connector does not run in a StatefulSet, e.g. on a VM with a kubeconfig.
+	SecretName string `mapstructure:"secretName"`
 	// SecretNamespace is the namespace of secret that container EKS connector state.
 	SecretNamespace string `mapstructure:"secretNamespace"`
 }
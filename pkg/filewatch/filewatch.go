@@ -0,0 +1,71 @@
+// Package filewatch calls back on changes of local files, including files mounted from ConfigMaps and Secrets.
+package filewatch
+
+import (
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/klog/v2"
+)
+
+// DataDir is the symlink kubernetes swaps atomically when a mounted ConfigMap, Secret or projected volume is updated.
+const DataDir = "..data"
+
+const changeOps = fsnotify.Write | fsnotify.Create | fsnotify.Rename | fsnotify.Remove
+
+// WatchFiles calls onChange whenever one of files changes.
+// It watches the directories of files, which covers both plain files
+// and mounted volumes updated through the ..data symlink.
+// description names the files in logs.
+func WatchFiles(description string, files []string, onChange func()) error {
+	names := map[string]bool{DataDir: true}
+	dirs := map[string]bool{}
+	for _, file := range files {
+		names[filepath.Base(file)] = true
+		dirs[filepath.Dir(file)] = true
+	}
+	return watch(description, dirs, func(name string) bool {
+		return names[filepath.Base(name)]
+	}, onChange)
+}
+
+// WatchDir calls onChange whenever any file of dir changes.
+// description names the files in logs.
+func WatchDir(description string, dir string, onChange func()) error {
+	return watch(description, map[string]bool{dir: true}, func(string) bool {
+		return true
+	}, onChange)
+}
+
+func watch(description string, dirs map[string]bool, matches func(name string) bool, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for dir := range dirs {
+		if err = watcher.Add(dir); err != nil {
+			_ = watcher.Close()
+			return err
+		}
+	}
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&changeOps == 0 || !matches(event.Name) {
+					continue
+				}
+				onChange()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				klog.Errorf("%s watcher error: %v", description, err)
+			}
+		}
+	}()
+	return nil
+}
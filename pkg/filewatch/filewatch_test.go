@@ -0,0 +1,102 @@
+package filewatch
+
+import (
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestFileWatchSuite(t *testing.T) {
+	suite.Run(t, new(FileWatchSuite))
+}
+
+type FileWatchSuite struct {
+	suite.Suite
+
+	dirName string
+	changes int32
+}
+
+func (suite *FileWatchSuite) SetupTest() {
+	suite.dirName = suite.T().TempDir()
+	atomic.StoreInt32(&suite.changes, 0)
+}
+
+func (suite *FileWatchSuite) TestWatchFiles() {
+	// prepare
+	suite.writeFile("watched", "old")
+	suite.NoError(WatchFiles("test", []string{path.Join(suite.dirName, "watched")}, suite.onChange))
+
+	// test
+	suite.writeFile("other", "ignored")
+	suite.writeFile("watched", "new")
+
+	// verify
+	suite.Eventually(func() bool {
+		return atomic.LoadInt32(&suite.changes) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (suite *FileWatchSuite) TestWatchFilesIgnoresOtherFiles() {
+	// prepare
+	suite.NoError(WatchFiles("test", []string{path.Join(suite.dirName, "watched")}, suite.onChange))
+
+	// test
+	suite.writeFile("other", "ignored")
+
+	// verify
+	suite.Never(func() bool {
+		return atomic.LoadInt32(&suite.changes) > 0
+	}, 200*time.Millisecond, 10*time.Millisecond)
+}
+
+func (suite *FileWatchSuite) TestWatchFilesDataSymlinkSwap() {
+	// prepare
+	// lay out files like kubelet does for mounted ConfigMaps.
+	suite.NoError(os.Mkdir(path.Join(suite.dirName, "..2023_10_18_09_30_00.1"), 0700))
+	suite.NoError(os.Symlink("..2023_10_18_09_30_00.1", path.Join(suite.dirName, DataDir)))
+	suite.NoError(WatchFiles("test", []string{path.Join(suite.dirName, "watched")}, suite.onChange))
+
+	// test
+	suite.NoError(os.Mkdir(path.Join(suite.dirName, "..2023_10_18_09_40_00.2"), 0700))
+	suite.NoError(os.Symlink("..2023_10_18_09_40_00.2", path.Join(suite.dirName, "..data_tmp")))
+	suite.NoError(os.Rename(path.Join(suite.dirName, "..data_tmp"), path.Join(suite.dirName, DataDir)))
+
+	// verify
+	suite.Eventually(func() bool {
+		return atomic.LoadInt32(&suite.changes) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (suite *FileWatchSuite) TestWatchDir() {
+	// prepare
+	suite.NoError(WatchDir("test", suite.dirName, suite.onChange))
+
+	// test
+	suite.writeFile("any", "content")
+
+	// verify
+	suite.Eventually(func() bool {
+		return atomic.LoadInt32(&suite.changes) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (suite *FileWatchSuite) TestWatchMissingDir() {
+	// test
+	err := WatchDir("test", path.Join(suite.dirName, "missing"), suite.onChange)
+
+	// verify
+	suite.Error(err)
+}
+
+func (suite *FileWatchSuite) onChange() {
+	atomic.AddInt32(&suite.changes, 1)
+}
+
+func (suite *FileWatchSuite) writeFile(name, content string) {
+	suite.NoError(os.WriteFile(path.Join(suite.dirName, name), []byte(content), 0600))
+}
@@ -1,8 +1,11 @@
 package fsnotify
 
 import (
+	"context"
+	"math"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -13,6 +16,7 @@ import (
 
 	"github.com/aws/amazon-eks-connector/pkg/config"
 	"github.com/aws/amazon-eks-connector/pkg/k8s"
+	"github.com/aws/amazon-eks-connector/pkg/metrics"
 	"github.com/aws/amazon-eks-connector/pkg/state"
 )
 
@@ -32,9 +36,40 @@ var backoff = wait.Backoff{
 	Steps:    7,
 }
 
+var (
+	syncAttemptsTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_secret_sync_attempts_total",
+		Help: "Attempts to sync the ssm agent key pair to the kubernetes secret.",
+	})
+	syncFailuresTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_secret_sync_failures_total",
+		Help: "Failed attempts to sync the ssm agent key pair to the kubernetes secret.",
+	})
+
+	// initialSyncSucceeded is 1 once the first SyncSecrets of NewWatcher succeeded.
+	initialSyncSucceeded int32
+
+	// registered is 1 once the kubernetes secret holds an ssm registration.
+	// Registration happens in the init container, so its outcome is read back from the secret it persisted.
+	registered int32
+	_          = metrics.NewGaugeFunc(metrics.Opts{
+		Name: "eks_connector_registered",
+		Help: "1 if the kubernetes secret holds the ssm registration of eks connector, 0 otherwise.",
+	}, func() float64 {
+		return float64(atomic.LoadInt32(&registered))
+	})
+
+	// privateKeyCreatedUnixNano is the creation time of the last key pair read from ssm agent files, 0 if unknown.
+	privateKeyCreatedUnixNano int64
+	_                         = metrics.NewGaugeFunc(metrics.Opts{
+		Name: "eks_connector_private_key_age_seconds",
+		Help: "Age of the ssm agent private key.",
+	}, privateKeyAge)
+)
+
 // NewWatcher initiates fsWatchProvider to monitor SSM agent's key pair file
-func NewWatcher(stateConfig *config.StateConfig) error {
-	secret, err := k8s.NewSecretInCluster(stateConfig)
+func NewWatcher(clusterConfig *config.ClusterConfig, stateConfig *config.StateConfig) error {
+	secret, err := k8s.NewSecretInCluster(clusterConfig, stateConfig)
 	if err != nil {
 		return errors.Wrap(err, "could not read secrets when initializing fs watcher")
 	}
@@ -49,6 +84,14 @@ func NewWatcher(stateConfig *config.StateConfig) error {
 	return provider.watchConfig()
 }
 
+// CheckInitialSync returns an error until the initial sync of NewWatcher succeeded.
+func CheckInitialSync(ctx context.Context) error {
+	if atomic.LoadInt32(&initialSyncSucceeded) == 0 {
+		return errors.New("initial sync of K8s secrets has not succeeded yet")
+	}
+	return nil
+}
+
 // getConfigFilePath returns absolute path of RegistrationKey file
 func getConfigFilePath(baseDir string) string {
 	return path.Join(baseDir, state.FileRegistrationKey)
@@ -63,6 +106,7 @@ func (fs *fsWatchProvider) watchConfig() error {
 	if err := wait.ExponentialBackoff(backoff, fs.SyncSecrets); err != nil {
 		return errors.Wrap(err, "could not sync K8s secrets when initializing fs watcher")
 	}
+	atomic.StoreInt32(&initialSyncSucceeded, 1)
 
 	fs.viper.WatchConfig()
 	fs.viper.OnConfigChange(func(event fsnotify.Event) {
@@ -82,21 +126,30 @@ func (fs *fsWatchProvider) watchConfig() error {
 
 // SyncSecrets syncs local file content with K8s secret. Return value indicates whether ExponentialBackoff()
 // should retry the operation or not.
-func (fs *fsWatchProvider) SyncSecrets() (bool, error) {
+func (fs *fsWatchProvider) SyncSecrets() (done bool, err error) {
 	fs.Lock()
 	defer fs.Unlock()
 
+	syncAttemptsTotal.Inc()
+	defer func() {
+		if !done {
+			syncFailuresTotal.Inc()
+		}
+	}()
+
 	existingState, err := fs.secretPersistence.Load()
 	if err != nil {
 		klog.Errorf("failed to load Kubernetes secret due to %v", err)
 		return false, nil
 	}
+	observeRegistration(existingState)
 
 	newState, err := fs.fsPersistence.Load()
 	if err != nil {
 		klog.Errorf("failed to load agent's local file due to %v", err)
 		return false, nil
 	}
+	observePrivateKey(newState)
 
 	if existingState[state.FileRegistrationKey] == newState[state.FileRegistrationKey] {
 		// if K8s secrets and file content are same then don't perform any operation
@@ -110,6 +163,7 @@ func (fs *fsWatchProvider) SyncSecrets() (bool, error) {
 		klog.Errorf("failed to save secret due to %v", err)
 		return false, nil
 	}
+	observeRegistration(newState)
 	klog.Infof("Updated kubernetes secrets with new key-pair")
 
 	return true, nil
@@ -119,3 +173,34 @@ func mergeState(preexistingState, newState state.SerializedState) {
 	// inherit EksConnectorConfig content since FS persistence does not have the information.
 	newState[state.EksConnectorConfig] = preexistingState[state.EksConnectorConfig]
 }
+
+// observeRegistration records whether serializedState, as persisted in the kubernetes secret, holds an ssm registration.
+func observeRegistration(serializedState state.SerializedState) {
+	value := int32(0)
+	if agentState, err := state.Deserialize(serializedState); err == nil && agentState.InstanceID != "" && agentState.PrivateKey != "" {
+		value = 1
+	}
+	atomic.StoreInt32(&registered, value)
+}
+
+// observePrivateKey records the creation time of the key pair in serializedState for the key age metric.
+func observePrivateKey(serializedState state.SerializedState) {
+	agentState, err := state.Deserialize(serializedState)
+	if err != nil || agentState.PrivateKeyCreatedDate == "" {
+		return
+	}
+	created, err := agentState.PrivateKeyCreatedTime()
+	if err != nil {
+		klog.Warningf("failed to parse private key creation date %q: %v", agentState.PrivateKeyCreatedDate, err)
+		return
+	}
+	atomic.StoreInt64(&privateKeyCreatedUnixNano, created.UnixNano())
+}
+
+func privateKeyAge() float64 {
+	created := atomic.LoadInt64(&privateKeyCreatedUnixNano)
+	if created == 0 {
+		return math.NaN()
+	}
+	return time.Since(time.Unix(0, created)).Seconds()
+}
@@ -1,8 +1,11 @@
 package fsnotify
 
 import (
+	"context"
 	"errors"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/suite"
@@ -95,10 +98,64 @@ func (suite *FSNotifySuite) TestWatchConfigInvokesSyncSecrets() {
 
 	// verify
 	suite.NoError(actualErr)
+	suite.NoError(CheckInitialSync(context.Background()))
 	suite.fsPersistence.AssertExpectations(suite.T())
 	suite.secretPersistence.AssertExpectations(suite.T())
 }
 
+func (suite *FSNotifySuite) TestSyncSecretsObservesPrivateKeyAge() {
+	// prepare
+	created := time.Now().Add(-1 * time.Hour)
+	testState := &state.State{
+		PrivateKey:            "testPrivateKey",
+		PrivateKeyCreatedDate: created.Format(state.PrivateKeyCreatedDateFormat),
+	}
+	serializedState, err := testState.Serialize()
+	suite.NoError(err)
+	suite.secretPersistence.On("Load").Return(serializedState, nil)
+	suite.fsPersistence.On("Load").Return(serializedState, nil)
+
+	// test
+	isSuccess, _ := suite.fsNotify.SyncSecrets()
+
+	// verify
+	suite.True(isSuccess)
+	suite.InDelta(time.Hour.Seconds(), privateKeyAge(), 60)
+}
+
+func (suite *FSNotifySuite) TestSyncSecretsObservesRegistration() {
+	// prepare
+	testState := &state.State{
+		InstanceID: "mi-0123456789abcdef0",
+		PrivateKey: "testPrivateKey",
+	}
+	serializedState, err := testState.Serialize()
+	suite.NoError(err)
+	suite.secretPersistence.On("Load").Return(serializedState, nil)
+	suite.fsPersistence.On("Load").Return(serializedState, nil)
+
+	// test
+	isSuccess, _ := suite.fsNotify.SyncSecrets()
+
+	// verify
+	suite.True(isSuccess)
+	suite.Equal(int32(1), atomic.LoadInt32(&registered))
+}
+
+func (suite *FSNotifySuite) TestSyncSecretsObservesMissingRegistration() {
+	// prepare
+	atomic.StoreInt32(&registered, 1)
+	suite.secretPersistence.On("Load").Return(state.SerializedState{}, nil)
+	suite.fsPersistence.On("Load").Return(nil, errors.New("error"))
+
+	// test
+	isSuccess, _ := suite.fsNotify.SyncSecrets()
+
+	// verify
+	suite.False(isSuccess)
+	suite.Equal(int32(0), atomic.LoadInt32(&registered))
+}
+
 func getSerializedState(privateKey, activationId string) state.SerializedState {
 	testState := &state.State{
 		PrivateKey:   privateKey,
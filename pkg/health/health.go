@@ -0,0 +1,94 @@
+// Package health serves the liveness and readiness endpoints of eks connector.
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	PathHealthz = "/healthz"
+	PathReadyz  = "/readyz"
+
+	// checkTimeout bounds all checks of a probe, it should be below the probe timeout of the chart.
+	checkTimeout = 4 * time.Second
+)
+
+// Check is a named condition of the health of eks connector.
+type Check struct {
+	Name string
+	// Liveness checks fail /healthz, so that kubernetes restarts eks connector.
+	// All checks fail /readyz.
+	Liveness bool
+	Run      func(ctx context.Context) error
+}
+
+// Handler returns an http handler serving /healthz and /readyz.
+// Like api server, the result of each check is listed in the response when a check fails, or with ?verbose.
+func Handler(checks ...Check) http.Handler {
+	var liveness []Check
+	for _, check := range checks {
+		if check.Liveness {
+			liveness = append(liveness, check)
+		}
+	}
+	mux := &http.ServeMux{}
+	mux.Handle(PathHealthz, handleChecks(liveness))
+	mux.Handle(PathReadyz, handleChecks(checks))
+	return mux
+}
+
+// Serve serves Handler on listener in the background, until the returned server is closed.
+func Serve(listener net.Listener, checks ...Check) *http.Server {
+	httpServer := &http.Server{
+		ErrorLog: log.New(os.Stdout, "[HealthServer] ", 0),
+		Handler:  Handler(checks...),
+	}
+	klog.Infof("serving %s and %s on %s", PathHealthz, PathReadyz, listener.Addr())
+	go func() {
+		if err := httpServer.Serve(listener); err != http.ErrServerClosed {
+			klog.Errorf("health server exited unexpectedly: %v", err)
+		}
+	}()
+	return httpServer
+}
+
+func handleChecks(checks []Check) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+
+		var output strings.Builder
+		failed := false
+		for _, check := range checks {
+			if err := check.Run(ctx); err != nil {
+				failed = true
+				klog.Warningf("%s check %s failed: %v", req.URL.Path, check.Name, err)
+				fmt.Fprintf(&output, "[-]%s failed: %v\n", check.Name, err)
+			} else {
+				fmt.Fprintf(&output, "[+]%s ok\n", check.Name)
+			}
+		}
+
+		res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		res.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			res.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(res, "%s%s check failed\n", output.String(), req.URL.Path)
+			return
+		}
+		if _, verbose := req.URL.Query()["verbose"]; verbose {
+			fmt.Fprintf(res, "%s%s check passed\n", output.String(), req.URL.Path)
+			return
+		}
+		fmt.Fprint(res, "ok")
+	})
+}
@@ -0,0 +1,86 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestHealthSuite(t *testing.T) {
+	suite.Run(t, new(HealthSuite))
+}
+
+type HealthSuite struct {
+	suite.Suite
+
+	listenerErr error
+	upstreamErr error
+}
+
+func (suite *HealthSuite) SetupTest() {
+	suite.listenerErr = nil
+	suite.upstreamErr = nil
+}
+
+func (suite *HealthSuite) TestHealthzOk() {
+	// prepare
+	suite.upstreamErr = errors.New("connection refused")
+
+	// test
+	response := suite.get("/healthz")
+
+	// verify
+	suite.Equal(200, response.Code, "readiness checks do not fail liveness")
+	suite.Equal("ok", response.Body.String())
+}
+
+func (suite *HealthSuite) TestHealthzFailed() {
+	// prepare
+	suite.listenerErr = errors.New("proxy listener is not open")
+
+	// test
+	response := suite.get("/healthz")
+
+	// verify
+	suite.Equal(503, response.Code)
+	suite.Contains(response.Body.String(), "[-]listener failed: proxy listener is not open")
+	suite.NotContains(response.Body.String(), "apiserver")
+}
+
+func (suite *HealthSuite) TestReadyzOk() {
+	// test
+	response := suite.get("/readyz?verbose")
+
+	// verify
+	suite.Equal(200, response.Code)
+	suite.Equal("[+]listener ok\n[+]apiserver ok\n/readyz check passed\n", response.Body.String())
+}
+
+func (suite *HealthSuite) TestReadyzFailed() {
+	// prepare
+	suite.upstreamErr = errors.New("connection refused")
+
+	// test
+	response := suite.get("/readyz")
+
+	// verify
+	suite.Equal(503, response.Code)
+	suite.Equal("[+]listener ok\n[-]apiserver failed: connection refused\n/readyz check failed\n", response.Body.String())
+}
+
+func (suite *HealthSuite) get(path string) *httptest.ResponseRecorder {
+	handler := Handler(
+		Check{Name: "listener", Liveness: true, Run: func(ctx context.Context) error {
+			return suite.listenerErr
+		}},
+		Check{Name: "apiserver", Run: func(ctx context.Context) error {
+			return suite.upstreamErr
+		}},
+	)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", path, nil))
+	return response
+}
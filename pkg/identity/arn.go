@@ -0,0 +1,125 @@
+// Package identity maps IAM identities of EKS console users to Kubernetes identities.
+package identity
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	arnPrefix   = "arn"
+	arnSections = 6
+
+	PrincipalTypeUser          = "user"
+	PrincipalTypeRole          = "role"
+	PrincipalTypeAssumedRole   = "assumed-role"
+	PrincipalTypeFederatedUser = "federated-user"
+	PrincipalTypeRoot          = "root"
+)
+
+// Principal is an IAM principal parsed from its ARN.
+type Principal struct {
+	// ARN is the ARN as provided by the requester.
+	ARN string
+	// CanonicalARN is the stable ARN of the principal.
+	// For roles and assumed roles it is the ARN of the role without IAM path or the volatile session name.
+	CanonicalARN string
+
+	Partition string
+	AccountID string
+	// Type is one of the PrincipalType constants.
+	Type string
+	// Name is the user, role or federated user name, empty for the root user.
+	Name string
+	// SessionName is the role session name of an assumed role, empty otherwise.
+	SessionName string
+}
+
+// ParsePrincipal parses an IAM user, role, assumed role, federated user or root ARN.
+func ParsePrincipal(arn string) (*Principal, error) {
+	sections := strings.SplitN(arn, ":", arnSections)
+	if len(sections) != arnSections || sections[0] != arnPrefix {
+		return nil, fmt.Errorf("malformed arn %q", arn)
+	}
+	partition, service, region, accountID, resource := sections[1], sections[2], sections[3], sections[4], sections[5]
+	if partition == "" {
+		return nil, fmt.Errorf("arn %q has no partition", arn)
+	}
+	if region != "" {
+		return nil, fmt.Errorf("arn %q of an IAM principal should not have a region", arn)
+	}
+	if !isAccountID(accountID) {
+		return nil, fmt.Errorf("arn %q has invalid account id %q", arn, accountID)
+	}
+
+	principal := &Principal{
+		ARN:       arn,
+		Partition: partition,
+		AccountID: accountID,
+	}
+
+	if resource == PrincipalTypeRoot {
+		if service != "iam" {
+			return nil, fmt.Errorf("arn %q is not an IAM principal", arn)
+		}
+		principal.Type = PrincipalTypeRoot
+		principal.CanonicalARN = arn
+		return principal, nil
+	}
+
+	parts := strings.Split(resource, "/")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("arn %q has malformed resource %q", arn, resource)
+	}
+	principal.Type = parts[0]
+	for _, part := range parts[1:] {
+		if part == "" {
+			return nil, fmt.Errorf("arn %q has malformed resource %q", arn, resource)
+		}
+	}
+
+	switch {
+	case service == "iam" && principal.Type == PrincipalTypeUser:
+		// the last part is the name, the parts in between are the IAM path.
+		principal.Name = parts[len(parts)-1]
+		principal.CanonicalARN = arn
+	case service == "iam" && principal.Type == PrincipalTypeRole:
+		// assumed role ARNs do not carry the IAM path, so it is dropped to compare roles consistently.
+		principal.Name = parts[len(parts)-1]
+		principal.CanonicalARN = RoleARN(partition, accountID, principal.Name)
+	case service == "sts" && principal.Type == PrincipalTypeAssumedRole:
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("arn %q has malformed assumed role %q", arn, resource)
+		}
+		principal.Name = parts[1]
+		principal.SessionName = parts[2]
+		principal.CanonicalARN = RoleARN(partition, accountID, principal.Name)
+	case service == "sts" && principal.Type == PrincipalTypeFederatedUser:
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("arn %q has malformed federated user %q", arn, resource)
+		}
+		principal.Name = parts[1]
+		principal.CanonicalARN = arn
+	default:
+		return nil, fmt.Errorf("arn %q is not an IAM principal", arn)
+	}
+
+	return principal, nil
+}
+
+// RoleARN returns the ARN of an IAM role without path.
+func RoleARN(partition, accountID, roleName string) string {
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, accountID, roleName)
+}
+
+func isAccountID(accountID string) bool {
+	if len(accountID) != 12 {
+		return false
+	}
+	for _, c := range accountID {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package identity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestARNSuite(t *testing.T) {
+	suite.Run(t, new(ARNSuite))
+}
+
+type ARNSuite struct {
+	suite.Suite
+}
+
+func (suite *ARNSuite) TestParseAssumedRole() {
+	// test
+	principal, err := ParsePrincipal("arn:aws-cn:sts::123456789012:assumed-role/Admin/alice@example.com")
+
+	// verify
+	suite.NoError(err)
+	suite.Equal(PrincipalTypeAssumedRole, principal.Type)
+	suite.Equal("aws-cn", principal.Partition)
+	suite.Equal("123456789012", principal.AccountID)
+	suite.Equal("Admin", principal.Name)
+	suite.Equal("alice@example.com", principal.SessionName)
+	suite.Equal("arn:aws-cn:iam::123456789012:role/Admin", principal.CanonicalARN)
+}
+
+func (suite *ARNSuite) TestParseRoleWithPath() {
+	// test
+	principal, err := ParsePrincipal("arn:aws:iam::123456789012:role/teams/dev/Admin")
+
+	// verify
+	suite.NoError(err)
+	suite.Equal(PrincipalTypeRole, principal.Type)
+	suite.Equal("Admin", principal.Name)
+	suite.Equal("arn:aws:iam::123456789012:role/Admin", principal.CanonicalARN)
+}
+
+func (suite *ARNSuite) TestParseUser() {
+	// test
+	principal, err := ParsePrincipal("arn:aws:iam::123456789012:user/dev/bob")
+
+	// verify
+	suite.NoError(err)
+	suite.Equal(PrincipalTypeUser, principal.Type)
+	suite.Equal("bob", principal.Name)
+	suite.Empty(principal.SessionName)
+	suite.Equal("arn:aws:iam::123456789012:user/dev/bob", principal.CanonicalARN)
+}
+
+func (suite *ARNSuite) TestParseRootAndFederatedUser() {
+	// test
+	root, rootErr := ParsePrincipal("arn:aws:iam::123456789012:root")
+	federated, federatedErr := ParsePrincipal("arn:aws:sts::123456789012:federated-user/carol")
+
+	// verify
+	suite.NoError(rootErr)
+	suite.Equal(PrincipalTypeRoot, root.Type)
+	suite.NoError(federatedErr)
+	suite.Equal(PrincipalTypeFederatedUser, federated.Type)
+	suite.Equal("carol", federated.Name)
+}
+
+func (suite *ARNSuite) TestParseInvalid() {
+	invalidARNs := []string{
+		"",
+		"not-an-arn",
+		"arn:aws:iam:123456789012::role/coder",
+		"arn:aws:iam:us-west-2:123456789012:role/coder",
+		"arn:aws:iam::12345:role/coder",
+		"arn::iam::123456789012:role/coder",
+		"arn:aws:s3:::bucket/key",
+		"arn:aws:iam::123456789012:role/",
+		"arn:aws:iam::123456789012:group/admins",
+		"arn:aws:sts::123456789012:assumed-role/Admin",
+		"arn:aws:sts::123456789012:root",
+	}
+	for _, arn := range invalidARNs {
+		_, err := ParsePrincipal(arn)
+		suite.Error(err, arn)
+	}
+}
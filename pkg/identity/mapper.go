@@ -0,0 +1,185 @@
+package identity
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// Extra keys attached to every mapped identity, impersonated as Impersonate-Extra-* headers.
+	ExtraARN          = "arn"
+	ExtraCanonicalARN = "canonicalarn"
+	ExtraSessionName  = "sessionname"
+	ExtraAccountID    = "accountid"
+
+	// Placeholders that can be used in username templates.
+	TemplateAccountID   = "{{AccountID}}"
+	TemplateSessionName = "{{SessionName}}"
+)
+
+// Identity is the Kubernetes identity impersonated on behalf of an IAM principal.
+type Identity struct {
+	Username string
+	Groups   []string
+	Extra    map[string][]string
+}
+
+// Mapper maps the IAM identity ARN of a requester to a Kubernetes identity.
+type Mapper interface {
+	Map(arn string) (*Identity, error)
+}
+
+// NewPassthroughMapper returns a Mapper that impersonates the IAM identity ARN verbatim as username.
+func NewPassthroughMapper() Mapper {
+	return &passthroughMapper{}
+}
+
+type passthroughMapper struct {
+}
+
+func (m *passthroughMapper) Map(arn string) (*Identity, error) {
+	return &Identity{
+		Username: arn,
+	}, nil
+}
+
+// MappingConfig is the content of an identity mapping file.
+// It follows the mapRoles and mapUsers format of the aws-auth ConfigMap.
+type MappingConfig struct {
+	MapRoles []RoleMapping `json:"mapRoles"`
+	MapUsers []UserMapping `json:"mapUsers"`
+}
+
+// RoleMapping maps an IAM role, and all sessions assuming it, to a Kubernetes identity.
+type RoleMapping struct {
+	RoleARN  string   `json:"rolearn"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+// UserMapping maps an IAM user to a Kubernetes identity.
+type UserMapping struct {
+	UserARN  string   `json:"userarn"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+// NewMapperFromFile returns a Mapper configured by the yaml or json mapping file at filePath,
+// typically mounted from a ConfigMap.
+func NewMapperFromFile(filePath string) (Mapper, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	mappingConfig := &MappingConfig{}
+	if err = yaml.UnmarshalStrict(data, mappingConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse identity mapping file %s: %w", filePath, err)
+	}
+	return NewMapper(mappingConfig)
+}
+
+// NewMapper returns a Mapper that applies the rules of mappingConfig.
+// IAM principals not matching any rule are impersonated by their canonical ARN without groups.
+func NewMapper(mappingConfig *MappingConfig) (Mapper, error) {
+	mapper := &ruleMapper{
+		rules: map[string]*mappingRule{},
+	}
+	for _, roleMapping := range mappingConfig.MapRoles {
+		if err := mapper.add(roleMapping.RoleARN, PrincipalTypeRole, roleMapping.Username, roleMapping.Groups); err != nil {
+			return nil, err
+		}
+	}
+	for _, userMapping := range mappingConfig.MapUsers {
+		if err := mapper.add(userMapping.UserARN, PrincipalTypeUser, userMapping.Username, userMapping.Groups); err != nil {
+			return nil, err
+		}
+	}
+	return mapper, nil
+}
+
+type mappingRule struct {
+	username string
+	groups   []string
+}
+
+// ruleMapper indexes mapping rules by lower-cased canonical ARN.
+type ruleMapper struct {
+	rules map[string]*mappingRule
+}
+
+func (m *ruleMapper) add(arn, principalType, username string, groups []string) error {
+	principal, err := ParsePrincipal(arn)
+	if err != nil {
+		return fmt.Errorf("invalid identity mapping: %w", err)
+	}
+	if principal.Type != principalType {
+		return fmt.Errorf("invalid identity mapping: arn %q is not an IAM %s", arn, principalType)
+	}
+	if err = validateUsername(username); err != nil {
+		return fmt.Errorf("invalid identity mapping for %q: %w", arn, err)
+	}
+	key := strings.ToLower(principal.CanonicalARN)
+	if _, exists := m.rules[key]; exists {
+		return fmt.Errorf("invalid identity mapping: duplicated mapping for %q", arn)
+	}
+	m.rules[key] = &mappingRule{
+		username: username,
+		groups:   groups,
+	}
+	return nil
+}
+
+func (m *ruleMapper) Map(arn string) (*Identity, error) {
+	principal, err := ParsePrincipal(arn)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Username: principal.CanonicalARN,
+		Extra: map[string][]string{
+			ExtraARN:          {principal.ARN},
+			ExtraCanonicalARN: {principal.CanonicalARN},
+			ExtraAccountID:    {principal.AccountID},
+		},
+	}
+	if principal.SessionName != "" {
+		identity.Extra[ExtraSessionName] = []string{principal.SessionName}
+	}
+
+	rule, found := m.rules[strings.ToLower(principal.CanonicalARN)]
+	if !found {
+		return identity, nil
+	}
+	if rule.username != "" {
+		identity.Username, err = renderUsername(rule.username, principal)
+		if err != nil {
+			return nil, err
+		}
+	}
+	identity.Groups = rule.groups
+	return identity, nil
+}
+
+// renderUsername replaces the placeholders of a username template with attributes of principal.
+func renderUsername(template string, principal *Principal) (string, error) {
+	if strings.Contains(template, TemplateSessionName) && principal.SessionName == "" {
+		return "", fmt.Errorf("username template %q requires a session name but arn %q has none", template, principal.ARN)
+	}
+	return strings.NewReplacer(
+		TemplateAccountID, principal.AccountID,
+		TemplateSessionName, principal.SessionName,
+	).Replace(template), nil
+}
+
+// validateUsername returns an error if a username template has unknown placeholders.
+func validateUsername(template string) error {
+	stripped := strings.NewReplacer(TemplateAccountID, "", TemplateSessionName, "").Replace(template)
+	if strings.Contains(stripped, "{{") {
+		return fmt.Errorf("username template %q has unknown placeholders", template)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package identity
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	testMappingFile = `
+mapRoles:
+  - rolearn: arn:aws:iam::123456789012:role/platform/Admin
+    username: admin:{{SessionName}}
+    groups:
+      - system:masters
+  - rolearn: arn:aws:iam::123456789012:role/Viewer
+    groups:
+      - viewers
+mapUsers:
+  - userarn: arn:aws:iam::123456789012:user/bob
+    username: bob-{{AccountID}}
+    groups:
+      - developers
+`
+)
+
+func TestMapperSuite(t *testing.T) {
+	suite.Run(t, new(MapperSuite))
+}
+
+type MapperSuite struct {
+	suite.Suite
+
+	dirName string
+	mapper  Mapper
+}
+
+func (suite *MapperSuite) SetupTest() {
+	dir, err := os.MkdirTemp("", "eks_connector_identity")
+	suite.NoError(err)
+	suite.dirName = dir
+
+	filePath := path.Join(suite.dirName, "mapping.yaml")
+	suite.NoError(os.WriteFile(filePath, []byte(testMappingFile), 0600))
+	suite.mapper, err = NewMapperFromFile(filePath)
+	suite.NoError(err)
+}
+
+func (suite *MapperSuite) TearDownTest() {
+	suite.NoError(os.RemoveAll(suite.dirName))
+}
+
+func (suite *MapperSuite) TestMapAssumedRoleWithTemplate() {
+	// test
+	identity, err := suite.mapper.Map("arn:aws:sts::123456789012:assumed-role/Admin/alice")
+
+	// verify
+	suite.NoError(err)
+	suite.Equal("admin:alice", identity.Username)
+	suite.Equal([]string{"system:masters"}, identity.Groups)
+	suite.Equal(map[string][]string{
+		ExtraARN:          {"arn:aws:sts::123456789012:assumed-role/Admin/alice"},
+		ExtraCanonicalARN: {"arn:aws:iam::123456789012:role/Admin"},
+		ExtraAccountID:    {"123456789012"},
+		ExtraSessionName:  {"alice"},
+	}, identity.Extra)
+}
+
+func (suite *MapperSuite) TestMapAssumedRoleWithoutUsername() {
+	// test
+	identity, err := suite.mapper.Map("arn:aws:sts::123456789012:assumed-role/viewer/bob")
+
+	// verify
+	suite.NoError(err)
+	suite.Equal("arn:aws:iam::123456789012:role/viewer", identity.Username, "role arn is matched case-insensitively")
+	suite.Equal([]string{"viewers"}, identity.Groups)
+}
+
+func (suite *MapperSuite) TestMapUser() {
+	// test
+	identity, err := suite.mapper.Map("arn:aws:iam::123456789012:user/bob")
+
+	// verify
+	suite.NoError(err)
+	suite.Equal("bob-123456789012", identity.Username)
+	suite.Equal([]string{"developers"}, identity.Groups)
+	suite.NotContains(identity.Extra, ExtraSessionName)
+}
+
+func (suite *MapperSuite) TestMapUnmatched() {
+	// test
+	identity, err := suite.mapper.Map("arn:aws:sts::210987654321:assumed-role/Admin/alice")
+
+	// verify
+	suite.NoError(err)
+	suite.Equal("arn:aws:iam::210987654321:role/Admin", identity.Username)
+	suite.Empty(identity.Groups)
+}
+
+func (suite *MapperSuite) TestMapTemplateWithoutSession() {
+	// test
+	_, err := suite.mapper.Map("arn:aws:iam::123456789012:role/Admin")
+
+	// verify
+	suite.Error(err)
+}
+
+func (suite *MapperSuite) TestMapInvalidARN() {
+	// test
+	_, err := suite.mapper.Map("")
+
+	// verify
+	suite.Error(err)
+}
+
+func (suite *MapperSuite) TestNewMapperInvalidConfig() {
+	_, err := NewMapper(&MappingConfig{
+		MapRoles: []RoleMapping{{RoleARN: "arn:aws:iam::123456789012:user/bob"}},
+	})
+	suite.Error(err, "user arn in mapRoles")
+
+	_, err = NewMapper(&MappingConfig{
+		MapRoles: []RoleMapping{
+			{RoleARN: "arn:aws:iam::123456789012:role/Admin"},
+			{RoleARN: "arn:aws:iam::123456789012:role/path/Admin"},
+		},
+	})
+	suite.Error(err, "duplicated role")
+
+	_, err = NewMapper(&MappingConfig{
+		MapUsers: []UserMapping{{UserARN: "arn:aws:iam::123456789012:user/bob", Username: "{{Unknown}}"}},
+	})
+	suite.Error(err, "unknown template placeholder")
+}
+
+func (suite *MapperSuite) TestPassthroughMapper() {
+	// test
+	identity, err := NewPassthroughMapper().Map("arn:aws:sts::123456789012:assumed-role/Admin/alice")
+
+	// verify
+	suite.NoError(err)
+	suite.Equal("arn:aws:sts::123456789012:assumed-role/Admin/alice", identity.Username)
+	suite.Empty(identity.Groups)
+	suite.Empty(identity.Extra)
+}
@@ -0,0 +1,60 @@
+package identity
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+var (
+	// ErrMissing is returned when the requester has no IAM identity.
+	ErrMissing = errors.New("missing IAM identity")
+	// ErrNotAllowed is returned when the requester IAM identity is well-formed but not allowed by configuration.
+	ErrNotAllowed = errors.New("IAM identity is not allowed")
+)
+
+// Validator validates the IAM identity ARN of a requester.
+type Validator interface {
+	Validate(arn string) (*Principal, error)
+}
+
+// NewValidator returns a Validator that accepts IAM principals
+// of the partitions and accounts allowed by identityConfig.
+// Empty allow lists accept any partition or account.
+func NewValidator(identityConfig *config.IdentityConfig) Validator {
+	return &principalValidator{
+		allowedPartitions: toSet(identityConfig.AllowedPartitions),
+		allowedAccountIDs: toSet(identityConfig.AllowedAccountIDs),
+	}
+}
+
+type principalValidator struct {
+	allowedPartitions map[string]bool
+	allowedAccountIDs map[string]bool
+}
+
+func (v *principalValidator) Validate(arn string) (*Principal, error) {
+	if arn == "" {
+		return nil, ErrMissing
+	}
+	principal, err := ParsePrincipal(arn)
+	if err != nil {
+		return nil, err
+	}
+	if len(v.allowedPartitions) > 0 && !v.allowedPartitions[principal.Partition] {
+		return nil, fmt.Errorf("%w: partition %s of %s is not allowed", ErrNotAllowed, principal.Partition, arn)
+	}
+	if len(v.allowedAccountIDs) > 0 && !v.allowedAccountIDs[principal.AccountID] {
+		return nil, fmt.Errorf("%w: account %s of %s is not allowed", ErrNotAllowed, principal.AccountID, arn)
+	}
+	return principal, nil
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
@@ -0,0 +1,71 @@
+package identity
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+func TestValidatorSuite(t *testing.T) {
+	suite.Run(t, new(ValidatorSuite))
+}
+
+type ValidatorSuite struct {
+	suite.Suite
+}
+
+func (suite *ValidatorSuite) TestValidateAnyPartitionAndAccount() {
+	// prepare
+	validator := NewValidator(&config.IdentityConfig{})
+
+	// test
+	principal, err := validator.Validate("arn:aws-us-gov:sts::123456789012:assumed-role/Admin/alice")
+
+	// verify
+	suite.NoError(err)
+	suite.Equal("aws-us-gov", principal.Partition)
+}
+
+func (suite *ValidatorSuite) TestValidateMissing() {
+	// prepare
+	validator := NewValidator(&config.IdentityConfig{})
+
+	// test
+	_, err := validator.Validate("")
+
+	// verify
+	suite.True(errors.Is(err, ErrMissing))
+}
+
+func (suite *ValidatorSuite) TestValidateMalformed() {
+	// prepare
+	validator := NewValidator(&config.IdentityConfig{})
+
+	// test
+	_, err := validator.Validate("arn:aws:iam:123456789012::role/coder")
+
+	// verify
+	suite.Error(err)
+	suite.False(errors.Is(err, ErrNotAllowed))
+}
+
+func (suite *ValidatorSuite) TestValidateAllowLists() {
+	// prepare
+	validator := NewValidator(&config.IdentityConfig{
+		AllowedPartitions: []string{"aws"},
+		AllowedAccountIDs: []string{"123456789012", "210987654321"},
+	})
+
+	// test
+	_, allowedErr := validator.Validate("arn:aws:iam::210987654321:user/bob")
+	_, partitionErr := validator.Validate("arn:aws-cn:iam::123456789012:user/bob")
+	_, accountErr := validator.Validate("arn:aws:iam::111122223333:user/bob")
+
+	// verify
+	suite.NoError(allowedErr)
+	suite.True(errors.Is(partitionErr, ErrNotAllowed))
+	suite.True(errors.Is(accountErr, ErrNotAllowed))
+}
@@ -4,6 +4,9 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
 
 	coreV1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -12,6 +15,8 @@ import (
 	"k8s.io/client-go/rest"
 
 	"github.com/aws/amazon-eks-connector/pkg/config"
+	"github.com/aws/amazon-eks-connector/pkg/kubeconfig"
+	"github.com/aws/amazon-eks-connector/pkg/serviceaccount"
 )
 
 type Secret interface {
@@ -21,25 +26,80 @@ type Secret interface {
 
 // NewSecretInCluster creates a Secret that is suitable for eks-connector pods
 // based on stateConfig.
-// The secret will be accessed using in-cluster Kubernetes credentials
-// and suffixed with pod ordinal index in StatefulSet to avoid conflicts.
-func NewSecretInCluster(stateConfig *config.StateConfig) (Secret, error) {
-	config, err := rest.InClusterConfig()
+// The secret will be accessed using in-cluster Kubernetes credentials,
+// or the credentials of the kubeconfig of clusterConfig if set,
+// and suffixed with pod ordinal index in StatefulSet to avoid conflicts,
+// unless stateConfig names the secret, e.g. outside of a StatefulSet.
+func NewSecretInCluster(clusterConfig *config.ClusterConfig, stateConfig *config.StateConfig) (Secret, error) {
+	k8sClient, err := NewClientset(clusterConfig)
 	if err != nil {
 		return nil, err
 	}
-	k8sClient, err := kubernetes.NewForConfig(config)
+	secretName, err := stateSecretName(stateConfig, NewPodIndexProvider())
 	if err != nil {
 		return nil, err
 	}
-	podIndexProvider := NewPodIndexProvider()
+
+	return NewSecret(secretName, stateConfig.SecretNamespace, k8sClient), nil
+}
+
+func stateSecretName(stateConfig *config.StateConfig, podIndexProvider PodIndexProvider) (string, error) {
+	if stateConfig.SecretName != "" {
+		return stateConfig.SecretName, nil
+	}
 	podIndex, err := podIndexProvider.Get()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s", stateConfig.SecretNamePrefix, podIndex), nil
+}
+
+// NewClientset creates a kubernetes client of the cluster, with in-cluster Kubernetes credentials
+// or the credentials of the kubeconfig or the credentials directory of clusterConfig if set.
+func NewClientset(clusterConfig *config.ClusterConfig) (kubernetes.Interface, error) {
+	restConfig, err := newRestConfig(clusterConfig)
 	if err != nil {
 		return nil, err
 	}
-	secretName := fmt.Sprintf("%s-%s", stateConfig.SecretNamePrefix, podIndex)
+	return kubernetes.NewForConfig(restConfig)
+}
 
-	return NewSecret(secretName, stateConfig.SecretNamespace, k8sClient), nil
+func newRestConfig(clusterConfig *config.ClusterConfig) (*rest.Config, error) {
+	if clusterConfig == nil {
+		return rest.InClusterConfig()
+	}
+	if clusterConfig.Kubeconfig != "" {
+		clusterKubeconfig, err := kubeconfig.Load(clusterConfig.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		return clusterKubeconfig.RestConfig(), nil
+	}
+	if clusterConfig.CredentialsDir != "" {
+		return newCredentialsDirRestConfig(clusterConfig.CredentialsDir)
+	}
+	return rest.InClusterConfig()
+}
+
+// newCredentialsDirRestConfig returns the in-cluster config of rest.InClusterConfig,
+// authenticated with the token and CA bundle of credentialsDir instead of the automounted service account.
+// The token file is read again as it rotates.
+func newCredentialsDirRestConfig(credentialsDir string) (*rest.Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, rest.ErrNotInCluster
+	}
+	tokenFile := filepath.Join(credentialsDir, serviceaccount.FileToken)
+	if _, err := os.Stat(tokenFile); err != nil {
+		return nil, err
+	}
+	return &rest.Config{
+		Host: "https://" + net.JoinHostPort(host, port),
+		TLSClientConfig: rest.TLSClientConfig{
+			CAFile: filepath.Join(credentialsDir, serviceaccount.FileRootCAs),
+		},
+		BearerTokenFile: tokenFile,
+	}, nil
 }
 
 func NewSecret(name, namespace string, clientset kubernetes.Interface) Secret {
@@ -1,11 +1,15 @@
 package k8s
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
 	"k8s.io/client-go/kubernetes/fake"
 	k8sTesting "k8s.io/client-go/testing"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
 )
 
 const (
@@ -89,6 +93,64 @@ func (suite *SecretSuite) TestSetPreexistingSecret() {
 	suite.assertSecretAPICall(actions[4], "get")
 }
 
+func (suite *SecretSuite) TestRestConfigCredentialsDir() {
+	// prepare
+	suite.T().Setenv("KUBERNETES_SERVICE_HOST", "10.100.0.1")
+	suite.T().Setenv("KUBERNETES_SERVICE_PORT", "443")
+	credentialsDir := suite.T().TempDir()
+	suite.NoError(os.WriteFile(filepath.Join(credentialsDir, "token"), []byte("projected"), 0600))
+
+	// test
+	restConfig, err := newRestConfig(&config.ClusterConfig{CredentialsDir: credentialsDir})
+
+	// verify
+	suite.NoError(err)
+	suite.Equal("https://10.100.0.1:443", restConfig.Host)
+	suite.Equal(filepath.Join(credentialsDir, "token"), restConfig.BearerTokenFile)
+	suite.Equal(filepath.Join(credentialsDir, "ca.crt"), restConfig.TLSClientConfig.CAFile)
+}
+
+func (suite *SecretSuite) TestRestConfigCredentialsDirWithoutToken() {
+	// prepare
+	suite.T().Setenv("KUBERNETES_SERVICE_HOST", "10.100.0.1")
+	suite.T().Setenv("KUBERNETES_SERVICE_PORT", "443")
+
+	// test
+	_, err := newRestConfig(&config.ClusterConfig{CredentialsDir: suite.T().TempDir()})
+
+	// verify
+	suite.Error(err)
+}
+
+func (suite *SecretSuite) TestStateSecretNameFromPodIndex() {
+	// prepare
+	podIndexProvider := &MockPodIndexProvider{}
+	podIndexProvider.On("Get").Return("1", nil)
+
+	// test
+	secretName, err := stateSecretName(&config.StateConfig{SecretNamePrefix: "eks-connector-state"}, podIndexProvider)
+
+	// verify
+	suite.NoError(err)
+	suite.Equal("eks-connector-state-1", secretName)
+}
+
+func (suite *SecretSuite) TestStateSecretNameConfigured() {
+	// prepare
+	podIndexProvider := &MockPodIndexProvider{}
+
+	// test
+	secretName, err := stateSecretName(&config.StateConfig{
+		SecretNamePrefix: "eks-connector-state",
+		SecretName:       "eks-connector-state-vm",
+	}, podIndexProvider)
+
+	// verify
+	suite.NoError(err)
+	suite.Equal("eks-connector-state-vm", secretName)
+	podIndexProvider.AssertNotCalled(suite.T(), "Get")
+}
+
 func (suite *SecretSuite) assertSecretAPICall(action k8sTesting.Action, verb string) {
 	suite.Equal(testSecretNamespace, action.GetNamespace())
 	suite.Equal("secrets", action.GetResource().Resource)
@@ -0,0 +1,220 @@
+// Package kubeconfig loads the api server and credentials of the current context of a kubeconfig file,
+// so that eks connector can run outside the cluster it connects to.
+package kubeconfig
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+// Kubeconfig is the cluster and user of the current context of a kubeconfig file,
+// with the files it references already read.
+type Kubeconfig struct {
+	// Server is the url of api server.
+	Server string
+	// ServerName is the name verified in the api server certificate instead of the server host, if set.
+	ServerName string
+	// CAData is the PEM bundle of the certificate authorities of api server.
+	// If empty, api server certificate is verified with the system roots.
+	CAData []byte
+
+	// CertData and KeyData are the PEM client certificate and key, if the user authenticates with a certificate.
+	CertData []byte
+	KeyData  []byte
+	// Token is the bearer token of the user, read from TokenFile if set.
+	Token     string
+	TokenFile string
+}
+
+// file is the subset of the kubeconfig format that eks connector supports.
+type file struct {
+	CurrentContext string `json:"current-context"`
+	Clusters       []struct {
+		Name    string  `json:"name"`
+		Cluster cluster `json:"cluster"`
+	} `json:"clusters"`
+	Contexts []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster string `json:"cluster"`
+			User    string `json:"user"`
+		} `json:"context"`
+	} `json:"contexts"`
+	Users []struct {
+		Name string `json:"name"`
+		User user   `json:"user"`
+	} `json:"users"`
+}
+
+type cluster struct {
+	Server                   string `json:"server"`
+	TLSServerName            string `json:"tls-server-name"`
+	CertificateAuthority     string `json:"certificate-authority"`
+	CertificateAuthorityData []byte `json:"certificate-authority-data"`
+	InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+}
+
+type user struct {
+	ClientCertificate     string `json:"client-certificate"`
+	ClientCertificateData []byte `json:"client-certificate-data"`
+	ClientKey             string `json:"client-key"`
+	ClientKeyData         []byte `json:"client-key-data"`
+	Token                 string `json:"token"`
+	TokenFile             string `json:"tokenFile"`
+
+	// credential plugins are not supported, they are only decoded to be rejected.
+	Exec         interface{} `json:"exec"`
+	AuthProvider interface{} `json:"auth-provider"`
+}
+
+// Load reads the current context of the kubeconfig file at path.
+// Relative file paths in the kubeconfig are relative to its directory, like for kubectl.
+func Load(path string) (*Kubeconfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	kubeconfigFile := &file{}
+	if err = yaml.Unmarshal(data, kubeconfigFile); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig %s: %w", path, err)
+	}
+	clusterName, userName, err := kubeconfigFile.currentContext()
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig %s: %w", path, err)
+	}
+	kubeconfig := &Kubeconfig{}
+	dir := filepath.Dir(path)
+	if err = kubeconfig.loadCluster(kubeconfigFile, clusterName, dir); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig %s: %w", path, err)
+	}
+	if err = kubeconfig.loadUser(kubeconfigFile, userName, dir); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig %s: %w", path, err)
+	}
+	return kubeconfig, nil
+}
+
+func (f *file) currentContext() (string, string, error) {
+	if f.CurrentContext == "" {
+		return "", "", errors.New("current-context is not set")
+	}
+	for _, context := range f.Contexts {
+		if context.Name == f.CurrentContext {
+			return context.Context.Cluster, context.Context.User, nil
+		}
+	}
+	return "", "", fmt.Errorf("context %q not found", f.CurrentContext)
+}
+
+func (k *Kubeconfig) loadCluster(f *file, name, dir string) error {
+	for _, namedCluster := range f.Clusters {
+		if namedCluster.Name != name {
+			continue
+		}
+		cluster := namedCluster.Cluster
+		if cluster.InsecureSkipTLSVerify {
+			return fmt.Errorf("cluster %q: insecure-skip-tls-verify is not supported", name)
+		}
+		k.Server = cluster.Server
+		k.ServerName = cluster.TLSServerName
+		k.CAData = cluster.CertificateAuthorityData
+		if cluster.CertificateAuthority != "" {
+			data, err := os.ReadFile(resolve(dir, cluster.CertificateAuthority))
+			if err != nil {
+				return err
+			}
+			k.CAData = data
+		}
+		_, _, err := k.Target()
+		return err
+	}
+	return fmt.Errorf("cluster %q not found", name)
+}
+
+func (k *Kubeconfig) loadUser(f *file, name, dir string) error {
+	for _, namedUser := range f.Users {
+		if namedUser.Name != name {
+			continue
+		}
+		user := namedUser.User
+		if user.Exec != nil || user.AuthProvider != nil {
+			return fmt.Errorf("user %q: exec and auth-provider credential plugins are not supported", name)
+		}
+		var err error
+		if k.CertData, err = readData(user.ClientCertificateData, dir, user.ClientCertificate); err != nil {
+			return err
+		}
+		if k.KeyData, err = readData(user.ClientKeyData, dir, user.ClientKey); err != nil {
+			return err
+		}
+		if (len(k.CertData) == 0) != (len(k.KeyData) == 0) {
+			return fmt.Errorf("user %q: client certificate and key must be set together", name)
+		}
+		k.Token = user.Token
+		if user.TokenFile != "" {
+			k.TokenFile = resolve(dir, user.TokenFile)
+			token, err := os.ReadFile(k.TokenFile)
+			if err != nil {
+				return err
+			}
+			k.Token = strings.TrimSpace(string(token))
+		}
+		if k.Token == "" && len(k.CertData) == 0 {
+			return fmt.Errorf("user %q has neither a token nor a client certificate", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("user %q not found", name)
+}
+
+// Target returns the protocol and host of api server.
+func (k *Kubeconfig) Target() (string, string, error) {
+	server, err := url.Parse(k.Server)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid server %q: %w", k.Server, err)
+	}
+	if (server.Scheme != "https" && server.Scheme != "http") || server.Host == "" {
+		return "", "", fmt.Errorf("invalid server %q, expected https://host[:port]", k.Server)
+	}
+	if strings.Trim(server.Path, "/") != "" {
+		return "", "", fmt.Errorf("server %q with a path is not supported", k.Server)
+	}
+	return server.Scheme, server.Host, nil
+}
+
+// RestConfig returns the client configuration of api server.
+// The token file, if any, is read again by the client as it is rotated.
+func (k *Kubeconfig) RestConfig() *rest.Config {
+	return &rest.Config{
+		Host:            k.Server,
+		BearerToken:     k.Token,
+		BearerTokenFile: k.TokenFile,
+		TLSClientConfig: rest.TLSClientConfig{
+			ServerName: k.ServerName,
+			CAData:     k.CAData,
+			CertData:   k.CertData,
+			KeyData:    k.KeyData,
+		},
+	}
+}
+
+// readData returns data if set, else the content of the file at path, if set.
+func readData(data []byte, dir, path string) ([]byte, error) {
+	if len(data) > 0 || path == "" {
+		return data, nil
+	}
+	return os.ReadFile(resolve(dir, path))
+}
+
+func resolve(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
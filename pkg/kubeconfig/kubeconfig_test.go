@@ -0,0 +1,160 @@
+package kubeconfig
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	testToken = "0npW4ZyoquYJUtW6b9td"
+	testCA    = "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"
+)
+
+func TestKubeconfigSuite(t *testing.T) {
+	suite.Run(t, new(KubeconfigSuite))
+}
+
+type KubeconfigSuite struct {
+	suite.Suite
+
+	dir string
+}
+
+func (suite *KubeconfigSuite) SetupTest() {
+	suite.dir = suite.T().TempDir()
+}
+
+func (suite *KubeconfigSuite) TestLoadTokenFile() {
+	// prepare
+	suite.writeFile("ca.crt", testCA)
+	suite.writeFile("token", testToken+"\n")
+	path := suite.writeFile("config", `
+apiVersion: v1
+kind: Config
+current-context: bastion
+contexts:
+- name: other
+  context:
+    cluster: other
+    user: other
+- name: bastion
+  context:
+    cluster: air-gapped
+    user: eks-connector
+clusters:
+- name: air-gapped
+  cluster:
+    server: https://10.0.0.1:6443
+    certificate-authority: ca.crt
+    tls-server-name: kubernetes.internal
+users:
+- name: eks-connector
+  user:
+    tokenFile: token
+`)
+
+	// test
+	kubeconfig, err := Load(path)
+
+	// verify
+	suite.Require().NoError(err)
+	suite.Equal("https://10.0.0.1:6443", kubeconfig.Server)
+	suite.Equal("kubernetes.internal", kubeconfig.ServerName)
+	suite.Equal(testCA, string(kubeconfig.CAData), "relative paths are relative to the kubeconfig")
+	suite.Equal(testToken, kubeconfig.Token)
+	suite.Equal(filepath.Join(suite.dir, "token"), kubeconfig.TokenFile)
+	protocol, host, err := kubeconfig.Target()
+	suite.NoError(err)
+	suite.Equal("https", protocol)
+	suite.Equal("10.0.0.1:6443", host)
+	restConfig := kubeconfig.RestConfig()
+	suite.Equal("https://10.0.0.1:6443", restConfig.Host)
+	suite.Equal(kubeconfig.TokenFile, restConfig.BearerTokenFile)
+	suite.Equal("kubernetes.internal", restConfig.TLSClientConfig.ServerName)
+}
+
+func (suite *KubeconfigSuite) TestLoadClientCertificateData() {
+	// prepare
+	path := suite.writeKubeconfig("server: https://10.0.0.1:6443", `
+    client-certificate-data: `+base64.StdEncoding.EncodeToString([]byte("cert"))+`
+    client-key-data: `+base64.StdEncoding.EncodeToString([]byte("key")))
+
+	// test
+	kubeconfig, err := Load(path)
+
+	// verify
+	suite.Require().NoError(err)
+	suite.Equal("cert", string(kubeconfig.CertData))
+	suite.Equal("key", string(kubeconfig.KeyData))
+	suite.Empty(kubeconfig.Token)
+	suite.Empty(kubeconfig.CAData, "the system roots verify api server")
+}
+
+func (suite *KubeconfigSuite) TestLoadInvalid() {
+	testCases := map[string]string{
+		"server with a path":      suite.writeKubeconfig("server: https://rancher.example.com/k8s/clusters/c-1", "token: "+testToken),
+		"server without scheme":   suite.writeKubeconfig("server: 10.0.0.1:6443", "token: "+testToken),
+		"insecure":                suite.writeKubeconfig("server: https://10.0.0.1:6443\n    insecure-skip-tls-verify: true", "token: "+testToken),
+		"exec plugin":             suite.writeKubeconfig("server: https://10.0.0.1:6443", "exec:\n      command: aws"),
+		"no credentials":          suite.writeKubeconfig("server: https://10.0.0.1:6443", "username: admin"),
+		"certificate without key": suite.writeKubeconfig("server: https://10.0.0.1:6443", "client-certificate: client.crt"),
+		"missing file":            filepath.Join(suite.dir, "missing"),
+	}
+
+	for name, path := range testCases {
+		// test
+		_, err := Load(path)
+
+		// verify
+		suite.Error(err, name)
+	}
+}
+
+func (suite *KubeconfigSuite) TestLoadMissingContext() {
+	// prepare
+	path := suite.writeFile("config", `
+current-context: missing
+clusters: []
+users: []
+`)
+
+	// test
+	_, err := Load(path)
+
+	// verify
+	suite.Error(err)
+	suite.Contains(err.Error(), `context "missing" not found`)
+}
+
+// writeKubeconfig writes a kubeconfig with one context, whose cluster and user are given as yaml fields.
+func (suite *KubeconfigSuite) writeKubeconfig(cluster, user string) string {
+	file, err := os.CreateTemp(suite.dir, "config")
+	suite.Require().NoError(err)
+	suite.Require().NoError(file.Close())
+	return suite.writeFile(filepath.Base(file.Name()), `
+current-context: default
+contexts:
+- name: default
+  context:
+    cluster: default
+    user: default
+clusters:
+- name: default
+  cluster:
+    `+cluster+`
+users:
+- name: default
+  user:
+    `+user+`
+`)
+}
+
+func (suite *KubeconfigSuite) writeFile(name, content string) string {
+	path := filepath.Join(suite.dir, name)
+	suite.Require().NoError(os.WriteFile(path, []byte(content), 0600))
+	return path
+}
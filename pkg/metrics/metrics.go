@@ -0,0 +1,397 @@
+// Package metrics provides the metrics of eks connector in the Prometheus text exposition format.
+//
+// It implements the few metric types eks connector needs rather than depending on the Prometheus client:
+// client_golang would add prometheus/common, procfs, protobuf and their own dependencies to the vendored tree
+// of a sidecar that runs in every connected cluster, while only counters, gauges and histograms are exposed,
+// over the text format 0.0.4 that every Prometheus version scrapes.
+// Replace this package with client_golang if metrics ever need more, e.g. summaries, exemplars or the protobuf format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+
+	// labelSeparator joins label values into series keys, it cannot appear in valid utf-8 label values.
+	labelSeparator = "\xff"
+)
+
+// DefBuckets are the default histogram buckets in seconds, suited to api server request latencies.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// Collector is a metric family that can be exposed by a Registry.
+type Collector interface {
+	// Name returns the name of the metric family.
+	Name() string
+	// Write writes the metric family in the text exposition format.
+	Write(w io.Writer) error
+}
+
+// Registry holds the metrics exposed by eks connector.
+type Registry struct {
+	lock       sync.RWMutex
+	collectors map[string]Collector
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: map[string]Collector{},
+	}
+}
+
+// DefaultRegistry holds the metrics registered by the New* functions of this package.
+var DefaultRegistry = NewRegistry()
+
+// MustRegister adds collector to the registry, it panics if a collector with the same name is already registered.
+func (r *Registry) MustRegister(collector Collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, exists := r.collectors[collector.Name()]; exists {
+		panic(fmt.Sprintf("metric %s is already registered", collector.Name()))
+	}
+	r.collectors[collector.Name()] = collector
+}
+
+// Write writes all metrics of the registry sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.lock.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	collectors := make([]Collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, r.collectors[name])
+	}
+	r.lock.RUnlock()
+
+	for _, collector := range collectors {
+		if err := collector.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Opts describes a metric family.
+type Opts struct {
+	Name string
+	Help string
+}
+
+// family is what all metric types share: a name, help, and series keyed by label values.
+type family struct {
+	opts       Opts
+	metricType string
+	labelNames []string
+
+	lock   sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+
+	// histograms only.
+	bucketCounts []uint64
+	count        uint64
+}
+
+func newFamily(opts Opts, metricType string, labelNames []string) *family {
+	return &family{
+		opts:       opts,
+		metricType: metricType,
+		labelNames: labelNames,
+		series:     map[string]*series{},
+	}
+}
+
+func (f *family) Name() string {
+	return f.opts.Name
+}
+
+// with returns the series of labelValues, creating it if needed. It must be called with the lock held.
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s has labels %v but got values %v", f.opts.Name, f.labelNames, labelValues))
+	}
+	key := strings.Join(labelValues, labelSeparator)
+	s, found := f.series[key]
+	if !found {
+		s = &series{
+			labelValues: append([]string{}, labelValues...),
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sortedSeries returns a snapshot of the series sorted by label values. It must be called with the lock held.
+func (f *family) sortedSeries() []series {
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	snapshot := make([]series, 0, len(keys))
+	for _, key := range keys {
+		s := *f.series[key]
+		s.bucketCounts = append([]uint64{}, s.bucketCounts...)
+		snapshot = append(snapshot, s)
+	}
+	return snapshot
+}
+
+func (f *family) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.opts.Name, escapeHelp(f.opts.Help), f.opts.Name, f.metricType)
+	return err
+}
+
+func (f *family) write(w io.Writer) error {
+	f.lock.Lock()
+	snapshot := f.sortedSeries()
+	f.lock.Unlock()
+
+	if err := f.writeHeader(w); err != nil {
+		return err
+	}
+	for _, s := range snapshot {
+		if err := writeSample(w, f.opts.Name, f.labelNames, s.labelValues, "", "", s.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	*family
+}
+
+// NewCounterVec returns a CounterVec registered in DefaultRegistry.
+func NewCounterVec(opts Opts, labelNames ...string) *CounterVec {
+	counter := &CounterVec{newFamily(opts, typeCounter, labelNames)}
+	DefaultRegistry.MustRegister(counter)
+	return counter
+}
+
+// Inc increments the counter of labelValues by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter of labelValues by delta, which must not be negative.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.opts.Name))
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.with(labelValues).value += delta
+}
+
+func (c *CounterVec) Write(w io.Writer) error {
+	return c.write(w)
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	*family
+}
+
+// NewGaugeVec returns a GaugeVec registered in DefaultRegistry.
+func NewGaugeVec(opts Opts, labelNames ...string) *GaugeVec {
+	gauge := &GaugeVec{newFamily(opts, typeGauge, labelNames)}
+	DefaultRegistry.MustRegister(gauge)
+	return gauge
+}
+
+// Set sets the gauge of labelValues to value.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.with(labelValues).value = value
+}
+
+// Add adds delta, which may be negative, to the gauge of labelValues.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.with(labelValues).value += delta
+}
+
+// Inc increments the gauge of labelValues by one.
+func (g *GaugeVec) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec decrements the gauge of labelValues by one.
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *GaugeVec) Write(w io.Writer) error {
+	return g.write(w)
+}
+
+// GaugeFunc is a gauge without labels whose value is computed when metrics are collected.
+type GaugeFunc struct {
+	*family
+	function func() float64
+}
+
+// NewGaugeFunc returns a GaugeFunc registered in DefaultRegistry.
+// function must be safe for concurrent use, it may return NaN when there is no value to expose.
+func NewGaugeFunc(opts Opts, function func() float64) *GaugeFunc {
+	gauge := &GaugeFunc{
+		family:   newFamily(opts, typeGauge, nil),
+		function: function,
+	}
+	DefaultRegistry.MustRegister(gauge)
+	return gauge
+}
+
+func (g *GaugeFunc) Write(w io.Writer) error {
+	value := g.function()
+	if math.IsNaN(value) {
+		return nil
+	}
+	if err := g.writeHeader(w); err != nil {
+		return err
+	}
+	return writeSample(w, g.opts.Name, nil, nil, "", "", value)
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	*family
+	buckets []float64
+}
+
+// NewHistogramVec returns a HistogramVec with the given upper bounds registered in DefaultRegistry.
+func NewHistogramVec(opts Opts, buckets []float64, labelNames ...string) *HistogramVec {
+	histogram := &HistogramVec{
+		family:  newFamily(opts, typeHistogram, labelNames),
+		buckets: append([]float64{}, buckets...),
+	}
+	sort.Float64s(histogram.buckets)
+	DefaultRegistry.MustRegister(histogram)
+	return histogram
+}
+
+// Observe adds value to the histogram of labelValues.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s := h.with(labelValues)
+	if s.bucketCounts == nil {
+		s.bucketCounts = make([]uint64, len(h.buckets))
+	}
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			s.bucketCounts[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+func (h *HistogramVec) Write(w io.Writer) error {
+	h.lock.Lock()
+	snapshot := h.sortedSeries()
+	h.lock.Unlock()
+
+	if err := h.writeHeader(w); err != nil {
+		return err
+	}
+	for _, s := range snapshot {
+		for i, upperBound := range h.buckets {
+			err := writeSample(w, h.opts.Name+"_bucket", h.labelNames, s.labelValues,
+				"le", formatFloat(upperBound), float64(s.bucketCounts[i]))
+			if err != nil {
+				return err
+			}
+		}
+		err := writeSample(w, h.opts.Name+"_bucket", h.labelNames, s.labelValues, "le", "+Inf", float64(s.count))
+		if err != nil {
+			return err
+		}
+		if err = writeSample(w, h.opts.Name+"_sum", h.labelNames, s.labelValues, "", "", s.value); err != nil {
+			return err
+		}
+		if err = writeSample(w, h.opts.Name+"_count", h.labelNames, s.labelValues, "", "", float64(s.count)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSample writes one sample line, with an optional extra label like the le of histogram buckets.
+func writeSample(w io.Writer, name string, labelNames, labelValues []string, extraName, extraValue string, value float64) error {
+	var builder strings.Builder
+	builder.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		builder.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				builder.WriteByte(',')
+			}
+			builder.WriteString(labelName)
+			builder.WriteString(`="`)
+			builder.WriteString(escapeLabelValue(labelValues[i]))
+			builder.WriteByte('"')
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				builder.WriteByte(',')
+			}
+			builder.WriteString(extraName)
+			builder.WriteString(`="`)
+			builder.WriteString(extraValue)
+			builder.WriteByte('"')
+		}
+		builder.WriteByte('}')
+	}
+	builder.WriteByte(' ')
+	builder.WriteString(formatFloat(value))
+	builder.WriteByte('\n')
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestMetricsSuite(t *testing.T) {
+	suite.Run(t, new(MetricsSuite))
+}
+
+type MetricsSuite struct {
+	suite.Suite
+}
+
+func (suite *MetricsSuite) TestCounterVec() {
+	// prepare
+	counter := NewCounterVec(Opts{
+		Name: "test_counter_total",
+		Help: "A counter\\with \"escapes\"\nin help.",
+	}, "verb", "code")
+
+	// test
+	counter.Inc("list", "200")
+	counter.Add(2, "list", "200")
+	counter.Inc("get", "404")
+	counter.Inc("get", "say \"hi\"\n")
+
+	// verify
+	suite.Equal(`# HELP test_counter_total A counter\\with "escapes"\nin help.
+# TYPE test_counter_total counter
+test_counter_total{verb="get",code="404"} 1
+test_counter_total{verb="get",code="say \"hi\"\n"} 1
+test_counter_total{verb="list",code="200"} 3
+`, suite.write(counter))
+	suite.Panics(func() { counter.Add(-1, "list", "200") })
+	suite.Panics(func() { counter.Inc("list") })
+}
+
+func (suite *MetricsSuite) TestGaugeVec() {
+	// prepare
+	gauge := NewGaugeVec(Opts{
+		Name: "test_gauge",
+		Help: "A gauge.",
+	})
+
+	// test
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+	gauge.Add(0.5)
+
+	// verify
+	suite.Equal("# HELP test_gauge A gauge.\n# TYPE test_gauge gauge\ntest_gauge 1.5\n", suite.write(gauge))
+	gauge.Set(7)
+	suite.Equal("# HELP test_gauge A gauge.\n# TYPE test_gauge gauge\ntest_gauge 7\n", suite.write(gauge))
+}
+
+func (suite *MetricsSuite) TestGaugeFunc() {
+	// prepare
+	value := math.NaN()
+	gauge := NewGaugeFunc(Opts{
+		Name: "test_gauge_func",
+		Help: "A gauge func.",
+	}, func() float64 {
+		return value
+	})
+
+	// test & verify
+	suite.Empty(suite.write(gauge), "NaN is not exposed")
+	value = 42
+	suite.Equal("# HELP test_gauge_func A gauge func.\n# TYPE test_gauge_func gauge\ntest_gauge_func 42\n", suite.write(gauge))
+}
+
+func (suite *MetricsSuite) TestHistogramVec() {
+	// prepare
+	histogram := NewHistogramVec(Opts{
+		Name: "test_duration_seconds",
+		Help: "A histogram.",
+	}, []float64{1, 0.1}, "verb")
+
+	// test
+	histogram.Observe(0.05, "get")
+	histogram.Observe(0.5, "get")
+	histogram.Observe(3, "get")
+
+	// verify
+	suite.Equal(`# HELP test_duration_seconds A histogram.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{verb="get",le="0.1"} 1
+test_duration_seconds_bucket{verb="get",le="1"} 2
+test_duration_seconds_bucket{verb="get",le="+Inf"} 3
+test_duration_seconds_sum{verb="get"} 3.55
+test_duration_seconds_count{verb="get"} 3
+`, suite.write(histogram))
+}
+
+func (suite *MetricsSuite) TestRegistry() {
+	// prepare
+	registry := NewRegistry()
+	second := &CounterVec{newFamily(Opts{Name: "test_b_total", Help: "B."}, typeCounter, nil)}
+	first := &CounterVec{newFamily(Opts{Name: "test_a_total", Help: "A."}, typeCounter, nil)}
+	registry.MustRegister(second)
+	registry.MustRegister(first)
+	first.Inc()
+
+	// test
+	response := httptest.NewRecorder()
+	Handler(registry).ServeHTTP(response, httptest.NewRequest("GET", PathMetrics, nil))
+
+	// verify
+	suite.Equal(contentTypeText, response.Header().Get("Content-Type"))
+	suite.Equal("# HELP test_a_total A.\n# TYPE test_a_total counter\ntest_a_total 1\n"+
+		"# HELP test_b_total B.\n# TYPE test_b_total counter\n", response.Body.String())
+	suite.Panics(func() { registry.MustRegister(first) })
+}
+
+func (suite *MetricsSuite) write(collector Collector) string {
+	var buffer bytes.Buffer
+	suite.NoError(collector.Write(&buffer))
+	return buffer.String()
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	PathMetrics = "/metrics"
+
+	contentTypeText = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// Handler returns an http handler exposing the metrics of registry.
+func Handler(registry *Registry) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", contentTypeText)
+		if err := registry.Write(res); err != nil {
+			klog.Errorf("failed to write metrics: %v", err)
+		}
+	})
+}
+
+// Serve exposes the metrics of DefaultRegistry on listener in the background, until the returned server is closed.
+func Serve(listener net.Listener) *http.Server {
+	mux := &http.ServeMux{}
+	mux.Handle(PathMetrics, Handler(DefaultRegistry))
+	httpServer := &http.Server{
+		ErrorLog: log.New(os.Stdout, "[MetricsServer] ", 0),
+		Handler:  mux,
+	}
+	klog.Infof("serving metrics on %s%s", listener.Addr(), PathMetrics)
+	go func() {
+		if err := httpServer.Serve(listener); err != http.ErrServerClosed {
+			klog.Errorf("metrics server exited unexpectedly: %v", err)
+		}
+	}()
+	return httpServer
+}
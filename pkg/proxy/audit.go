@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-eks-connector/pkg/audit"
+)
+
+const (
+	// HeaderAuditID carries the request ID to api server, which records it as the ID of its own audit event.
+	HeaderAuditID = "Audit-ID"
+
+	// maxAuditedBodySize is the largest request body recorded at audit level Request.
+	maxAuditedBodySize = 64 * 1024
+
+	resourceSecrets = "secrets"
+	kindSecret      = "Secret"
+)
+
+// audit records the audit event of a request once it has been served.
+func (p *proxy) audit(start time.Time, requestID string, req *http.Request, info *RequestInfo,
+	attributes *Attributes, res *responseRecorder, body *bodyRecorder) {
+	if p.Auditor.Level() == audit.LevelNone {
+		return
+	}
+	event := &audit.Event{
+		Timestamp:     start.UTC(),
+		RequestID:     requestID,
+		IAMIdentity:   req.Header.Get(HeaderIamArn),
+		UserAgent:     req.Header.Get(HeaderUserAgent),
+		Verb:          info.Verb,
+		RequestURI:    req.URL.RequestURI(),
+		APIGroup:      info.APIGroup,
+		APIVersion:    info.APIVersion,
+		Resource:      info.Resource,
+		Subresource:   info.Subresource,
+		Namespace:     info.Namespace,
+		Name:          info.Name,
+		ResponseCode:  res.code,
+		LatencyMillis: float64(time.Since(start).Microseconds()) / 1000,
+		ResponseBytes: res.size,
+	}
+	// the upstream transport may still be reading the body, so it is only read through a snapshot.
+	var object []byte
+	event.RequestBytes, object = body.snapshot()
+	if attributes != nil {
+		event.User = attributes.User.Username
+		event.Groups = attributes.User.Groups
+	}
+	if p.Auditor.Level() == audit.LevelRequest {
+		event.RequestObject = auditedObject(info, event.RequestBytes, object)
+	}
+	p.Auditor.Log(event)
+}
+
+// auditedObject returns the request body to record, which is redacted for Secrets
+// and omitted when it is not a complete json object.
+func auditedObject(info *RequestInfo, size int64, object []byte) json.RawMessage {
+	if size == 0 {
+		return nil
+	}
+	if info.Resource == resourceSecrets {
+		return audit.RedactedObject
+	}
+	if object == nil {
+		return nil
+	}
+	// a Secret can also be sent to another resource url, e.g. in the items of a list through a bulk request.
+	decoder := json.NewDecoder(bytes.NewReader(object))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return nil
+	}
+	redacted, changed := redactSecrets(value)
+	if !changed {
+		return object
+	}
+	if _, isSecret := redacted.(json.RawMessage); isSecret {
+		return audit.RedactedObject
+	}
+	redactedObject, err := json.Marshal(redacted)
+	if err != nil {
+		return nil
+	}
+	return redactedObject
+}
+
+// redactSecrets replaces the objects of kind Secret found at any depth of value by the redacted marker,
+// and returns whether it replaced any.
+func redactSecrets(value interface{}) (interface{}, bool) {
+	changed := false
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		if typed["kind"] == kindSecret {
+			return audit.RedactedObject, true
+		}
+		for key, field := range typed {
+			if redacted, fieldChanged := redactSecrets(field); fieldChanged {
+				typed[key] = redacted
+				changed = true
+			}
+		}
+	case []interface{}:
+		for i, item := range typed {
+			if redacted, itemChanged := redactSecrets(item); itemChanged {
+				typed[i] = redacted
+				changed = true
+			}
+		}
+	}
+	return value, changed
+}
+
+// responseRecorder records the status code and size of a response.
+// It keeps the Flusher and Hijacker capabilities of the wrapped writer for streamed and upgraded responses.
+type responseRecorder struct {
+	http.ResponseWriter
+	code int
+	size int64
+
+	// onHijack, if set, wraps the connection returned by Hijack.
+	onHijack func(conn net.Conn) net.Conn
+}
+
+func newResponseRecorder(res http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{
+		ResponseWriter: res,
+	}
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	if r.code == 0 {
+		r.code = http.StatusSwitchingProtocols
+	}
+	conn, readWriter, err := hijacker.Hijack()
+	if err == nil && r.onHijack != nil {
+		conn = r.onHijack(conn)
+	}
+	return conn, readWriter, err
+}
+
+// Unwrap lets http.ResponseController reach the wrapped writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// bodyRecorder counts the bytes of a request body as it is read by the upstream transport,
+// and keeps up to maxAuditedBodySize of them if capture is set.
+// The transport reads the body on its own goroutine, possibly after the response was served.
+type bodyRecorder struct {
+	io.ReadCloser
+	capture bool
+
+	lock      sync.Mutex
+	buffer    bytes.Buffer
+	size      int64
+	truncated bool
+}
+
+func newBodyRecorder(body io.ReadCloser, capture bool) *bodyRecorder {
+	return &bodyRecorder{
+		ReadCloser: body,
+		capture:    capture,
+	}
+}
+
+func (b *bodyRecorder) Read(data []byte) (int, error) {
+	n, err := b.ReadCloser.Read(data)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.size += int64(n)
+	if b.capture && n > 0 && !b.truncated {
+		if b.buffer.Len()+n > maxAuditedBodySize {
+			b.truncated = true
+			b.buffer.Reset()
+		} else {
+			b.buffer.Write(data[:n])
+		}
+	}
+	return n, err
+}
+
+// snapshot returns the number of bytes read so far, and a copy of the captured body,
+// nil if it was not captured or exceeded maxAuditedBodySize.
+func (b *bodyRecorder) snapshot() (int64, []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.capture || b.truncated {
+		return b.size, nil
+	}
+	return b.size, append([]byte(nil), b.buffer.Bytes()...)
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/audit"
+)
+
+func TestAuditSuite(t *testing.T) {
+	suite.Run(t, new(AuditSuite))
+}
+
+type AuditSuite struct {
+	suite.Suite
+}
+
+func (suite *AuditSuite) TestAuditedObjectRedactsNestedSecrets() {
+	// prepare
+	info := &RequestInfo{Resource: "lists"}
+	object := []byte(`{"kind":"List","items":[` +
+		`{"kind":"ConfigMap","data":{"key":"value"}},` +
+		`{"kind":"Secret","data":{"password":"aHVudGVyMg=="}}]}`)
+
+	// test
+	audited := auditedObject(info, int64(len(object)), object)
+
+	// verify
+	suite.JSONEq(`{"kind":"List","items":[{"kind":"ConfigMap","data":{"key":"value"}},"[redacted]"]}`, string(audited))
+	suite.NotContains(string(audited), "aHVudGVyMg==")
+}
+
+func (suite *AuditSuite) TestAuditedObject() {
+	testCases := map[string]struct {
+		resource string
+		object   string
+		expected string
+	}{
+		"object":          {"configmaps", `{"kind":"ConfigMap","data":{"replicas":1.50}}`, `{"kind":"ConfigMap","data":{"replicas":1.50}}`},
+		"secret resource": {resourceSecrets, `{"data":{"password":"aHVudGVyMg=="}}`, string(audit.RedactedObject)},
+		"secret kind":     {"configmaps", `{"kind":"Secret","data":{"password":"aHVudGVyMg=="}}`, string(audit.RedactedObject)},
+		"invalid json":    {"configmaps", `{"kind":`, ""},
+		"several objects": {"configmaps", `{"kind":"ConfigMap"}{"kind":"Secret"}`, ""},
+	}
+	for name, testCase := range testCases {
+		// test
+		audited := auditedObject(&RequestInfo{Resource: testCase.resource}, int64(len(testCase.object)), []byte(testCase.object))
+
+		// verify
+		suite.Equal(testCase.expected, string(audited), name)
+	}
+}
+
+func (suite *AuditSuite) TestBodyRecorderSnapshotWhileReading() {
+	// prepare
+	body := strings.Repeat("x", 1024)
+	recorder := newBodyRecorder(io.NopCloser(strings.NewReader(body)), true)
+
+	// test
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buffer := make([]byte, 16)
+		for {
+			if _, err := recorder.Read(buffer); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		size, object := recorder.snapshot()
+		suite.Equal(int(size), len(object))
+	}
+	wg.Wait()
+
+	// verify
+	size, object := recorder.snapshot()
+	suite.Equal(int64(len(body)), size)
+	suite.Equal(body, string(object))
+}
+
+func (suite *AuditSuite) TestBodyRecorderSnapshotTruncated() {
+	// prepare
+	body := strings.Repeat("x", maxAuditedBodySize+1)
+	recorder := newBodyRecorder(io.NopCloser(strings.NewReader(body)), true)
+
+	// test
+	_, err := io.Copy(io.Discard, recorder)
+
+	// verify
+	suite.NoError(err)
+	size, object := recorder.snapshot()
+	suite.Equal(int64(len(body)), size)
+	suite.Nil(object)
+}
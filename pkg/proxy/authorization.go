@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"github.com/aws/amazon-eks-connector/pkg/filewatch"
+)
+
+const (
+	PolicyActionAllow = "Allow"
+	PolicyActionDeny  = "Deny"
+)
+
+// Authorizer decides whether eks connector lets a request through to api server.
+// It is a guardrail evaluated before, and independently of, kubernetes RBAC.
+type Authorizer interface {
+	// Authorize returns whether the request is allowed, and the reason of the decision.
+	Authorize(attributes *Attributes) (allowed bool, reason string)
+}
+
+// NewAlwaysAllowAuthorizer returns an Authorizer that leaves all decisions to kubernetes RBAC.
+func NewAlwaysAllowAuthorizer() Authorizer {
+	return &alwaysAllowAuthorizer{}
+}
+
+type alwaysAllowAuthorizer struct {
+}
+
+func (a *alwaysAllowAuthorizer) Authorize(attributes *Attributes) (bool, string) {
+	return true, ""
+}
+
+// Policy is the content of an authorization policy file.
+// A request is denied if any Deny rule matches it, otherwise it is allowed if any Allow rule matches it.
+// Requests matching no rule get DefaultAction.
+type Policy struct {
+	// DefaultAction is Allow or Deny, Allow if not set.
+	DefaultAction string       `json:"defaultAction"`
+	Rules         []PolicyRule `json:"rules"`
+}
+
+// PolicyRule matches requests by their attributes.
+// An omitted list matches anything, "*" in a list entry matches any sequence of characters.
+type PolicyRule struct {
+	// Name identifies the rule in logs and error responses.
+	Name string `json:"name"`
+	// Action is Allow or Deny.
+	Action string `json:"action"`
+
+	// Users match the requester IAM ARN, its canonical ARN or the impersonated kubernetes username.
+	Users []string `json:"users"`
+	// Groups match any impersonated kubernetes group.
+	Groups []string `json:"groups"`
+	Verbs  []string `json:"verbs"`
+
+	// APIGroups, Resources and Namespaces only match resource requests.
+	// Resources may name subresources like pods/exec.
+	APIGroups  []string `json:"apiGroups"`
+	Resources  []string `json:"resources"`
+	Namespaces []string `json:"namespaces"`
+
+	// NonResourceURLs only match non-resource requests like /version.
+	NonResourceURLs []string `json:"nonResourceURLs"`
+}
+
+// NewPolicyAuthorizer returns an Authorizer enforcing the yaml or json Policy file at filePath.
+// The file is reloaded when it changes, a policy that fails to load is logged and the previous one is kept.
+func NewPolicyAuthorizer(filePath string) (Authorizer, error) {
+	authorizer := &policyAuthorizer{
+		filePath: filePath,
+	}
+	if err := authorizer.load(); err != nil {
+		return nil, err
+	}
+	if err := authorizer.watch(); err != nil {
+		return nil, err
+	}
+	return authorizer, nil
+}
+
+type policyAuthorizer struct {
+	filePath string
+
+	lock   sync.RWMutex
+	policy *Policy
+}
+
+func (a *policyAuthorizer) Authorize(attributes *Attributes) (bool, string) {
+	a.lock.RLock()
+	policy := a.policy
+	a.lock.RUnlock()
+
+	return policy.evaluate(attributes)
+}
+
+func (a *policyAuthorizer) load() error {
+	policy, err := loadPolicy(a.filePath)
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	a.policy = policy
+	a.lock.Unlock()
+	klog.Infof("loaded authorization policy from %s with %d rules", a.filePath, len(policy.Rules))
+	return nil
+}
+
+// watch reloads the policy when it changes, including through the ..data symlink of a mounted ConfigMap.
+func (a *policyAuthorizer) watch() error {
+	return filewatch.WatchFiles("authorization policy", []string{a.filePath}, func() {
+		if err := a.load(); err != nil {
+			klog.Errorf("failed to reload authorization policy, keeping the previous one: %v", err)
+		}
+	})
+}
+
+func loadPolicy(filePath string) (*Policy, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	// a file being rewritten in place is briefly empty, which must not be read as an allow-all policy.
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil, fmt.Errorf("authorization policy %s is empty", filePath)
+	}
+	policy := &Policy{}
+	if err = yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse authorization policy %s: %w", filePath, err)
+	}
+	if err = policy.validate(); err != nil {
+		return nil, fmt.Errorf("invalid authorization policy %s: %w", filePath, err)
+	}
+	return policy, nil
+}
+
+func (p *Policy) validate() error {
+	if p.DefaultAction == "" {
+		p.DefaultAction = PolicyActionAllow
+	}
+	if p.DefaultAction != PolicyActionAllow && p.DefaultAction != PolicyActionDeny {
+		return fmt.Errorf("unknown default action %q", p.DefaultAction)
+	}
+	for i, rule := range p.Rules {
+		if rule.Action != PolicyActionAllow && rule.Action != PolicyActionDeny {
+			return fmt.Errorf("rule %d %q has unknown action %q", i, rule.Name, rule.Action)
+		}
+		if rule.Name == "" {
+			p.Rules[i].Name = fmt.Sprintf("rule-%d", i)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) evaluate(attributes *Attributes) (bool, string) {
+	var allowedBy string
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !rule.matches(attributes) {
+			continue
+		}
+		if rule.Action == PolicyActionDeny {
+			return false, rule.Name
+		}
+		if allowedBy == "" {
+			allowedBy = rule.Name
+		}
+	}
+	if allowedBy != "" {
+		return true, allowedBy
+	}
+	return p.DefaultAction == PolicyActionAllow, "default action " + p.DefaultAction
+}
+
+func (r *PolicyRule) matches(attributes *Attributes) bool {
+	request := attributes.Request
+	if request.IsResourceRequest {
+		if len(r.NonResourceURLs) > 0 {
+			return false
+		}
+		if !matchAny(r.APIGroups, request.APIGroup) ||
+			!matchAny(r.Resources, request.ResourceName()) ||
+			!matchAny(r.Namespaces, request.Namespace) {
+			return false
+		}
+	} else {
+		if len(r.APIGroups) > 0 || len(r.Resources) > 0 || len(r.Namespaces) > 0 {
+			return false
+		}
+		if !matchAny(r.NonResourceURLs, request.Path) {
+			return false
+		}
+	}
+	return matchAny(r.Verbs, request.Verb) && r.matchesUser(attributes) && r.matchesGroups(attributes)
+}
+
+func (r *PolicyRule) matchesUser(attributes *Attributes) bool {
+	if len(r.Users) == 0 {
+		return true
+	}
+	candidates := []string{attributes.User.Username}
+	if attributes.Principal != nil {
+		candidates = append(candidates, attributes.Principal.ARN, attributes.Principal.CanonicalARN)
+	}
+	for _, candidate := range candidates {
+		if matchAny(r.Users, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *PolicyRule) matchesGroups(attributes *Attributes) bool {
+	if len(r.Groups) == 0 {
+		return true
+	}
+	for _, group := range attributes.User.Groups {
+		if matchAny(r.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny returns true if patterns is empty or any pattern matches value.
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matchWildcard(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWildcard matches value against pattern where "*" matches any sequence of characters, including "/".
+func matchWildcard(pattern, value string) bool {
+	segments := strings.Split(pattern, "*")
+	if len(segments) == 1 {
+		return pattern == value
+	}
+	if !strings.HasPrefix(value, segments[0]) {
+		return false
+	}
+	value = value[len(segments[0]):]
+	for _, segment := range segments[1 : len(segments)-1] {
+		index := strings.Index(value, segment)
+		if index < 0 {
+			return false
+		}
+		value = value[index+len(segment):]
+	}
+	return strings.HasSuffix(value, segments[len(segments)-1])
+}
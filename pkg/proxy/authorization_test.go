@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/identity"
+)
+
+const (
+	testPolicy = `
+rules:
+  - name: no-kube-system-secrets
+    action: Deny
+    namespaces: ["kube-system"]
+    resources: ["secrets"]
+  - name: no-exec-for-viewers
+    action: Deny
+    users: ["arn:aws:iam::123456789012:role/Viewer"]
+    resources: ["pods/exec", "pods/attach"]
+  - name: no-version
+    action: Deny
+    groups: ["restricted-*"]
+    nonResourceURLs: ["/version"]
+`
+	testPolicyDefaultDeny = `
+defaultAction: Deny
+rules:
+  - name: read-only
+    action: Allow
+    verbs: ["get", "list", "watch"]
+`
+)
+
+func TestAuthorizationSuite(t *testing.T) {
+	suite.Run(t, new(AuthorizationSuite))
+}
+
+type AuthorizationSuite struct {
+	suite.Suite
+
+	dirName string
+}
+
+func (suite *AuthorizationSuite) SetupTest() {
+	dir, err := os.MkdirTemp("", "eks_connector_policy")
+	suite.NoError(err)
+	suite.dirName = dir
+}
+
+func (suite *AuthorizationSuite) TearDownTest() {
+	suite.NoError(os.RemoveAll(suite.dirName))
+}
+
+func (suite *AuthorizationSuite) TestPolicyRules() {
+	// prepare
+	authorizer, err := NewPolicyAuthorizer(suite.writePolicy(testPolicy))
+	suite.NoError(err)
+
+	testCases := []struct {
+		arn     string
+		groups  []string
+		method  string
+		url     string
+		allowed bool
+		reason  string
+	}{
+		{testIAMIdentity, nil, "GET", "/api/v1/namespaces/kube-system/secrets/token", false, "no-kube-system-secrets"},
+		{testIAMIdentity, nil, "GET", "/api/v1/secrets", true, "default action Allow"},
+		{testIAMIdentity, nil, "GET", "/api/v1/namespaces/kube-system/pods", true, "default action Allow"},
+		{testAssumedRoleIdentity, nil, "POST", "/api/v1/namespaces/default/pods/nginx/exec", false, "no-exec-for-viewers"},
+		{testIAMIdentity, nil, "POST", "/api/v1/namespaces/default/pods/nginx/exec", true, "default action Allow"},
+		{testIAMIdentity, []string{"restricted-users"}, "GET", "/version", false, "no-version"},
+		{testIAMIdentity, nil, "GET", "/version", true, "default action Allow"},
+	}
+	for _, testCase := range testCases {
+		// test
+		allowed, reason := authorizer.Authorize(newTestAttributes(testCase.arn, testCase.groups, testCase.method, testCase.url))
+
+		// verify
+		suite.Equal(testCase.allowed, allowed, testCase.url)
+		suite.Equal(testCase.reason, reason, testCase.url)
+	}
+}
+
+func (suite *AuthorizationSuite) TestPolicyDefaultDeny() {
+	// prepare
+	authorizer, err := NewPolicyAuthorizer(suite.writePolicy(testPolicyDefaultDeny))
+	suite.NoError(err)
+
+	// test
+	getAllowed, getReason := authorizer.Authorize(newTestAttributes(testIAMIdentity, nil, "GET", "/api/v1/pods"))
+	deleteAllowed, deleteReason := authorizer.Authorize(newTestAttributes(testIAMIdentity, nil, "DELETE", "/api/v1/namespaces/default/pods/nginx"))
+
+	// verify
+	suite.True(getAllowed)
+	suite.Equal("read-only", getReason)
+	suite.False(deleteAllowed)
+	suite.Equal("default action Deny", deleteReason)
+}
+
+func (suite *AuthorizationSuite) TestPolicyReload() {
+	// prepare
+	policyFile := suite.writePolicy(testPolicy)
+	authorizer, err := NewPolicyAuthorizer(policyFile)
+	suite.NoError(err)
+	attributes := newTestAttributes(testIAMIdentity, nil, "DELETE", "/api/v1/namespaces/default/pods/nginx")
+	allowed, _ := authorizer.Authorize(attributes)
+	suite.True(allowed)
+
+	// test
+	suite.writePolicy(testPolicyDefaultDeny)
+
+	// verify
+	suite.Eventually(func() bool {
+		allowed, _ := authorizer.Authorize(attributes)
+		return !allowed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// an invalid policy keeps the previous one
+	suite.writePolicy("defaultAction: Maybe")
+	time.Sleep(100 * time.Millisecond)
+	allowed, reason := authorizer.Authorize(attributes)
+	suite.False(allowed)
+	suite.Equal("default action Deny", reason)
+}
+
+func (suite *AuthorizationSuite) TestInvalidPolicy() {
+	for _, policy := range []string{
+		"defaultAction: Maybe",
+		"rules: [{action: Block}]",
+		"rules: [{action: Allow, unknownField: true}]",
+		" \n",
+	} {
+		_, err := NewPolicyAuthorizer(suite.writePolicy(policy))
+		suite.Error(err, policy)
+	}
+}
+
+func (suite *AuthorizationSuite) TestMatchWildcard() {
+	suite.True(matchWildcard("*", ""))
+	suite.True(matchWildcard("pods", "pods"))
+	suite.False(matchWildcard("pods", "pods/exec"))
+	suite.True(matchWildcard("pods/*", "pods/exec"))
+	suite.True(matchWildcard("arn:aws:sts::*:assumed-role/Admin/*", "arn:aws:sts::123456789012:assumed-role/Admin/alice"))
+	suite.False(matchWildcard("arn:aws:sts::*:assumed-role/Admin/*", "arn:aws:sts::123456789012:assumed-role/Viewer/alice"))
+	suite.True(matchWildcard("a*b*b", "abb"))
+	suite.False(matchWildcard("a*b*b", "ab"))
+}
+
+func (suite *AuthorizationSuite) writePolicy(policy string) string {
+	filePath := path.Join(suite.dirName, "policy.yaml")
+	suite.NoError(os.WriteFile(filePath, []byte(policy), 0600))
+	return filePath
+}
+
+func newTestAttributes(arn string, groups []string, method, url string) *Attributes {
+	principal, err := identity.ParsePrincipal(arn)
+	if err != nil {
+		panic(err)
+	}
+	return &Attributes{
+		Principal: principal,
+		User: &identity.Identity{
+			Username: arn,
+			Groups:   groups,
+		},
+		Request: newRequestInfo(httptest.NewRequest(method, "http://foo-bar"+url, nil)),
+	}
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/aws/amazon-eks-connector/pkg/identity"
+)
+
+type contextKey int
+
+const (
+	attributesContextKey contextKey = iota
+	requestIDContextKey
+	listenerOptionsContextKey
+	forwardErrorContextKey
+)
+
+// ListenerOptions are the handler options of the listener a request is received on.
+type ListenerOptions struct {
+	// Name identifies the listener in logs.
+	Name string
+	// Mode is readwrite or readonly, overriding the proxy mode for the requests of the listener if set.
+	Mode string
+	// TrustIdentity skips the verification of the requester IAM identity signature, e.g. on a loopback debug port.
+	TrustIdentity bool
+}
+
+// Attributes are what eks connector knows about a proxied request once it is authenticated.
+type Attributes struct {
+	// Principal is the requester IAM identity.
+	Principal *identity.Principal
+	// User is the kubernetes identity impersonated for the requester.
+	User *identity.Identity
+	// Request holds the kubernetes attributes of the request.
+	Request *RequestInfo
+}
+
+// withAttributes returns a copy of ctx carrying the attributes of the request.
+func withAttributes(ctx context.Context, attributes *Attributes) context.Context {
+	return context.WithValue(ctx, attributesContextKey, attributes)
+}
+
+// attributesFrom returns the attributes of the request, if it is authenticated.
+func attributesFrom(ctx context.Context) (*Attributes, bool) {
+	attributes, ok := ctx.Value(attributesContextKey).(*Attributes)
+	return attributes, ok
+}
+
+// withRequestID returns a copy of ctx carrying the ID eks connector assigned to the request.
+func withRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+// requestIDFrom returns the ID of the request.
+func requestIDFrom(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDContextKey).(string)
+	return requestID, ok
+}
+
+// WithListenerOptions returns a copy of ctx carrying the options of the listener requests are received on.
+func WithListenerOptions(ctx context.Context, options *ListenerOptions) context.Context {
+	return context.WithValue(ctx, listenerOptionsContextKey, options)
+}
+
+// listenerOptionsFrom returns the options of the listener the request was received on, if any.
+func listenerOptionsFrom(ctx context.Context) (*ListenerOptions, bool) {
+	options, ok := ctx.Value(listenerOptionsContextKey).(*ListenerOptions)
+	return options, ok
+}
+
+// withForwardError returns a copy of ctx carrying the reason why the request must not be forwarded to api server.
+func withForwardError(ctx context.Context, err error) context.Context {
+	return context.WithValue(ctx, forwardErrorContextKey, err)
+}
+
+// forwardErrorFrom returns the reason why the request must not be forwarded to api server, nil if it can be.
+func forwardErrorFrom(ctx context.Context) error {
+	err, _ := ctx.Value(forwardErrorContextKey).(error)
+	return err
+}
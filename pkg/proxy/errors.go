@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Types of errors the proxy encounters when sending a request to api server.
+const (
+	UpstreamErrorServiceAccount = "service_account"
+	UpstreamErrorImpersonation  = "impersonation"
+	UpstreamErrorContentHash    = "content_hash"
+	UpstreamErrorTLS            = "tls"
+	UpstreamErrorDNS            = "dns"
+	UpstreamErrorTimeout        = "timeout"
+	UpstreamErrorCanceled       = "canceled"
+	UpstreamErrorConnection     = "connection"
+	UpstreamErrorOther          = "other"
+)
+
+const (
+	// StatusClientClosedRequest is the nginx-style code of requests canceled by the client before api server responded.
+	StatusClientClosedRequest = 499
+
+	// Status reasons of proxy errors that have no kubernetes equivalent.
+	StatusReasonBadGateway          metav1.StatusReason = "BadGateway"
+	StatusReasonClientClosedRequest metav1.StatusReason = "ClientClosedRequest"
+
+	// Cause types in the details of proxy error statuses.
+	CauseTypeUpstreamError metav1.CauseType = "UpstreamError"
+	CauseTypeRequestID     metav1.CauseType = "RequestID"
+)
+
+// upstreamErrorStatus describes the Status returned for a type of upstream error.
+type upstreamErrorStatus struct {
+	code    int
+	reason  metav1.StatusReason
+	message string
+}
+
+// upstreamErrorStatuses tell connector misconfigurations (502, 503 service account) from api server outages (503, 504).
+var upstreamErrorStatuses = map[string]upstreamErrorStatus{
+	UpstreamErrorServiceAccount: {http.StatusServiceUnavailable, metav1.StatusReasonServiceUnavailable,
+		"eks connector cannot load its service account token or CA bundle"},
+	UpstreamErrorImpersonation: {http.StatusInternalServerError, metav1.StatusReasonInternalError,
+		"eks connector cannot determine the kubernetes identity to impersonate"},
+	UpstreamErrorContentHash: {http.StatusBadRequest, metav1.StatusReasonBadRequest,
+		"the request body does not match its signed content hash"},
+	UpstreamErrorTLS: {http.StatusBadGateway, StatusReasonBadGateway,
+		"eks connector cannot verify the certificate of kubernetes api"},
+	UpstreamErrorDNS: {http.StatusBadGateway, StatusReasonBadGateway,
+		"eks connector cannot resolve the address of kubernetes api"},
+	UpstreamErrorTimeout: {http.StatusGatewayTimeout, metav1.StatusReasonTimeout,
+		"kubernetes api did not respond to eks connector in time"},
+	UpstreamErrorCanceled: {StatusClientClosedRequest, StatusReasonClientClosedRequest,
+		"the request was canceled by the client"},
+	UpstreamErrorConnection: {http.StatusServiceUnavailable, metav1.StatusReasonServiceUnavailable,
+		"eks connector cannot connect to kubernetes api"},
+	UpstreamErrorOther: {http.StatusBadGateway, StatusReasonBadGateway,
+		"eks connector failed to proxy the request to kubernetes api"},
+}
+
+// errServiceAccount is returned when the service account token or CA bundle cannot be loaded.
+var errServiceAccount = errors.New("failed to load service account secret")
+
+// upstreamErrorType classifies an error returned by the proxy transport or the service account provider.
+func upstreamErrorType(err error) string {
+	var dnsError *net.DNSError
+	var netError net.Error
+	var opError *net.OpError
+	var unknownAuthorityError x509.UnknownAuthorityError
+	var hostnameError x509.HostnameError
+	var certificateInvalidError x509.CertificateInvalidError
+	var recordHeaderError tls.RecordHeaderError
+
+	switch {
+	case errors.Is(err, errServiceAccount):
+		return UpstreamErrorServiceAccount
+	case errors.Is(err, errImpersonation):
+		return UpstreamErrorImpersonation
+	case errors.Is(err, errContentHash):
+		return UpstreamErrorContentHash
+	case errors.Is(err, context.Canceled):
+		return UpstreamErrorCanceled
+	case errors.As(err, &unknownAuthorityError), errors.As(err, &hostnameError),
+		errors.As(err, &certificateInvalidError), errors.As(err, &recordHeaderError):
+		return UpstreamErrorTLS
+	case errors.As(err, &dnsError):
+		return UpstreamErrorDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netError) && netError.Timeout():
+		return UpstreamErrorTimeout
+	case errors.As(err, &opError):
+		return UpstreamErrorConnection
+	default:
+		return UpstreamErrorOther
+	}
+}
+
+// proxyError responds to a request that could not be proxied to api server with a Status classifying the error.
+// The request ID is both logged and returned, so that users can share it to find the error in the logs.
+func (p *proxy) proxyError(res http.ResponseWriter, req *http.Request, err error) {
+	errorType := upstreamErrorType(err)
+	upstreamErrorsTotal.Inc(errorType)
+	requestID, _ := requestIDFrom(req.Context())
+	klog.Infof("eks connector proxy failed %s %s with %s error, request id %s: %v",
+		req.Method, req.URL.Path, errorType, requestID, err)
+
+	status := upstreamErrorStatuses[errorType]
+	writeStatusDetails(res, status.code, status.reason,
+		fmt.Sprintf("%s. check eks connector logs for request id %s.", status.message, requestID),
+		&metav1.StatusDetails{
+			Causes: []metav1.StatusCause{
+				{Type: CauseTypeUpstreamError, Message: errorType},
+				{Type: CauseTypeRequestID, Message: requestID},
+			},
+		})
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestErrorsSuite(t *testing.T) {
+	suite.Run(t, new(ErrorsSuite))
+}
+
+type ErrorsSuite struct {
+	suite.Suite
+}
+
+func (suite *ErrorsSuite) TestUpstreamErrorType() {
+	testCases := map[string]error{
+		UpstreamErrorServiceAccount: fmt.Errorf("%w: %v", errServiceAccount, os.ErrNotExist),
+		UpstreamErrorImpersonation:  errImpersonation,
+		UpstreamErrorCanceled:       &url.Error{Op: "Get", Err: context.Canceled},
+		UpstreamErrorTLS:            &url.Error{Op: "Get", Err: x509.UnknownAuthorityError{}},
+		UpstreamErrorDNS: &net.OpError{Op: "dial", Err: &net.DNSError{
+			Err:  "no such host",
+			Name: "kubernetes.default.svc",
+		}},
+		UpstreamErrorTimeout:    &url.Error{Op: "Get", Err: context.DeadlineExceeded},
+		UpstreamErrorConnection: &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+		UpstreamErrorOther:      errors.New("unexpected EOF"),
+	}
+	for expected, err := range testCases {
+		// test
+		actual := upstreamErrorType(err)
+
+		// verify
+		suite.Equal(expected, actual, err.Error())
+	}
+}
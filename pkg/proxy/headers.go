@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+// DefaultAllowedHeaders are client headers forwarded to api server unless denied by configuration.
+// They are needed for content negotiation (e.g. `Accept: application/json;as=Table`),
+// patch content types and conditional requests.
+var DefaultAllowedHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Cache-Control",
+	"Content-Encoding",
+	"Content-Type",
+	"If-Match",
+	"If-Modified-Since",
+	"If-None-Match",
+	"If-Range",
+	"If-Unmodified-Since",
+	"Range",
+}
+
+// ForbiddenHeaders are client headers that are never forwarded to api server regardless of configuration,
+// so that callers cannot escalate their privileges over the connector's service account.
+var ForbiddenHeaders = []string{
+	HeaderAuthorization,
+	"Proxy-Authorization",
+	"Impersonate-*",
+	// the identity and its signature headers.
+	HeaderIamArn + "*",
+}
+
+// headerPolicy decides which client headers are forwarded to api server.
+type headerPolicy struct {
+	allow []string
+	deny  []string
+}
+
+func newHeaderPolicy(headerConfig *config.HeaderConfig) *headerPolicy {
+	forbidden := normalizeHeaderPatterns(ForbiddenHeaders)
+	for _, name := range headerConfig.Allow {
+		if matchHeader(forbidden, strings.ToLower(name)) {
+			klog.Warningf("header %s is configured to be allowed but will never be forwarded", name)
+		}
+	}
+
+	allow := normalizeHeaderPatterns(DefaultAllowedHeaders, headerConfig.Allow)
+	deny := normalizeHeaderPatterns(forbidden, headerConfig.Deny)
+
+	return &headerPolicy{
+		allow: allow,
+		deny:  deny,
+	}
+}
+
+// filter returns a new header map with only the allowed headers of header.
+func (h *headerPolicy) filter(header http.Header) http.Header {
+	filtered := http.Header{}
+	for name, values := range header {
+		if h.allowed(name) {
+			filtered[name] = values
+		}
+	}
+	return filtered
+}
+
+func (h *headerPolicy) allowed(name string) bool {
+	name = strings.ToLower(name)
+	return matchHeader(h.allow, name) && !matchHeader(h.deny, name)
+}
+
+// matchHeader returns true if the lower-cased header name matches any of the normalized patterns.
+func matchHeader(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHeaderPatterns merges pattern lists into a single lower-cased list.
+func normalizeHeaderPatterns(patternLists ...[]string) []string {
+	var normalized []string
+	for _, patterns := range patternLists {
+		for _, pattern := range patterns {
+			pattern = strings.ToLower(strings.TrimSpace(pattern))
+			if pattern != "" {
+				normalized = append(normalized, pattern)
+			}
+		}
+	}
+	return normalized
+}
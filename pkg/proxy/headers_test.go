@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+func TestHeaderPolicySuite(t *testing.T) {
+	suite.Run(t, new(HeaderPolicySuite))
+}
+
+type HeaderPolicySuite struct {
+	suite.Suite
+}
+
+func (suite *HeaderPolicySuite) TestDefaultPolicy() {
+	// prepare
+	policy := newHeaderPolicy(&config.HeaderConfig{})
+	header := http.Header{}
+	header.Set("Accept", "application/vnd.kubernetes.protobuf")
+	header.Set("Content-Type", "application/merge-patch+json")
+	header.Set("Range", "bytes=0-1023")
+	header.Set("X-Unknown", "unknown")
+
+	// test
+	filtered := policy.filter(header)
+
+	// verify
+	suite.Len(filtered, 3)
+	suite.Equal("application/vnd.kubernetes.protobuf", filtered.Get("Accept"))
+	suite.Equal("application/merge-patch+json", filtered.Get("Content-Type"))
+	suite.Equal("bytes=0-1023", filtered.Get("Range"))
+}
+
+func (suite *HeaderPolicySuite) TestForbiddenHeadersCannotBeAllowed() {
+	// prepare
+	policy := newHeaderPolicy(&config.HeaderConfig{
+		Allow: []string{"authorization", "impersonate-*", "X-Aws-Eks-*"},
+	})
+	header := http.Header{}
+	header.Set(HeaderAuthorization, "Bearer token")
+	header.Set("Impersonate-User", "admin")
+	header.Add("Impersonate-Group", "system:masters")
+	header.Set("Impersonate-Uid", "0")
+	header.Set(HeaderIamArn, "arn:aws:iam::123456789012:role/admin")
+	header.Set("X-Aws-Eks-Trace", "trace")
+
+	// test
+	filtered := policy.filter(header)
+
+	// verify
+	suite.Len(filtered, 1)
+	suite.Equal("trace", filtered.Get("X-Aws-Eks-Trace"))
+}
+
+func (suite *HeaderPolicySuite) TestDenyOverridesAllow() {
+	// prepare
+	policy := newHeaderPolicy(&config.HeaderConfig{
+		Allow: []string{"X-Trace-*"},
+		Deny:  []string{"accept-*", "X-Trace-Secret"},
+	})
+
+	// verify
+	suite.True(policy.allowed("Accept"))
+	suite.False(policy.allowed("Accept-Encoding"))
+	suite.False(policy.allowed("Accept-Language"))
+	suite.True(policy.allowed("X-Trace-Id"))
+	suite.False(policy.allowed("x-trace-secret"))
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+const (
+	HeaderRetryAfter = "Retry-After"
+
+	// identityLimiterTTL is how long the rate limiter of an idle identity is kept.
+	identityLimiterTTL = 10 * time.Minute
+	// inFlightRetryAfter is the Retry-After advertised when the proxy is at its in-flight limit.
+	inFlightRetryAfter = 1 * time.Second
+)
+
+// Reasons a request is rejected by the requestLimiter.
+const (
+	LimitReasonRate                = "rate"
+	LimitReasonInFlight            = "inflight"
+	LimitReasonLongRunningInFlight = "longrunning-inflight"
+)
+
+// longRunningSubresources keep their connection open for as long as the client wants.
+var longRunningSubresources = map[string]bool{
+	"exec":        true,
+	"attach":      true,
+	"portforward": true,
+	"proxy":       true,
+}
+
+// limitError is returned when a request exceeds a limit of the proxy.
+type limitError struct {
+	reason     string
+	retryAfter time.Duration
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded, retry after %s", e.reason, e.retryAfter)
+}
+
+// requestLimiter enforces a token bucket per IAM identity,
+// and a global in-flight limit with a separate budget for long-running requests like watches,
+// so that watches cannot starve short requests and the other way around.
+// A limit of zero disables it.
+type requestLimiter struct {
+	limitsConfig *config.LimitsConfig
+
+	lock       sync.Mutex
+	identities map[string]*identityLimiter
+	lastSweep  time.Time
+
+	inFlight            chan struct{}
+	longRunningInFlight chan struct{}
+}
+
+type identityLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRequestLimiter(limitsConfig *config.LimitsConfig) *requestLimiter {
+	limiter := &requestLimiter{
+		limitsConfig: limitsConfig,
+		identities:   map[string]*identityLimiter{},
+		lastSweep:    time.Now(),
+	}
+	if limitsConfig.MaxInFlight > 0 {
+		limiter.inFlight = make(chan struct{}, limitsConfig.MaxInFlight)
+	}
+	if limitsConfig.MaxLongRunningInFlight > 0 {
+		limiter.longRunningInFlight = make(chan struct{}, limitsConfig.MaxLongRunningInFlight)
+	}
+	return limiter
+}
+
+// acquire takes a rate token of identity and an in-flight slot.
+// The returned release func must be called once the request is served.
+func (l *requestLimiter) acquire(identity string, longRunning bool) (func(), *limitError) {
+	if delay := l.reserve(identity); delay > 0 {
+		return nil, &limitError{
+			reason:     LimitReasonRate,
+			retryAfter: delay,
+		}
+	}
+
+	slots, reason := l.inFlight, LimitReasonInFlight
+	if longRunning {
+		slots, reason = l.longRunningInFlight, LimitReasonLongRunningInFlight
+	}
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	default:
+		return nil, &limitError{
+			reason:     reason,
+			retryAfter: inFlightRetryAfter,
+		}
+	}
+}
+
+// reserve takes a rate token of identity, and returns how long to wait for one if there's none left.
+func (l *requestLimiter) reserve(identity string) time.Duration {
+	if l.limitsConfig.RequestsPerSecond <= 0 {
+		return 0
+	}
+	now := time.Now()
+
+	l.lock.Lock()
+	entry, found := l.identities[identity]
+	if !found {
+		burst := l.limitsConfig.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		entry = &identityLimiter{
+			limiter: rate.NewLimiter(rate.Limit(l.limitsConfig.RequestsPerSecond), burst),
+		}
+		l.identities[identity] = entry
+	}
+	entry.lastSeen = now
+	l.sweep(now)
+	l.lock.Unlock()
+
+	reservation := entry.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		// the request is rejected rather than delayed, so it must not consume a future token.
+		reservation.CancelAt(now)
+	}
+	return delay
+}
+
+// sweep drops the limiters of identities idle for longer than identityLimiterTTL.
+// It must be called with the lock held.
+func (l *requestLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < identityLimiterTTL {
+		return
+	}
+	l.lastSweep = now
+	for identity, entry := range l.identities {
+		if now.Sub(entry.lastSeen) > identityLimiterTTL {
+			delete(l.identities, identity)
+		}
+	}
+}
+
+// isLongRunning returns true for requests that hold their connection open, like watches, exec or log streams.
+func isLongRunning(req *http.Request, info *RequestInfo) bool {
+	if info.Verb == VerbWatch || isUpgradeRequest(req) {
+		return true
+	}
+	if longRunningSubresources[info.Subresource] {
+		return true
+	}
+	return info.Subresource == "log" && req.URL.Query().Get("follow") == "true"
+}
+
+func (p *proxy) limitError(res http.ResponseWriter, attributes *Attributes, err *limitError) {
+	throttledTotal.Inc(err.reason)
+	klog.Infof("eks connector throttled %s %s for %s: %v",
+		attributes.Request.Verb, attributes.Request.Path, attributes.Principal.ARN, err)
+	retryAfter := int(math.Ceil(err.retryAfter.Seconds()))
+	res.Header().Set(HeaderRetryAfter, strconv.Itoa(retryAfter))
+	writeStatus(res, http.StatusTooManyRequests, metav1.StatusReasonTooManyRequests,
+		fmt.Sprintf("eks connector is throttling requests (%s limit), please try again later.", err.reason))
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+func TestLimitsSuite(t *testing.T) {
+	suite.Run(t, new(LimitsSuite))
+}
+
+type LimitsSuite struct {
+	suite.Suite
+}
+
+func (suite *LimitsSuite) TestAcquireUnlimited() {
+	// prepare
+	limiter := newRequestLimiter(&config.LimitsConfig{})
+
+	for i := 0; i < 100; i++ {
+		// test
+		release, err := limiter.acquire(testIAMIdentity, i%2 == 0)
+
+		// verify
+		suite.Nil(err)
+		suite.NotNil(release)
+	}
+}
+
+func (suite *LimitsSuite) TestAcquireRateLimitedPerIdentity() {
+	// prepare
+	limiter := newRequestLimiter(&config.LimitsConfig{
+		RequestsPerSecond: 0.1,
+		Burst:             2,
+	})
+
+	// test
+	_, err1 := limiter.acquire(testIAMIdentity, false)
+	_, err2 := limiter.acquire(testIAMIdentity, false)
+	_, err3 := limiter.acquire(testIAMIdentity, false)
+	_, otherErr := limiter.acquire(testAssumedRoleIdentity, false)
+
+	// verify
+	suite.Nil(err1)
+	suite.Nil(err2)
+	suite.NotNil(err3)
+	suite.Equal(LimitReasonRate, err3.reason)
+	suite.Greater(err3.retryAfter.Seconds(), 1.0)
+	suite.Nil(otherErr, "other identities have their own bucket")
+}
+
+func (suite *LimitsSuite) TestAcquireInFlight() {
+	// prepare
+	limiter := newRequestLimiter(&config.LimitsConfig{
+		MaxInFlight: 1,
+	})
+	release, err := limiter.acquire(testIAMIdentity, false)
+	suite.Nil(err)
+
+	// test
+	_, rejected := limiter.acquire(testAssumedRoleIdentity, false)
+	release()
+	_, afterRelease := limiter.acquire(testAssumedRoleIdentity, false)
+
+	// verify
+	suite.NotNil(rejected)
+	suite.Equal(LimitReasonInFlight, rejected.reason)
+	suite.Equal(inFlightRetryAfter, rejected.retryAfter)
+	suite.Nil(afterRelease)
+}
+
+func (suite *LimitsSuite) TestAcquireLongRunningBudget() {
+	// prepare
+	limiter := newRequestLimiter(&config.LimitsConfig{
+		MaxInFlight:            1,
+		MaxLongRunningInFlight: 1,
+	})
+	_, err := limiter.acquire(testIAMIdentity, true)
+	suite.Nil(err)
+
+	// test
+	_, watchErr := limiter.acquire(testIAMIdentity, true)
+	_, shortErr := limiter.acquire(testIAMIdentity, false)
+
+	// verify
+	suite.NotNil(watchErr)
+	suite.Equal(LimitReasonLongRunningInFlight, watchErr.reason)
+	suite.Nil(shortErr, "long-running requests do not use the budget of short requests")
+}
+
+func (suite *LimitsSuite) TestIsLongRunning() {
+	testCases := map[string]bool{
+		"/api/v1/pods":                                        false,
+		"/api/v1/pods?watch=true":                             true,
+		"/api/v1/watch/namespaces/default/pods":               true,
+		"/api/v1/namespaces/default/pods/web/log":             false,
+		"/api/v1/namespaces/default/pods/web/log?follow=true": true,
+		"/api/v1/namespaces/default/pods/web/exec":            true,
+		"/api/v1/namespaces/default/pods/web/portforward":     true,
+	}
+	for path, expected := range testCases {
+		// prepare
+		request := httptest.NewRequest("GET", path, nil)
+
+		// test
+		longRunning := isLongRunning(request, newRequestInfo(request))
+
+		// verify
+		suite.Equal(expected, longRunning, path)
+	}
+}
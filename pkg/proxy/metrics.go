@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"github.com/aws/amazon-eks-connector/pkg/metrics"
+)
+
+const (
+	budgetShort       = "short"
+	budgetLongRunning = "longrunning"
+)
+
+// verbOther is the verb label of requests with an unknown http method, which clients could vary at will.
+const verbOther = "other"
+
+// verbLabels are the verb label values of kubernetes verbs and of the lower-cased http methods of non-resource requests.
+var verbLabels = map[string]bool{
+	VerbGet:              true,
+	VerbList:             true,
+	VerbWatch:            true,
+	VerbCreate:           true,
+	VerbUpdate:           true,
+	VerbPatch:            true,
+	VerbDelete:           true,
+	VerbDeleteCollection: true,
+	"head":               true,
+	"post":               true,
+	"put":                true,
+	"options":            true,
+}
+
+// verbLabel returns the verb label of a request, so that the number of series is bounded.
+func verbLabel(verb string) string {
+	if verbLabels[verb] {
+		return verb
+	}
+	return verbOther
+}
+
+var (
+	requestsTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_proxy_requests_total",
+		Help: "Requests received by the proxy, by kubernetes verb and response code.",
+	}, "verb", "code")
+	requestDuration = metrics.NewHistogramVec(metrics.Opts{
+		Name: "eks_connector_proxy_request_duration_seconds",
+		Help: "Time to serve requests received by the proxy, by kubernetes verb and response code.",
+	}, metrics.DefBuckets, "verb", "code")
+	requestsInFlight = metrics.NewGaugeVec(metrics.Opts{
+		Name: "eks_connector_proxy_requests_in_flight",
+		Help: "Requests being served by the proxy, by in-flight budget.",
+	}, "budget")
+	upstreamErrorsTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_proxy_upstream_errors_total",
+		Help: "Requests the proxy failed to send to api server, by error type.",
+	}, "type")
+	targetHealthy = metrics.NewGaugeVec(metrics.Opts{
+		Name: "eks_connector_proxy_upstream_target_healthy",
+		Help: "Whether the last health check of an api server endpoint succeeded, by endpoint.",
+	}, "target")
+	targetEjectionsTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_proxy_upstream_target_ejections_total",
+		Help: "Times an api server endpoint was skipped after it refused a connection, by endpoint.",
+	}, "target")
+	signatureFailuresTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_proxy_identity_signature_failures_total",
+		Help: "Requests rejected because their IAM identity signature is invalid, by reason.",
+	}, "reason")
+	readOnlyMode = metrics.NewGaugeVec(metrics.Opts{
+		Name: "eks_connector_proxy_read_only",
+		Help: "Whether the proxy is in read-only mode.",
+	})
+	readOnlyRejectionsTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_proxy_read_only_rejections_total",
+		Help: "Requests rejected by the read-only mode of the proxy.",
+	})
+	throttledTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_proxy_throttled_requests_total",
+		Help: "Requests rejected with 429 by the proxy, by exceeded limit.",
+	}, "reason")
+	inFlightLimit = metrics.NewGaugeVec(metrics.Opts{
+		Name: "eks_connector_proxy_in_flight_limit",
+		Help: "Configured maximum of requests served concurrently by the proxy, by in-flight budget. 0 is unlimited.",
+	}, "budget")
+	rateLimit = metrics.NewGaugeVec(metrics.Opts{
+		Name: "eks_connector_proxy_rate_limit_requests_per_second",
+		Help: "Configured sustained rate of requests allowed per IAM identity. 0 is unlimited.",
+	})
+	sessionsActive = metrics.NewGaugeVec(metrics.Opts{
+		Name: "eks_connector_proxy_sessions_active",
+		Help: "Open exec, attach and port-forward sessions, by session type.",
+	}, "type")
+	sessionTimeoutsTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_proxy_session_timeouts_total",
+		Help: "Sessions closed by the proxy, by session type and expired timeout.",
+	}, "type", "timeout")
+	streamTimeoutsTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_proxy_stream_timeouts_total",
+		Help: "Watch and log follow responses ended by the proxy, by expired timeout.",
+	}, "timeout")
+	sessionRecordingErrorsTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_proxy_session_recording_errors_total",
+		Help: "Sessions rejected or closed by the proxy because they could not be recorded.",
+	})
+)
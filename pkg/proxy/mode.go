@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/http/httpguts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ModeReadWrite proxies any request allowed by the authorization policy and kubernetes RBAC.
+	ModeReadWrite = "readwrite"
+	// ModeReadOnly only proxies requests that cannot change the cluster,
+	// whatever RBAC grants to the impersonated identity.
+	ModeReadOnly = "readonly"
+
+	// subresourceProxy forwards requests to pods, services and nodes, whose endpoints may change state on a GET.
+	subresourceProxy = "proxy"
+)
+
+// readOnlyMethods are the http methods proxied in read-only mode.
+var readOnlyMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+// ValidateMode returns an error if mode is not a known proxy mode.
+func ValidateMode(mode string) error {
+	switch mode {
+	case "", ModeReadWrite, ModeReadOnly:
+		return nil
+	default:
+		return fmt.Errorf("unknown proxy mode %q", mode)
+	}
+}
+
+// readOnly returns whether req is served in read-only mode, by the proxy or by the listener it was received on.
+func (p *proxy) readOnly(req *http.Request) bool {
+	if options, ok := listenerOptionsFrom(req.Context()); ok && options.Mode != "" {
+		return options.Mode == ModeReadOnly
+	}
+	return p.ProxyConfig.Mode == ModeReadOnly
+}
+
+// allowedInMode returns false if the proxy mode forbids req.
+// Upgrade requests are forbidden in read-only mode as exec, attach and port-forward are GETs over an upgraded connection,
+// and so are proxy subresources which reach arbitrary endpoints in the cluster.
+func (p *proxy) allowedInMode(req *http.Request, attributes *Attributes) bool {
+	if !p.readOnly(req) {
+		return true
+	}
+	request := attributes.Request
+	if request.IsResourceRequest && request.Subresource == subresourceProxy {
+		return false
+	}
+	return readOnlyMethods[req.Method] && !isUpgradeRequest(req)
+}
+
+func (p *proxy) modeError(res http.ResponseWriter, attributes *Attributes, req *http.Request) {
+	readOnlyRejectionsTotal.Inc()
+	request := attributes.Request
+	klog.Infof("eks connector read-only mode rejected %s %s for %s", req.Method, request.Path, attributes.Principal.ARN)
+	writeStatus(res, http.StatusForbidden, metav1.StatusReasonForbidden,
+		fmt.Sprintf("eks connector is in read-only mode: %s %s is not allowed", req.Method, request.Path))
+}
+
+// isUpgradeRequest returns true if req asks to switch protocols, e.g. to SPDY or WebSocket.
+func isUpgradeRequest(req *http.Request) bool {
+	return httpguts.HeaderValuesContainsToken(req.Header["Connection"], "Upgrade") || req.Header.Get("Upgrade") != ""
+}
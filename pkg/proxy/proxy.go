@@ -1,14 +1,24 @@
 package proxy
 
 import (
-	"crypto/tls"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 
+	"github.com/aws/amazon-eks-connector/pkg/audit"
 	"github.com/aws/amazon-eks-connector/pkg/config"
+	"github.com/aws/amazon-eks-connector/pkg/identity"
+	"github.com/aws/amazon-eks-connector/pkg/recording"
 	"github.com/aws/amazon-eks-connector/pkg/serviceaccount"
 )
 
@@ -17,101 +27,320 @@ const (
 	HeaderUserAgent       = "User-Agent"
 	HeaderAuthorization   = "Authorization"
 	HeaderImpersonateUser = "Impersonate-User"
+	// HeaderImpersonateGroup may be repeated for each group.
+	HeaderImpersonateGroup = "Impersonate-Group"
+	// HeaderImpersonateExtraPrefix is followed by the url-encoded extra key.
+	HeaderImpersonateExtraPrefix = "Impersonate-Extra-"
 
 	HeaderValueUserAgent = "eks-connector/1.0"
-
-	StatusProxyError = 502
-	// MessageProxyError is the response body when there's any proxy level error occurs.
-	// It is a static json so we are putting it here without needing to serializing it on every request.
-	MessageProxyError = `{"status": 502, "message": "eks connector failed to proxy the request to kubernetes api. check eks connector logs for details."}`
 )
 
+// drainPollInterval is how often Drain checks whether all requests are served.
+const drainPollInterval = 100 * time.Millisecond
+
+// errIdentityMapping is returned when a valid IAM identity cannot be mapped to a kubernetes identity.
+var errIdentityMapping = errors.New("failed to map IAM identity")
+
+// errImpersonation is returned when a request to forward carries no kubernetes identity to impersonate.
+var errImpersonation = errors.New("no kubernetes identity to impersonate")
+
+// Handler proxies requests to api server on behalf of EKS console users.
+type Handler interface {
+	http.Handler
+	// CheckUpstream returns an error if api server is not ready or cannot be reached.
+	CheckUpstream(ctx context.Context) error
+	// Drain stops the health checks of the api server endpoints,
+	// ends open watch, log follow and session requests, and those started afterwards,
+	// then waits until all requests are served or ctx is done.
+	Drain(ctx context.Context) error
+}
+
 type proxy struct {
-	ProxyConfig    *config.ProxyConfig
-	ServiceAccount serviceaccount.SecretProvider
+	ProxyConfig       *config.ProxyConfig
+	ServiceAccount    serviceaccount.SecretProvider
+	IdentityVerifier  IdentityVerifier
+	IdentityValidator identity.Validator
+	IdentityMapper    identity.Mapper
+	Authorizer        Authorizer
+	Auditor           audit.Logger
+	Recorder          recording.Recorder
+
+	headerPolicy *headerPolicy
+	limiter      *requestLimiter
+	sessions     *sessionLimiter
+	targets      *targetPool
+	watchdogs    *watchdogSet
+	upstreamLock sync.RWMutex
+	current      *upstream
+	// inFlight is the number of requests being served.
+	inFlight int64
+
+	// stopHealthChecks ends the health checks of the endpoints, healthChecks waits for them to end.
+	stopHealthChecks context.CancelFunc
+	healthChecks     sync.WaitGroup
 }
 
 func NewProxyHandler(proxyConfig *config.ProxyConfig,
-	serviceAccountProvider serviceaccount.SecretProvider) http.Handler {
-	return &proxy{
-		ProxyConfig:    proxyConfig,
-		ServiceAccount: serviceAccountProvider,
+	serviceAccountProvider serviceaccount.SecretProvider,
+	identityVerifier IdentityVerifier,
+	identityMapper identity.Mapper,
+	authorizer Authorizer,
+	auditor audit.Logger,
+	recorder recording.Recorder) Handler {
+	if proxyConfig.Mode == ModeReadOnly {
+		klog.Infof("eks connector proxy is in read-only mode, mutating and upgrade requests are rejected")
+		readOnlyMode.Set(1)
+	} else {
+		readOnlyMode.Set(0)
+	}
+	inFlightLimit.Set(float64(proxyConfig.Limits.MaxInFlight), budgetShort)
+	inFlightLimit.Set(float64(proxyConfig.Limits.MaxLongRunningInFlight), budgetLongRunning)
+	rateLimit.Set(proxyConfig.Limits.RequestsPerSecond)
+	p := &proxy{
+		ProxyConfig:       proxyConfig,
+		ServiceAccount:    serviceAccountProvider,
+		IdentityVerifier:  identityVerifier,
+		IdentityValidator: identity.NewValidator(&proxyConfig.Identity),
+		IdentityMapper:    identityMapper,
+		Authorizer:        authorizer,
+		Auditor:           auditor,
+		Recorder:          recorder,
+		headerPolicy:      newHeaderPolicy(&proxyConfig.Headers),
+		limiter:           newRequestLimiter(&proxyConfig.Limits),
+		sessions:          newSessionLimiter(&proxyConfig.Sessions),
+		targets:           newTargetPool(proxyConfig),
+		watchdogs:         newWatchdogSet(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stopHealthChecks = cancel
+	if len(p.targets.targets) > 1 && proxyConfig.Failover.HealthCheckInterval > 0 {
+		p.healthChecks.Add(1)
+		go func() {
+			defer p.healthChecks.Done()
+			p.checkTargets(ctx)
+		}()
 	}
+	return p
 }
 
-func (p *proxy) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	url := p.proxyUrl(req)
+func (p *proxy) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&p.inFlight, 1)
+	defer atomic.AddInt64(&p.inFlight, -1)
+	start := time.Now()
+	requestID := uuid.New().String()
+	info := newRequestInfo(req)
+	longRunning := isLongRunning(req, info)
+	res := newResponseRecorder(writer)
+	res.Header().Set(HeaderAuditID, requestID)
+	body := newBodyRecorder(req.Body, p.Auditor.Level() == audit.LevelRequest)
+	if req.Body != nil {
+		req.Body = body
+	}
+	req = req.WithContext(withRequestID(req.Context(), requestID))
+
+	budget := budgetShort
+	if longRunning {
+		budget = budgetLongRunning
+	}
+	requestsInFlight.Inc(budget)
+
+	var attributes *Attributes
+	defer func() {
+		requestsInFlight.Dec(budget)
+		code, verb := strconv.Itoa(res.code), verbLabel(info.Verb)
+		requestsTotal.Inc(verb, code)
+		requestDuration.Observe(time.Since(start).Seconds(), verb, code)
+		p.audit(start, requestID, req, info, attributes, res, body)
+	}()
 
-	reverseProxy, err := p.reverseProxy(url)
+	attributes, err := p.authenticate(req, info)
 	if err != nil {
-		p.proxyError(res, req, err)
-	} else {
-		reverseProxy.ServeHTTP(res, req)
+		p.identityError(res, req, err)
+		return
+	}
+	if !p.allowedInMode(req, attributes) {
+		p.modeError(res, attributes, req)
+		return
+	}
+	if allowed, reason := p.Authorizer.Authorize(attributes); !allowed {
+		p.authorizationError(res, attributes, reason)
+		return
+	}
+	// limits apply to the role rather than to each of its sessions, which the requester names.
+	release, limitErr := p.limiter.acquire(attributes.Principal.CanonicalARN, longRunning)
+	if limitErr != nil {
+		p.limitError(res, attributes, limitErr)
+		return
+	}
+	defer release()
+
+	req = req.WithContext(withAttributes(req.Context(), attributes))
+
+	session := sessionType(info)
+	if session != "" {
+		endSession, ok := p.startSession(res, req, attributes, session)
+		if !ok {
+			return
+		}
+		defer endSession()
 	}
-}
 
-func (p *proxy) reverseProxy(target *url.URL) (*httputil.ReverseProxy, error) {
 	secret, err := p.ServiceAccount.Get()
+	if err != nil {
+		p.proxyError(res, req, fmt.Errorf("%w: %v", errServiceAccount, err))
+		return
+	}
+	upstream := p.upstream(secret)
+	if session != "" && isUpgradeRequest(req) {
+		upstream.sessionProxy.ServeHTTP(res, req)
+		return
+	}
+	if longRunning {
+		upstream.streamProxy.ServeHTTP(res, req)
+		return
+	}
+	req, cancel := withRequestTimeout(req, &p.ProxyConfig.Timeouts)
+	defer cancel()
+	upstream.reverseProxy.ServeHTTP(res, req)
+}
+
+func (p *proxy) Drain(ctx context.Context) error {
+	// the process is shutting down or handing off to a new one, which checks the endpoints itself.
+	p.stopHealthChecks()
+	p.healthChecks.Wait()
+	p.watchdogs.drain()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&p.inFlight) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// authenticate verifies and validates the requester IAM identity, and maps it to the kubernetes identity to impersonate.
+func (p *proxy) authenticate(req *http.Request, info *RequestInfo) (*Attributes, error) {
+	// extract iam identity from original request header
+	iamIdentity := req.Header.Get(HeaderIamArn)
+	klog.V(2).Infof("requester IAM identity is %s", iamIdentity)
+
+	if options, ok := listenerOptionsFrom(req.Context()); !ok || !options.TrustIdentity {
+		if err := p.IdentityVerifier.Verify(req, iamIdentity); err != nil {
+			return nil, err
+		}
+	}
+	principal, err := p.IdentityValidator.Validate(iamIdentity)
 	if err != nil {
 		return nil, err
 	}
-	director := func(req *http.Request) {
-		// override the scheme and host.
-		req.URL.Scheme = target.Scheme
-		req.URL.Host = target.Host
-		req.URL.RawQuery = target.RawQuery
-		req.URL.Path = target.Path
-		req.URL.RawPath = target.RawPath
-
-		klog.V(2).Infof("rewritten URL to %s", req.URL)
-
-		p.proxyHeader(req, secret)
-	}
-	return &httputil.ReverseProxy{
-		Director: director,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs: secret.RootCAs,
-			},
-		},
-		ErrorHandler: p.proxyError,
+	user, err := p.IdentityMapper.Map(iamIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errIdentityMapping, err)
+	}
+	return &Attributes{
+		Principal: principal,
+		User:      user,
+		Request:   info,
 	}, nil
 }
 
-func (p *proxy) proxyError(res http.ResponseWriter, req *http.Request, err error) {
-	klog.Infof("eks connector proxy encountered error: %v", err)
-	res.WriteHeader(StatusProxyError)
-	_, responseError := res.Write([]byte(MessageProxyError))
-	if responseError != nil {
-		klog.Error("eks connector proxy failed to write error response: %v", err)
+func (p *proxy) identityError(res http.ResponseWriter, req *http.Request, err error) {
+	klog.Infof("eks connector rejected request %s %s: %v", req.Method, req.URL.Path, err)
+	switch {
+	case errors.Is(err, identity.ErrNotAllowed), errors.Is(err, errIdentityMapping):
+		writeStatus(res, http.StatusForbidden, metav1.StatusReasonForbidden,
+			"eks connector does not allow the requester IAM identity. check eks connector logs for details.")
+	case errors.Is(err, errSignature):
+		writeStatus(res, http.StatusUnauthorized, metav1.StatusReasonUnauthorized,
+			fmt.Sprintf("eks connector requires a valid signature of the requester IAM identity in %s header. "+
+				"check eks connector logs for details.", HeaderIdentitySignature))
+	default:
+		writeStatus(res, http.StatusUnauthorized, metav1.StatusReasonUnauthorized,
+			fmt.Sprintf("eks connector requires a valid IAM identity in %s header.", HeaderIamArn))
 	}
 }
 
-func (p *proxy) proxyHeader(req *http.Request, secret *serviceaccount.Secret) {
-	// for security reasons we start with a new header map.
+func (p *proxy) authorizationError(res http.ResponseWriter, attributes *Attributes, reason string) {
+	request := attributes.Request
+	klog.Infof("eks connector policy denied %s %s for %s: %s",
+		request.Verb, request.Path, attributes.Principal.ARN, reason)
+
+	var message string
+	if request.IsResourceRequest {
+		message = fmt.Sprintf("%s %q is forbidden: User %q cannot %s resource %q in API group %q",
+			request.ResourceName(), request.Name, attributes.User.Username, request.Verb, request.ResourceName(), request.APIGroup)
+		if request.Namespace != "" {
+			message += fmt.Sprintf(" in the namespace %q", request.Namespace)
+		}
+	} else {
+		message = fmt.Sprintf("forbidden: User %q cannot %s path %q", attributes.User.Username, request.Verb, request.Path)
+	}
+	writeStatus(res, http.StatusForbidden, metav1.StatusReasonForbidden,
+		fmt.Sprintf("%s: denied by eks connector policy %q", message, reason))
+}
+
+// proxyHeader sets the headers of a request forwarded to api server.
+// It returns errImpersonation if the request carries no kubernetes identity, which must not be forwarded.
+func (p *proxy) proxyHeader(req *http.Request, secret *serviceaccount.Secret) error {
+	// for security reasons we start with a new header map that only has headers allowed by the header policy.
 	originalHeader := req.Header
-	req.Header = http.Header{}
+	req.Header = p.headerPolicy.filter(originalHeader)
 
-	// extract iam identity from original request header
-	iamIdentity := originalHeader.Get(HeaderIamArn)
-	klog.V(2).Infof("requester IAM identity is %s", iamIdentity)
-	req.Header.Set(HeaderImpersonateUser, iamIdentity)
+	// impersonate the kubernetes identity mapped from iam identity
+	var user *identity.Identity
+	if attributes, ok := attributesFrom(req.Context()); ok {
+		user = attributes.User
+	}
+	if err := p.impersonateHeader(req.Header, user); err != nil {
+		return err
+	}
 
-	// inject ServiceAccount token to authorization
-	req.Header.Set(HeaderAuthorization, "Bearer "+secret.Token)
+	// let api server audit the request under the same ID as eks connector.
+	if requestID, ok := requestIDFrom(req.Context()); ok {
+		req.Header.Set(HeaderAuditID, requestID)
+	}
+
+	// inject ServiceAccount token to authorization, unless eks connector authenticates with a client certificate.
+	if secret.Token != "" {
+		req.Header.Set(HeaderAuthorization, "Bearer "+secret.Token)
+	}
 
 	// common headers
 	req.Header.Set(HeaderUserAgent, HeaderValueUserAgent)
+	return nil
+}
+
+func (p *proxy) impersonateHeader(header http.Header, user *identity.Identity) error {
+	if user == nil || user.Username == "" {
+		// this should never happen as ServeHTTP always authenticates the user. api server ignores
+		// an empty Impersonate-User, the request would fall back to the service account permissions.
+		return errImpersonation
+	}
+	klog.V(2).Infof("impersonating user %s with groups %v", user.Username, user.Groups)
+	header.Set(HeaderImpersonateUser, user.Username)
+	for _, group := range user.Groups {
+		header.Add(HeaderImpersonateGroup, group)
+	}
+	for key, values := range user.Extra {
+		extraHeader := HeaderImpersonateExtraPrefix + url.PathEscape(key)
+		for _, value := range values {
+			header.Add(extraHeader, value)
+		}
+	}
+	return nil
 }
 
 func (p *proxy) proxyUrl(req *http.Request) *url.URL {
 	url := &url.URL{
 		Scheme:   p.ProxyConfig.TargetProtocol,
-		Host:     p.ProxyConfig.TargetHost,
 		Path:     req.URL.Path,
 		RawPath:  req.URL.RawPath,
 		RawQuery: req.URL.RawQuery,
 	}
+	url.Host, _ = p.targets.pick(nil)
 
 	klog.V(2).Infof("proxy url is %s", url)
 
@@ -1,25 +1,45 @@
 package proxy
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"github.com/aws/amazon-eks-connector/pkg/audit"
+	"github.com/aws/amazon-eks-connector/pkg/config"
+	"github.com/aws/amazon-eks-connector/pkg/identity"
+	"github.com/aws/amazon-eks-connector/pkg/metrics"
+	"github.com/aws/amazon-eks-connector/pkg/recording"
 	"github.com/aws/amazon-eks-connector/pkg/serviceaccount"
 )
 
 const (
-	testServiceAccountToken = "rUVGEcNVnKg84iTob13n"
-	testIAMIdentity         = "arn:aws:iam:123456789012::role/coder"
-	testHttpResponse        = "OOMKill"
-	testOriginalUserAgent   = "java/11"
-	testCustomRequestHeader = "x-header-will-not-forward"
-	testCustomQueryString   = "next=dUKQYLVdXnJlYjr386XA"
+	testServiceAccountToken        = "rUVGEcNVnKg84iTob13n"
+	testRotatedServiceAccountToken = "fJ0ZkKqWc2yR7PxGzL4m"
+	testIAMIdentity                = "arn:aws:iam::123456789012:role/coder"
+	testAssumedRoleIdentity        = "arn:aws:sts::123456789012:assumed-role/Viewer/alice"
+	testHttpResponse               = "OOMKill"
+	testOriginalUserAgent          = "java/11"
+	testCustomRequestHeader        = "x-header-will-not-forward"
+	testCustomQueryString          = "next=dUKQYLVdXnJlYjr386XA"
+	testTableAccept                = "application/json;as=Table;v=v1;g=meta.k8s.io,application/json"
 )
 
 func TestProxySuite(t *testing.T) {
@@ -31,7 +51,7 @@ type ProxySuite struct {
 
 	secretProvider *serviceaccount.MockSecretProvider
 	targetServer   *mockServer
-	proxyHandler   http.Handler
+	proxyHandler   Handler
 }
 
 func (suite *ProxySuite) SetupTest() {
@@ -41,6 +61,11 @@ func (suite *ProxySuite) SetupTest() {
 	suite.proxyHandler = NewProxyHandler(
 		suite.targetServer.ProxyConfig(),
 		suite.secretProvider,
+		NewTrustedIdentityVerifier(),
+		identity.NewPassthroughMapper(),
+		NewAlwaysAllowAuthorizer(),
+		audit.NewNopLogger(),
+		recording.NewNopRecorder(),
 	)
 }
 
@@ -68,8 +93,10 @@ func (suite *ProxySuite) TestServeHTTPHappyCase() {
 	// verify
 	suite.Len(suite.targetServer.requests, 1)
 	proxyRequest := suite.targetServer.requests[0]
-	suite.Equal(5, proxyRequest.HeaderCount())
+	suite.Equal(6, proxyRequest.HeaderCount())
 	suite.Equal(bearer(testServiceAccountToken), proxyRequest.Header(HeaderAuthorization))
+	suite.NotEmpty(proxyRequest.Header(HeaderAuditID))
+	suite.Equal([]string{proxyRequest.Header(HeaderAuditID)}, response.Header().Values(HeaderAuditID), "request ID is sent back once")
 	suite.Equal(testIAMIdentity, proxyRequest.Header(HeaderImpersonateUser))
 	suite.Equal(HeaderValueUserAgent, proxyRequest.Header(HeaderUserAgent))
 	suite.Empty(proxyRequest.Header(testCustomRequestHeader), "custom header is not forwarded")
@@ -81,6 +108,948 @@ func (suite *ProxySuite) TestServeHTTPHappyCase() {
 	suite.secretProvider.AssertExpectations(suite.T())
 }
 
+func (suite *ProxySuite) TestServeHTTPHeaderPolicy() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Headers.Allow = []string{"X-Custom-*"}
+	proxyConfig.Headers.Deny = []string{"If-None-Match"}
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+	request.Header.Set("Accept", testTableAccept)
+	request.Header.Set("X-Custom-Trace", "trace")
+	request.Header.Set("If-None-Match", "etag")
+	request.Header.Set(HeaderAuthorization, bearer("client-token"))
+	request.Header.Set("Impersonate-Group", "system:masters")
+	request.Header.Set("Impersonate-Extra-Scopes", "admin")
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Equal(200, response.Code)
+	suite.Len(suite.targetServer.requests, 1)
+	proxyRequest := suite.targetServer.requests[0]
+	suite.Equal(testTableAccept, proxyRequest.Header("Accept"), "default allowed header is forwarded")
+	suite.Equal("trace", proxyRequest.Header("X-Custom-Trace"), "configured allowed header is forwarded")
+	suite.Empty(proxyRequest.Header("If-None-Match"), "configured denied header is not forwarded")
+	suite.Equal(bearer(testServiceAccountToken), proxyRequest.Header(HeaderAuthorization), "client authorization is replaced")
+	suite.Empty(proxyRequest.Header("Impersonate-Group"), "client impersonation is not forwarded")
+	suite.Empty(proxyRequest.Header("Impersonate-Extra-Scopes"), "client impersonation is not forwarded")
+	suite.Empty(proxyRequest.Header(HeaderIamArn), "iam identity header is not forwarded")
+	suite.Equal(testIAMIdentity, proxyRequest.Header(HeaderImpersonateUser))
+}
+
+func (suite *ProxySuite) TestServeHTTPMappedIdentity() {
+	// prepare
+	identityMapper, err := identity.NewMapper(&identity.MappingConfig{
+		MapRoles: []identity.RoleMapping{{
+			RoleARN:  "arn:aws:iam::123456789012:role/console/Viewer",
+			Username: "viewer:{{SessionName}}",
+			Groups:   []string{"console-viewers", "system:authenticated"},
+		}},
+	})
+	suite.NoError(err)
+	proxyHandler := NewProxyHandler(suite.targetServer.ProxyConfig(), suite.secretProvider, NewTrustedIdentityVerifier(), identityMapper, NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+	request.Header.Set(HeaderIamArn, testAssumedRoleIdentity)
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Equal(200, response.Code)
+	suite.Len(suite.targetServer.requests, 1)
+	proxyRequest := suite.targetServer.requests[0]
+	suite.Equal("viewer:alice", proxyRequest.Header(HeaderImpersonateUser))
+	suite.Equal([]string{"console-viewers", "system:authenticated"}, proxyRequest.rawRequest.Header.Values(HeaderImpersonateGroup))
+	suite.Equal(testAssumedRoleIdentity, proxyRequest.Header("Impersonate-Extra-Arn"))
+	suite.Equal("arn:aws:iam::123456789012:role/Viewer", proxyRequest.Header("Impersonate-Extra-Canonicalarn"))
+	suite.Equal("alice", proxyRequest.Header("Impersonate-Extra-Sessionname"))
+	suite.Equal("123456789012", proxyRequest.Header("Impersonate-Extra-Accountid"))
+}
+
+func (suite *ProxySuite) TestServeHTTPIdentityMappingError() {
+	// prepare
+	identityMapper := &identity.MockMapper{}
+	identityMapper.On("Map", testIAMIdentity).Return(nil, errors.New("mapping error"))
+	proxyHandler := NewProxyHandler(suite.targetServer.ProxyConfig(), suite.secretProvider, NewTrustedIdentityVerifier(), identityMapper, NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Len(suite.targetServer.requests, 0)
+	suite.assertStatus(response, 403, metav1.StatusReasonForbidden)
+	suite.secretProvider.AssertNotCalled(suite.T(), "Get")
+	identityMapper.AssertExpectations(suite.T())
+}
+
+func (suite *ProxySuite) TestServeHTTPEmptyUsername() {
+	// prepare
+	identityMapper := &identity.MockMapper{}
+	identityMapper.On("Map", testIAMIdentity).Return(&identity.Identity{Groups: []string{"system:authenticated"}}, nil)
+	proxyHandler := NewProxyHandler(suite.targetServer.ProxyConfig(), suite.secretProvider, NewTrustedIdentityVerifier(), identityMapper, NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Len(suite.targetServer.requests, 0, "api server would ignore an empty Impersonate-User")
+	suite.assertProxyError(response, 500, metav1.StatusReasonInternalError, UpstreamErrorImpersonation)
+}
+
+func (suite *ProxySuite) TestServeHTTPMissingIdentity() {
+	// prepare
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+
+	// test
+	suite.proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Len(suite.targetServer.requests, 0)
+	suite.assertStatus(response, 401, metav1.StatusReasonUnauthorized)
+	suite.secretProvider.AssertNotCalled(suite.T(), "Get")
+}
+
+func (suite *ProxySuite) TestServeHTTPMalformedIdentity() {
+	// prepare
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+	request.Header.Set(HeaderIamArn, "arn:aws:iam:123456789012::role/coder")
+
+	// test
+	suite.proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Len(suite.targetServer.requests, 0)
+	suite.assertStatus(response, 401, metav1.StatusReasonUnauthorized)
+}
+
+func (suite *ProxySuite) TestServeHTTPIdentityNotAllowed() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Identity.AllowedAccountIDs = []string{"210987654321"}
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Len(suite.targetServer.requests, 0)
+	suite.assertStatus(response, 403, metav1.StatusReasonForbidden)
+}
+
+func (suite *ProxySuite) TestServeHTTPSignedIdentity() {
+	// prepare
+	proxyHandler := NewProxyHandler(suite.targetServer.ProxyConfig(), suite.secretProvider, suite.hmacVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	requestBody := `{"kind":"ConfigMap","metadata":{"name":"settings"}}`
+	request := httptest.NewRequest("POST", "http://foo-bar:12345/api/v1/namespaces/default/configmaps", strings.NewReader(requestBody))
+	signIdentity(request, testIAMIdentity, []byte(requestBody), hmacSigner([]byte(testHMACKey)))
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Equal(200, response.Code)
+	suite.Len(suite.targetServer.requests, 1)
+	proxyRequest := suite.targetServer.requests[0]
+	suite.Equal(testIAMIdentity, proxyRequest.Header(HeaderImpersonateUser))
+	suite.Empty(proxyRequest.Header(HeaderIdentitySignature), "signature headers are not forwarded")
+	suite.Empty(proxyRequest.Header(HeaderIdentityNonce), "signature headers are not forwarded")
+}
+
+func (suite *ProxySuite) TestServeHTTPUnsignedIdentity() {
+	// prepare
+	proxyHandler := NewProxyHandler(suite.targetServer.ProxyConfig(), suite.secretProvider, suite.hmacVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Len(suite.targetServer.requests, 0)
+	suite.assertStatus(response, 401, metav1.StatusReasonUnauthorized)
+	suite.Contains(response.Body.String(), HeaderIdentitySignature)
+}
+
+func (suite *ProxySuite) TestServeHTTPSignedIdentityTamperedBody() {
+	// prepare
+	proxyHandler := NewProxyHandler(suite.targetServer.ProxyConfig(), suite.secretProvider, suite.hmacVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "http://foo-bar:12345/api/v1/namespaces/default/pods",
+		strings.NewReader(`{"kind":"Pod","spec":{"hostPID":true}}`))
+	signIdentity(request, testIAMIdentity, []byte(`{"kind":"Pod"}`), hmacSigner([]byte(testHMACKey)))
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.assertStatus(response, 400, metav1.StatusReasonBadRequest)
+}
+
+func (suite *ProxySuite) TestServeHTTPListenerTrustsIdentity() {
+	// prepare
+	proxyHandler := NewProxyHandler(suite.targetServer.ProxyConfig(), suite.secretProvider, suite.hmacVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+	request = request.WithContext(WithListenerOptions(request.Context(), &ListenerOptions{TrustIdentity: true}))
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Equal(200, response.Code)
+	suite.Len(suite.targetServer.requests, 1)
+	suite.Equal(testIAMIdentity, suite.targetServer.requests[0].Header(HeaderImpersonateUser))
+}
+
+func (suite *ProxySuite) TestServeHTTPDeniedByPolicy() {
+	// prepare
+	authorizer := &MockAuthorizer{}
+	authorizer.On("Authorize", mock.MatchedBy(func(attributes *Attributes) bool {
+		return attributes.Principal.ARN == testIAMIdentity &&
+			attributes.User.Username == testIAMIdentity &&
+			attributes.Request.Verb == VerbGet &&
+			attributes.Request.ResourceName() == "secrets" &&
+			attributes.Request.Namespace == "kube-system"
+	})).Return(false, "no-kube-system-secrets")
+	proxyHandler := NewProxyHandler(suite.targetServer.ProxyConfig(), suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), authorizer, audit.NewNopLogger(), recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/namespaces/kube-system/secrets/token", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Len(suite.targetServer.requests, 0)
+	suite.assertStatus(response, 403, metav1.StatusReasonForbidden)
+	suite.Contains(response.Body.String(), "no-kube-system-secrets")
+	suite.secretProvider.AssertNotCalled(suite.T(), "Get")
+	authorizer.AssertExpectations(suite.T())
+}
+
+func (suite *ProxySuite) TestServeHTTPAudit() {
+	// prepare
+	auditor := &audit.MockLogger{}
+	auditor.On("Level").Return(audit.LevelRequest)
+	var event *audit.Event
+	auditor.On("Log", mock.Anything).Run(func(args mock.Arguments) {
+		event = args.Get(0).(*audit.Event)
+	})
+	proxyHandler := NewProxyHandler(suite.targetServer.ProxyConfig(), suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), auditor, recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	requestBody := `{"kind":"ConfigMap","metadata":{"name":"settings"}}`
+	request := httptest.NewRequest("POST", "http://foo-bar:12345/api/v1/namespaces/default/configmaps", strings.NewReader(requestBody))
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+	request.Header.Set(HeaderUserAgent, testOriginalUserAgent)
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Equal(200, response.Code)
+	auditor.AssertNumberOfCalls(suite.T(), "Log", 1)
+	suite.Equal(suite.targetServer.requests[0].Header(HeaderAuditID), event.RequestID)
+	suite.Equal(testIAMIdentity, event.IAMIdentity)
+	suite.Equal(testIAMIdentity, event.User)
+	suite.Equal(testOriginalUserAgent, event.UserAgent)
+	suite.Equal(VerbCreate, event.Verb)
+	suite.Equal("configmaps", event.Resource)
+	suite.Equal("default", event.Namespace)
+	suite.Equal(200, event.ResponseCode)
+	suite.Equal(int64(len(requestBody)), event.RequestBytes)
+	suite.Equal(int64(len(testHttpResponse)), event.ResponseBytes)
+	suite.JSONEq(requestBody, string(event.RequestObject))
+}
+
+func (suite *ProxySuite) TestServeHTTPAuditRedactsSecrets() {
+	// prepare
+	auditor := &audit.MockLogger{}
+	auditor.On("Level").Return(audit.LevelRequest)
+	var event *audit.Event
+	auditor.On("Log", mock.Anything).Run(func(args mock.Arguments) {
+		event = args.Get(0).(*audit.Event)
+	})
+	proxyHandler := NewProxyHandler(suite.targetServer.ProxyConfig(), suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), auditor, recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "http://foo-bar:12345/api/v1/namespaces/default/secrets",
+		strings.NewReader(`{"kind":"Secret","data":{"password":"aHVudGVyMg=="}}`))
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Equal(200, response.Code)
+	suite.Equal(audit.RedactedObject, event.RequestObject)
+	suite.NotContains(string(event.RequestObject), "aHVudGVyMg==")
+}
+
+func (suite *ProxySuite) TestServeHTTPAuditRejected() {
+	// prepare
+	auditor := &audit.MockLogger{}
+	auditor.On("Level").Return(audit.LevelMetadata)
+	var event *audit.Event
+	auditor.On("Log", mock.Anything).Run(func(args mock.Arguments) {
+		event = args.Get(0).(*audit.Event)
+	})
+	proxyHandler := NewProxyHandler(suite.targetServer.ProxyConfig(), suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), auditor, recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/namespaces/default/pods/web", nil)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.assertStatus(response, 401, metav1.StatusReasonUnauthorized)
+	suite.Equal(response.Header().Get(HeaderAuditID), event.RequestID)
+	suite.Empty(event.User)
+	suite.Equal(VerbGet, event.Verb)
+	suite.Equal("pods", event.Resource)
+	suite.Equal("web", event.Name)
+	suite.Equal(401, event.ResponseCode)
+	suite.Equal(int64(response.Body.Len()), event.ResponseBytes)
+	suite.Nil(event.RequestObject)
+}
+
+func (suite *ProxySuite) TestServeHTTPReadOnlyAllowsReads() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Mode = ModeReadOnly
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+
+	for _, method := range []string{"GET", "HEAD", "OPTIONS"} {
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(method, "http://foo-bar:12345/api/v1/pods?watch=true", nil)
+		request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+		// test
+		proxyHandler.ServeHTTP(response, request)
+
+		// verify
+		suite.Equal(200, response.Code, method)
+	}
+	suite.Len(suite.targetServer.requests, 3)
+}
+
+func (suite *ProxySuite) TestServeHTTPListenerMode() {
+	// prepare
+	readOnlyConfig := suite.targetServer.ProxyConfig()
+	readOnlyConfig.Mode = ModeReadOnly
+	readOnlyHandler := NewProxyHandler(readOnlyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+	newRequest := func(mode string) *http.Request {
+		request := httptest.NewRequest("POST", "http://foo-bar:12345/api/v1/namespaces/default/configmaps", strings.NewReader("{}"))
+		request.Header.Set(HeaderIamArn, testIAMIdentity)
+		return request.WithContext(WithListenerOptions(request.Context(), &ListenerOptions{Mode: mode}))
+	}
+	readOnlyResponse := httptest.NewRecorder()
+	readWriteResponse := httptest.NewRecorder()
+
+	// test
+	suite.proxyHandler.ServeHTTP(readOnlyResponse, newRequest(ModeReadOnly))
+	readOnlyHandler.ServeHTTP(readWriteResponse, newRequest(ModeReadWrite))
+
+	// verify
+	suite.assertStatus(readOnlyResponse, 403, metav1.StatusReasonForbidden)
+	suite.Contains(readOnlyResponse.Body.String(), "read-only mode")
+	suite.Equal(200, readWriteResponse.Code, "the listener mode overrides the proxy mode")
+	suite.Len(suite.targetServer.requests, 1)
+}
+
+func (suite *ProxySuite) TestServeHTTPReadOnlyRejectsWrites() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Mode = ModeReadOnly
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	execRequest := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/namespaces/default/pods/web/exec?command=sh", nil)
+	execRequest.Header.Set("Connection", "Upgrade")
+	execRequest.Header.Set("Upgrade", "SPDY/3.1")
+	requests := []*http.Request{
+		httptest.NewRequest("POST", "http://foo-bar:12345/api/v1/namespaces/default/pods", strings.NewReader("{}")),
+		httptest.NewRequest("PUT", "http://foo-bar:12345/api/v1/namespaces/default/pods/web", strings.NewReader("{}")),
+		httptest.NewRequest("PATCH", "http://foo-bar:12345/api/v1/namespaces/default/pods/web", strings.NewReader("{}")),
+		httptest.NewRequest("DELETE", "http://foo-bar:12345/api/v1/namespaces/default/pods/web", nil),
+		execRequest,
+		httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/namespaces/default/pods/web/proxy/shutdown", nil),
+		httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/namespaces/default/services/web:80/proxy/", nil),
+		httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/nodes/node-1/proxy/configz", nil),
+	}
+
+	for _, request := range requests {
+		response := httptest.NewRecorder()
+		request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+		// test
+		proxyHandler.ServeHTTP(response, request)
+
+		// verify
+		suite.assertStatus(response, 403, metav1.StatusReasonForbidden)
+		suite.Contains(response.Body.String(), "read-only mode")
+	}
+	suite.Len(suite.targetServer.requests, 0)
+	suite.secretProvider.AssertNotCalled(suite.T(), "Get")
+}
+
+func (suite *ProxySuite) TestServeHTTPRateLimited() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Limits.RequestsPerSecond = 0.1
+	proxyConfig.Limits.Burst = 1
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+	responses := []*httptest.ResponseRecorder{httptest.NewRecorder(), httptest.NewRecorder()}
+	// sessions of the same role share its budget.
+	iamIdentities := []string{testAssumedRoleIdentity, "arn:aws:sts::123456789012:assumed-role/Viewer/bob"}
+
+	// test
+	for i, response := range responses {
+		request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+		request.Header.Set(HeaderIamArn, iamIdentities[i])
+		proxyHandler.ServeHTTP(response, request)
+	}
+
+	// verify
+	suite.Equal(200, responses[0].Code)
+	suite.assertStatus(responses[1], 429, metav1.StatusReasonTooManyRequests)
+	suite.Equal("10", responses[1].Header().Get(HeaderRetryAfter))
+	suite.Len(suite.targetServer.requests, 1)
+}
+
+func (suite *ProxySuite) TestServeHTTPMetrics() {
+	// prepare
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/namespaces/default/pods/web", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+	// test
+	suite.proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Equal(200, response.Code)
+	var exposition bytes.Buffer
+	suite.NoError(metrics.DefaultRegistry.Write(&exposition))
+	suite.Contains(exposition.String(), `eks_connector_proxy_requests_total{verb="get",code="200"}`)
+	suite.Contains(exposition.String(), `eks_connector_proxy_request_duration_seconds_count{verb="get",code="200"}`)
+	suite.Contains(exposition.String(), `eks_connector_proxy_requests_in_flight{budget="short"} 0`)
+}
+
+func (suite *ProxySuite) TestServeHTTPMetricsUnknownMethod() {
+	// prepare
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("X-UNBOUNDED-1", "http://foo-bar:12345/version", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+	// test
+	suite.proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	var exposition bytes.Buffer
+	suite.NoError(metrics.DefaultRegistry.Write(&exposition))
+	suite.Contains(exposition.String(), `eks_connector_proxy_requests_total{verb="other",`)
+	suite.NotContains(exposition.String(), "x-unbounded-1")
+}
+
+func (suite *ProxySuite) TestServeHTTPSession() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Sessions.EnableExec = true
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newEchoSessionHandler()
+	frontend := httptest.NewServer(proxyHandler)
+	defer frontend.Close()
+
+	// test
+	conn, response := suite.openSession(frontend, "exec", "SPDY/3.1")
+	defer conn.Close()
+	_, err := conn.Write([]byte("ls /"))
+	suite.NoError(err)
+	echo := make([]byte, 4)
+	_, err = io.ReadFull(conn, echo)
+
+	// verify
+	suite.Equal(http.StatusSwitchingProtocols, response.StatusCode)
+	suite.NoError(err)
+	suite.Equal("ls /", string(echo))
+	suite.Len(suite.targetServer.requests, 1)
+	proxyRequest := suite.targetServer.requests[0]
+	suite.Equal("SPDY/3.1", proxyRequest.Header("Upgrade"))
+	suite.Equal("v4.channel.k8s.io", proxyRequest.Header("X-Stream-Protocol-Version"))
+	suite.Equal(bearer(testServiceAccountToken), proxyRequest.Header(HeaderAuthorization))
+	suite.Equal(testIAMIdentity, proxyRequest.Header(HeaderImpersonateUser))
+}
+
+func (suite *ProxySuite) TestServeHTTPSessionIdleTimeout() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Sessions.EnableAttach = true
+	proxyConfig.Sessions.IdleTimeout = 100 * time.Millisecond
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newEchoSessionHandler()
+	frontend := httptest.NewServer(proxyHandler)
+	defer frontend.Close()
+	conn, response := suite.openSession(frontend, "attach", "SPDY/3.1")
+	defer conn.Close()
+	suite.Equal(http.StatusSwitchingProtocols, response.StatusCode)
+
+	// test
+	suite.NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	_, err := conn.Read(make([]byte, 1))
+
+	// verify
+	suite.Equal(io.EOF, err, "the proxy closes idle sessions")
+}
+
+func (suite *ProxySuite) TestServeHTTPSessionDisabled() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Sessions.EnableExec = true
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "http://foo-bar:12345/api/v1/namespaces/default/pods/web/portforward", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "SPDY/3.1")
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	status := suite.assertStatus(response, 403, metav1.StatusReasonForbidden)
+	suite.Contains(status.Message, "portforward is disabled")
+	suite.Len(suite.targetServer.requests, 0)
+	suite.secretProvider.AssertNotCalled(suite.T(), "Get")
+}
+
+func (suite *ProxySuite) TestServeHTTPSessionLimited() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Sessions.EnableExec = true
+	proxyConfig.Sessions.MaxPerIdentity = 1
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newEchoSessionHandler()
+	frontend := httptest.NewServer(proxyHandler)
+	defer frontend.Close()
+	conn, _ := suite.openSessionAs(frontend, testAssumedRoleIdentity, "exec", "SPDY/3.1")
+	defer conn.Close()
+
+	// test
+	other, response := suite.openSessionAs(frontend, "arn:aws:sts::123456789012:assumed-role/Viewer/bob", "exec", "SPDY/3.1")
+	defer other.Close()
+
+	// verify
+	suite.Equal(http.StatusTooManyRequests, response.StatusCode)
+	suite.Equal("10", response.Header.Get(HeaderRetryAfter))
+	suite.Len(suite.targetServer.requests, 1)
+}
+
+func (suite *ProxySuite) TestServeHTTPSessionRecorded() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Sessions.EnableExec = true
+	recordingDir := suite.T().TempDir()
+	recorder, err := recording.NewRecorder(&config.RecordingConfig{Dir: recordingDir})
+	suite.Require().NoError(err)
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recorder)
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newEchoSessionHandler()
+	frontend := httptest.NewServer(proxyHandler)
+	defer frontend.Close()
+	// a masked binary websocket frame of "id" on stdin, which the handler echoes.
+	frame := []byte{0x82, 0x83, 0x01, 0x02, 0x03, 0x04, 0x00 ^ 0x01, 'i' ^ 0x02, 'd' ^ 0x03}
+
+	// test
+	conn, response := suite.openSession(frontend, "exec", "websocket")
+	defer conn.Close()
+	_, err = conn.Write(frame)
+	suite.NoError(err)
+	echo := make([]byte, len(frame))
+	_, err = io.ReadFull(conn, echo)
+
+	// verify
+	suite.Equal(http.StatusSwitchingProtocols, response.StatusCode)
+	suite.NoError(err)
+	suite.Equal(frame, echo)
+	files, err := filepath.Glob(filepath.Join(recordingDir, "*.cast"))
+	suite.NoError(err)
+	suite.Require().Len(files, 1)
+	recorded, err := os.ReadFile(files[0])
+	suite.NoError(err)
+	suite.Contains(string(recorded), `"iamIdentity":"`+testIAMIdentity+`"`)
+	suite.Contains(string(recorded), `"i","id"]`, "stdin is recorded before it is forwarded")
+}
+
+func (suite *ProxySuite) TestServeHTTPSessionRecordedWithoutExtensions() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Sessions.EnableExec = true
+	recorder, err := recording.NewRecorder(&config.RecordingConfig{Dir: suite.T().TempDir()})
+	suite.Require().NoError(err)
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recorder)
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newEchoSessionHandler()
+	frontend := httptest.NewServer(proxyHandler)
+	defer frontend.Close()
+	conn, err := net.Dial("tcp", frontend.Listener.Addr().String())
+	suite.Require().NoError(err)
+	defer conn.Close()
+	request := httptest.NewRequest("POST", "/api/v1/namespaces/default/pods/web/exec?command=sh", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+
+	// test
+	suite.Require().NoError(request.Write(conn))
+	response, err := http.ReadResponse(bufio.NewReader(conn), request)
+
+	// verify
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusSwitchingProtocols, response.StatusCode)
+	suite.Require().Len(suite.targetServer.requests, 1)
+	suite.Empty(suite.targetServer.requests[0].Header("Sec-WebSocket-Extensions"), "api server must not compress recorded frames")
+}
+
+func (suite *ProxySuite) TestServeHTTPSessionRecordingError() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Sessions.EnableExec = true
+	recordingDir := filepath.Join(suite.T().TempDir(), "recordings")
+	recorder, err := recording.NewRecorder(&config.RecordingConfig{Dir: recordingDir})
+	suite.Require().NoError(err)
+	suite.NoError(os.Remove(recordingDir))
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recorder)
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "http://foo-bar:12345/api/v1/namespaces/default/pods/web/exec", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.assertStatus(response, 500, metav1.StatusReasonInternalError)
+	suite.Len(suite.targetServer.requests, 0)
+}
+
+// openSession sends a request to upgrade to protocol for subresource of a pod to server,
+// and returns the connection with the response headers read.
+func (suite *ProxySuite) openSession(server *httptest.Server, subresource, protocol string) (net.Conn, *http.Response) {
+	return suite.openSessionAs(server, testIAMIdentity, subresource, protocol)
+}
+
+// openSessionAs opens a session for the requester IAM identity iamIdentity.
+func (suite *ProxySuite) openSessionAs(server *httptest.Server, iamIdentity, subresource, protocol string) (net.Conn, *http.Response) {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	suite.Require().NoError(err)
+	request := httptest.NewRequest("POST", "/api/v1/namespaces/default/pods/web/"+subresource+"?command=sh", nil)
+	request.Header.Set(HeaderIamArn, iamIdentity)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", protocol)
+	request.Header.Set("X-Stream-Protocol-Version", "v4.channel.k8s.io")
+	suite.Require().NoError(request.Write(conn))
+	response, err := http.ReadResponse(bufio.NewReader(conn), request)
+	suite.Require().NoError(err)
+	return conn, response
+}
+
+// newEchoSessionHandler switches to the requested protocol and echoes the client.
+func newEchoSessionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, readWriter, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = fmt.Fprintf(readWriter, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n",
+			r.Header.Get("Upgrade"))
+		_ = readWriter.Flush()
+		_, _ = io.Copy(conn, readWriter)
+	})
+}
+
+func (suite *ProxySuite) TestServeHTTPWatchStreamed() {
+	// prepare
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	done := make(chan struct{})
+	defer close(done)
+	suite.targetServer.handler = newWatchHandler(done)
+	frontend := httptest.NewServer(suite.proxyHandler)
+	defer frontend.Close()
+	request, err := http.NewRequest("GET", frontend.URL+"/api/v1/namespaces/default/events?watch=true", nil)
+	suite.Require().NoError(err)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+	// test
+	response, err := frontend.Client().Do(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+	event, err := bufio.NewReader(response.Body).ReadString('\n')
+
+	// verify
+	suite.NoError(err, "the event is flushed while the watch is still open")
+	suite.Equal(`{"type":"ADDED"}`+"\n", event)
+}
+
+func (suite *ProxySuite) TestServeHTTPWatchIdleTimeout() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Timeouts.LongRunningIdleTimeout = 100 * time.Millisecond
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	done := make(chan struct{})
+	defer close(done)
+	suite.targetServer.handler = newWatchHandler(done)
+	frontend := httptest.NewServer(proxyHandler)
+	defer frontend.Close()
+	request, err := http.NewRequest("GET", frontend.URL+"/api/v1/namespaces/default/pods/web/log?follow=true", nil)
+	suite.Require().NoError(err)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+	// test
+	response, err := frontend.Client().Do(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+
+	// verify
+	suite.NoError(err, "the proxy ends idle streams cleanly")
+	suite.Equal(`{"type":"ADDED"}`+"\n", string(body))
+}
+
+func (suite *ProxySuite) TestServeHTTPWatchDrained() {
+	// prepare
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	done := make(chan struct{})
+	defer close(done)
+	suite.targetServer.handler = newWatchHandler(done)
+	frontend := httptest.NewServer(suite.proxyHandler)
+	defer frontend.Close()
+	request, err := http.NewRequest("GET", frontend.URL+"/api/v1/namespaces/default/events?watch=true", nil)
+	suite.Require().NoError(err)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+	response, err := frontend.Client().Do(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+	reader := bufio.NewReader(response.Body)
+	_, err = reader.ReadString('\n')
+	suite.Require().NoError(err)
+
+	// test
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		drained <- suite.proxyHandler.Drain(ctx)
+	}()
+	rest, err := io.ReadAll(reader)
+
+	// verify
+	suite.NoError(err, "draining ends open watches cleanly")
+	suite.Empty(rest)
+	suite.NoError(<-drained)
+}
+
+func (suite *ProxySuite) TestServeHTTPRequestTimeout() {
+	// prepare
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.Timeouts.Request = 100 * time.Millisecond
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	done := make(chan struct{})
+	defer close(done)
+	suite.targetServer.handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		select {
+		case <-done:
+		case <-req.Context().Done():
+		}
+	})
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.assertProxyError(response, 504, metav1.StatusReasonTimeout, UpstreamErrorTimeout)
+}
+
+// newWatchHandler returns a handler sending one watch event, and keeping the watch open until done.
+func newWatchHandler(done <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"type":"ADDED"}` + "\n"))
+		rw.(http.Flusher).Flush()
+		select {
+		case <-done:
+		case <-req.Context().Done():
+		}
+	})
+}
+
+func (suite *ProxySuite) TestServeHTTPReusesConnection() {
+	// prepare
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+
+	// test
+	for i := 0; i < 3; i++ {
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+		request.Header.Set(HeaderIamArn, testIAMIdentity)
+		suite.proxyHandler.ServeHTTP(response, request)
+		suite.Equal(200, response.Code)
+	}
+
+	// verify
+	suite.Len(suite.targetServer.requests, 3)
+	suite.Equal(1, suite.targetServer.Connections(), "keep-alive connection should be reused")
+}
+
+func (suite *ProxySuite) TestServeHTTPRebuildsTransportOnSecretChange() {
+	// prepare
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil).Once()
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testRotatedServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil).Once()
+	suite.targetServer.handler = newTextHandler(testHttpResponse)
+
+	// test
+	for i := 0; i < 2; i++ {
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+		request.Header.Set(HeaderIamArn, testIAMIdentity)
+		suite.proxyHandler.ServeHTTP(response, request)
+		suite.Equal(200, response.Code)
+	}
+
+	// verify
+	suite.Len(suite.targetServer.requests, 2)
+	suite.Equal(bearer(testServiceAccountToken), suite.targetServer.requests[0].Header(HeaderAuthorization))
+	suite.Equal(bearer(testRotatedServiceAccountToken), suite.targetServer.requests[1].Header(HeaderAuthorization))
+	suite.Equal(2, suite.targetServer.Connections(), "new transport should dial a new connection")
+	suite.secretProvider.AssertExpectations(suite.T())
+}
+
 func (suite *ProxySuite) TestServeHTTPBadCertificate() {
 	// prepare
 	response := httptest.NewRecorder()
@@ -96,7 +1065,48 @@ func (suite *ProxySuite) TestServeHTTPBadCertificate() {
 
 	// verify
 	suite.Len(suite.targetServer.requests, 0, "request should not hit targetServer due to certificate error")
-	suite.Equal(502, response.Code, "proxy error response should be returned")
+	suite.assertProxyError(response, 502, StatusReasonBadGateway, UpstreamErrorTLS)
+}
+
+func (suite *ProxySuite) TestServeHTTPServerName() {
+	// prepare
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		RootCAs:    suite.targetServer.RootCAPool(),
+		ServerName: "kubernetes.internal",
+	}, nil)
+
+	// test
+	suite.proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Len(suite.targetServer.requests, 0)
+	suite.assertProxyError(response, 502, StatusReasonBadGateway, UpstreamErrorTLS)
+}
+
+func (suite *ProxySuite) TestServeHTTPUnauthorizedReloadsServiceAccount() {
+	// prepare
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	cachedProvider := serviceaccount.NewCachedProvider(suite.secretProvider, suite.T().TempDir())
+	proxyHandler := NewProxyHandler(suite.targetServer.ProxyConfig(), cachedProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Equal(http.StatusUnauthorized, response.Code)
+	suite.secretProvider.AssertNumberOfCalls(suite.T(), "Get", 2)
 }
 
 func (suite *ProxySuite) TestServeHTTPSecretProviderError() {
@@ -111,7 +1121,248 @@ func (suite *ProxySuite) TestServeHTTPSecretProviderError() {
 
 	// verify
 	suite.Len(suite.targetServer.requests, 0)
-	suite.Equal(502, response.Code)
+	suite.assertProxyError(response, 503, metav1.StatusReasonServiceUnavailable, UpstreamErrorServiceAccount)
+}
+
+func (suite *ProxySuite) TestServeHTTPUpstreamUnavailable() {
+	// prepare
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.TargetHost = suite.closedAddress()
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.assertProxyError(response, 503, metav1.StatusReasonServiceUnavailable, UpstreamErrorConnection)
+}
+
+func (suite *ProxySuite) TestServeHTTPFailover() {
+	// prepare
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler("ok")
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.TargetHosts = []string{suite.closedAddress(), proxyConfig.TargetHost}
+	proxyConfig.Failover = config.FailoverConfig{
+		Policy:           FailoverPriority,
+		EjectionDuration: time.Minute,
+	}
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+
+	for i := 0; i < 2; i++ {
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil)
+		request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+		// test
+		proxyHandler.ServeHTTP(response, request)
+
+		// verify
+		suite.Equal(http.StatusOK, response.Code, "requests fail over to the healthy endpoint")
+		suite.Equal("ok", response.Body.String())
+	}
+	suite.Len(suite.targetServer.requests, 2)
+	suite.True(proxyHandler.(*proxy).targets.targets[1].available(time.Now()))
+	suite.False(proxyHandler.(*proxy).targets.targets[0].available(time.Now()), "the endpoint refusing connections is ejected")
+}
+
+func (suite *ProxySuite) TestDrainStopsHealthChecks() {
+	// prepare
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	var checks int32
+	suite.targetServer.handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&checks, 1)
+	})
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.TargetHosts = []string{proxyConfig.TargetHost, proxyConfig.TargetHost}
+	proxyConfig.Failover = config.FailoverConfig{
+		Policy:              FailoverPriority,
+		HealthCheckInterval: 10 * time.Millisecond,
+		HealthCheckTimeout:  time.Second,
+	}
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	suite.Eventually(func() bool {
+		return atomic.LoadInt32(&checks) >= 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// test
+	err := proxyHandler.Drain(context.Background())
+
+	// verify
+	suite.NoError(err)
+	// a check canceled by Drain may still reach the server.
+	time.Sleep(20 * time.Millisecond)
+	drained := atomic.LoadInt32(&checks)
+	time.Sleep(50 * time.Millisecond)
+	suite.Equal(drained, atomic.LoadInt32(&checks), "endpoints are not checked once the proxy drains")
+}
+
+func (suite *ProxySuite) TestServeHTTPFailoverWithBody() {
+	// prepare
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler("ok")
+	proxyConfig := suite.targetServer.ProxyConfig()
+	proxyConfig.TargetHosts = []string{suite.closedAddress(), proxyConfig.TargetHost}
+	proxyConfig.Failover.Policy = FailoverPriority
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "http://foo-bar:12345/api/v1/namespaces/default/configmaps", strings.NewReader("{}"))
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+
+	// test
+	proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Len(suite.targetServer.requests, 0, "a request whose body was consumed is not sent again")
+	suite.assertProxyError(response, 503, metav1.StatusReasonServiceUnavailable, UpstreamErrorConnection)
+}
+
+func (suite *ProxySuite) TestServeHTTPClientCanceled() {
+	// prepare
+	response := httptest.NewRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := httptest.NewRequest("GET", "http://foo-bar:12345/api/v1/pods", nil).WithContext(ctx)
+	request.Header.Set(HeaderIamArn, testIAMIdentity)
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+
+	// test
+	suite.proxyHandler.ServeHTTP(response, request)
+
+	// verify
+	suite.Len(suite.targetServer.requests, 0)
+	suite.assertProxyError(response, 499, StatusReasonClientClosedRequest, UpstreamErrorCanceled)
+}
+
+func (suite *ProxySuite) assertStatus(response *httptest.ResponseRecorder, code int, reason metav1.StatusReason) *metav1.Status {
+	suite.Equal(code, response.Code)
+	suite.Equal("application/json", response.Header().Get("Content-Type"))
+	status := &metav1.Status{}
+	suite.NoError(json.Unmarshal(response.Body.Bytes(), status))
+	suite.Equal("Status", status.Kind)
+	suite.Equal("v1", status.APIVersion)
+	suite.Equal(metav1.StatusFailure, status.Status)
+	suite.Equal(int32(code), status.Code)
+	suite.Equal(reason, status.Reason)
+	suite.NotEmpty(status.Message)
+	return status
+}
+
+// assertProxyError asserts the status of a request that could not be proxied to api server.
+func (suite *ProxySuite) assertProxyError(response *httptest.ResponseRecorder, code int, reason metav1.StatusReason,
+	errorType string) {
+	status := suite.assertStatus(response, code, reason)
+	requestID := response.Header().Get(HeaderAuditID)
+	suite.Contains(status.Message, requestID)
+	suite.NotNil(status.Details)
+	suite.Equal([]metav1.StatusCause{
+		{Type: CauseTypeUpstreamError, Message: errorType},
+		{Type: CauseTypeRequestID, Message: requestID},
+	}, status.Details.Causes)
+}
+
+func (suite *ProxySuite) TestCheckUpstream() {
+	// prepare
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler("ok")
+	proxyHandler := suite.proxyHandler.(Handler)
+
+	// test
+	err := proxyHandler.CheckUpstream(context.Background())
+
+	// verify
+	suite.NoError(err)
+	suite.Len(suite.targetServer.requests, 1)
+	checkRequest := suite.targetServer.requests[0]
+	suite.Equal("/readyz", checkRequest.rawRequest.URL.Path)
+	suite.Equal(bearer(testServiceAccountToken), checkRequest.Header(HeaderAuthorization))
+}
+
+func (suite *ProxySuite) TestCheckUpstreamNotReady() {
+	// prepare
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("[-]etcd failed: reason withheld"))
+	})
+	proxyHandler := suite.proxyHandler.(Handler)
+
+	// test
+	err := proxyHandler.CheckUpstream(context.Background())
+
+	// verify
+	suite.Error(err)
+	suite.Contains(err.Error(), "etcd failed")
+}
+
+func (suite *ProxySuite) TestCheckUpstreamFailover() {
+	// prepare
+	suite.secretProvider.On("Get").Return(&serviceaccount.Secret{
+		Token:   testServiceAccountToken,
+		RootCAs: suite.targetServer.RootCAPool(),
+	}, nil)
+	suite.targetServer.handler = newTextHandler("ok")
+	proxyConfig := suite.targetServer.ProxyConfig()
+	closedAddress := suite.closedAddress()
+
+	// test
+	proxyConfig.TargetHosts = []string{closedAddress, proxyConfig.TargetHost}
+	proxyHandler := NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	readyErr := proxyHandler.CheckUpstream(context.Background())
+	proxyConfig.TargetHosts = []string{closedAddress, closedAddress}
+	proxyHandler = NewProxyHandler(proxyConfig, suite.secretProvider, NewTrustedIdentityVerifier(), identity.NewPassthroughMapper(), NewAlwaysAllowAuthorizer(), audit.NewNopLogger(), recording.NewNopRecorder())
+	notReadyErr := proxyHandler.CheckUpstream(context.Background())
+
+	// verify
+	suite.NoError(readyErr, "the proxy is ready while one endpoint is ready")
+	suite.Error(notReadyErr)
+	suite.Contains(notReadyErr.Error(), closedAddress)
+}
+
+func (suite *ProxySuite) TestCheckUpstreamSecretProviderError() {
+	// prepare
+	suite.secretProvider.On("Get").Return(nil, errors.New("token not found"))
+	proxyHandler := suite.proxyHandler.(Handler)
+
+	// test
+	err := proxyHandler.CheckUpstream(context.Background())
+
+	// verify
+	suite.True(errors.Is(err, errServiceAccount))
+	suite.Len(suite.targetServer.requests, 0)
+}
+
+// closedAddress returns the address of a closed port, which refuses connections.
+func (suite *ProxySuite) closedAddress() string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	suite.Require().NoError(listener.Close())
+	return listener.Addr().String()
 }
 
 func newTextHandler(response string) http.Handler {
@@ -123,3 +1374,15 @@ func newTextHandler(response string) http.Handler {
 func bearer(token string) string {
 	return "Bearer " + token
 }
+
+func (suite *ProxySuite) hmacVerifier() IdentityVerifier {
+	keyFile := filepath.Join(suite.T().TempDir(), "key")
+	suite.Require().NoError(os.WriteFile(keyFile, []byte(testHMACKey), 0600))
+	verifier, err := NewSignatureVerifier(&config.SignatureConfig{
+		Algorithm:    SignatureHMACSHA256,
+		KeyFile:      keyFile,
+		MaxClockSkew: time.Minute,
+	})
+	suite.Require().NoError(err)
+	return verifier
+}
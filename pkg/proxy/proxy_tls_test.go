@@ -14,6 +14,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/amazon-eks-connector/pkg/config"
@@ -29,6 +30,8 @@ type mockServer struct {
 	leafCert   *tlsCert
 
 	requests []*mockServerRequest
+	// connections is the number of connections accepted by httpServer.
+	connections int32
 }
 
 func (server *mockServer) Start() {
@@ -36,12 +39,14 @@ func (server *mockServer) Start() {
 		panic("httpServer is already started")
 	}
 	mux := http.NewServeMux()
-	mux.HandleFunc("/api/v1/pods", func(rw http.ResponseWriter, req *http.Request) {
+	record := func(rw http.ResponseWriter, req *http.Request) {
 		server.requests = append(server.requests, &mockServerRequest{
 			rawRequest: req.Clone(context.TODO()),
 		})
 		server.handler.ServeHTTP(rw, req)
-	})
+	}
+	mux.HandleFunc("/api/", record)
+	mux.HandleFunc("/readyz", record)
 	server.rootCACert = must(generateCert("Amazon Web Services Root CA", nil, nil))
 	server.leafCert = must(generateCert("Kubernetes API Server leaf cert", []string{"127.0.0.1"}, server.rootCACert))
 
@@ -53,6 +58,11 @@ func (server *mockServer) Start() {
 	}
 
 	server.httpServer = httptest.NewUnstartedServer(mux)
+	server.httpServer.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&server.connections, 1)
+		}
+	}
 	server.httpServer.TLS = new(tls.Config)
 	server.httpServer.TLS.Certificates = []tls.Certificate{certChain}
 	server.httpServer.StartTLS()
@@ -74,6 +84,10 @@ func (server *mockServer) ProxyConfig() *config.ProxyConfig {
 	}
 }
 
+func (server *mockServer) Connections() int {
+	return int(atomic.LoadInt32(&server.connections))
+}
+
 func (server *mockServer) RootCAPool() *x509.CertPool {
 	pool := x509.NewCertPool()
 	cert, err := x509.ParseCertificate(server.rootCACert.raw)
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Kubernetes verbs of resource requests.
+const (
+	VerbGet              = "get"
+	VerbList             = "list"
+	VerbWatch            = "watch"
+	VerbCreate           = "create"
+	VerbUpdate           = "update"
+	VerbPatch            = "patch"
+	VerbDelete           = "delete"
+	VerbDeleteCollection = "deletecollection"
+)
+
+// RequestInfo holds the kubernetes attributes of a request parsed from its URL,
+// following the rules of api server's RequestInfoFactory.
+type RequestInfo struct {
+	// IsResourceRequest is false for non-resource paths like /version or /healthz.
+	IsResourceRequest bool
+	Path              string
+	// Verb is the kubernetes verb for resource requests, and the lower-cased http method otherwise.
+	Verb string
+
+	APIPrefix   string
+	APIGroup    string
+	APIVersion  string
+	Namespace   string
+	Resource    string
+	Subresource string
+	Name        string
+}
+
+// namespaceSubresources are subresources of namespaces that would otherwise be parsed as resources.
+var namespaceSubresources = map[string]bool{
+	"status":   true,
+	"finalize": true,
+}
+
+// newRequestInfo parses the kubernetes attributes of req.
+func newRequestInfo(req *http.Request) *RequestInfo {
+	info := &RequestInfo{
+		Path: req.URL.Path,
+		Verb: strings.ToLower(req.Method),
+	}
+
+	parts := splitPath(req.URL.Path)
+	if len(parts) < 3 {
+		// /api, /apis or /apis/<group> are discovery requests.
+		return info
+	}
+	info.APIPrefix = parts[0]
+	parts = parts[1:]
+	switch info.APIPrefix {
+	case "api":
+		info.APIVersion = parts[0]
+		parts = parts[1:]
+	case "apis":
+		if len(parts) < 3 {
+			// /apis/<group>/<version> is a discovery request.
+			return info
+		}
+		info.APIGroup = parts[0]
+		info.APIVersion = parts[1]
+		parts = parts[2:]
+	default:
+		return info
+	}
+	info.IsResourceRequest = true
+
+	switch req.Method {
+	case http.MethodPost:
+		info.Verb = VerbCreate
+	case http.MethodGet, http.MethodHead:
+		info.Verb = VerbGet
+	case http.MethodPut:
+		info.Verb = VerbUpdate
+	case http.MethodPatch:
+		info.Verb = VerbPatch
+	case http.MethodDelete:
+		info.Verb = VerbDelete
+	}
+
+	// deprecated /watch/ prefix.
+	if parts[0] == "watch" {
+		if info.Verb == VerbGet {
+			info.Verb = VerbWatch
+		}
+		parts = parts[1:]
+	}
+
+	if len(parts) > 0 && parts[0] == "namespaces" {
+		if len(parts) > 1 {
+			info.Namespace = parts[1]
+			// a namespaced resource, unless it is a subresource of the namespace itself.
+			if len(parts) > 2 && !namespaceSubresources[parts[2]] {
+				parts = parts[2:]
+			}
+		}
+	}
+
+	if len(parts) > 0 {
+		info.Resource = parts[0]
+	}
+	if len(parts) > 1 {
+		info.Name = parts[1]
+	}
+	if len(parts) > 2 {
+		info.Subresource = parts[2]
+	}
+
+	if info.Name == "" {
+		switch info.Verb {
+		case VerbGet:
+			info.Verb = VerbList
+		case VerbDelete:
+			info.Verb = VerbDeleteCollection
+		}
+	}
+	if info.Verb == VerbList && isWatch(req) {
+		info.Verb = VerbWatch
+	}
+	return info
+}
+
+// ResourceName returns the resource with its subresource, e.g. pods/exec.
+func (info *RequestInfo) ResourceName() string {
+	if info.Subresource == "" {
+		return info.Resource
+	}
+	return info.Resource + "/" + info.Subresource
+}
+
+func isWatch(req *http.Request) bool {
+	watch := strings.ToLower(req.URL.Query().Get("watch"))
+	return watch == "true" || watch == "1"
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestRequestInfoSuite(t *testing.T) {
+	suite.Run(t, new(RequestInfoSuite))
+}
+
+type RequestInfoSuite struct {
+	suite.Suite
+}
+
+func (suite *RequestInfoSuite) TestResourceRequests() {
+	testCases := []struct {
+		method   string
+		url      string
+		expected RequestInfo
+	}{
+		{"GET", "/api/v1/pods", RequestInfo{
+			Verb: VerbList, APIPrefix: "api", APIVersion: "v1", Resource: "pods"}},
+		{"GET", "/api/v1/namespaces/default/pods?watch=true", RequestInfo{
+			Verb: VerbWatch, APIPrefix: "api", APIVersion: "v1", Namespace: "default", Resource: "pods"}},
+		{"GET", "/api/v1/watch/namespaces/default/pods/nginx", RequestInfo{
+			Verb: VerbWatch, APIPrefix: "api", APIVersion: "v1", Namespace: "default", Resource: "pods", Name: "nginx"}},
+		{"POST", "/api/v1/namespaces/default/pods/nginx/exec?command=sh", RequestInfo{
+			Verb: VerbCreate, APIPrefix: "api", APIVersion: "v1", Namespace: "default", Resource: "pods", Name: "nginx", Subresource: "exec"}},
+		{"GET", "/api/v1/namespaces/default/pods/nginx/log?follow=true", RequestInfo{
+			Verb: VerbGet, APIPrefix: "api", APIVersion: "v1", Namespace: "default", Resource: "pods", Name: "nginx", Subresource: "log"}},
+		{"PATCH", "/apis/apps/v1/namespaces/kube-system/deployments/coredns/scale", RequestInfo{
+			Verb: VerbPatch, APIPrefix: "apis", APIGroup: "apps", APIVersion: "v1", Namespace: "kube-system", Resource: "deployments", Name: "coredns", Subresource: "scale"}},
+		{"DELETE", "/apis/batch/v1/namespaces/default/jobs", RequestInfo{
+			Verb: VerbDeleteCollection, APIPrefix: "apis", APIGroup: "batch", APIVersion: "v1", Namespace: "default", Resource: "jobs"}},
+		{"PUT", "/api/v1/namespaces/default/finalize", RequestInfo{
+			Verb: VerbUpdate, APIPrefix: "api", APIVersion: "v1", Namespace: "default", Resource: "namespaces", Name: "default", Subresource: "finalize"}},
+		{"GET", "/api/v1/namespaces/default", RequestInfo{
+			Verb: VerbGet, APIPrefix: "api", APIVersion: "v1", Namespace: "default", Resource: "namespaces", Name: "default"}},
+		{"HEAD", "/apis/rbac.authorization.k8s.io/v1/clusterroles/admin", RequestInfo{
+			Verb: VerbGet, APIPrefix: "apis", APIGroup: "rbac.authorization.k8s.io", APIVersion: "v1", Resource: "clusterroles", Name: "admin"}},
+	}
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(testCase.method, "http://foo-bar"+testCase.url, nil)
+		expected := testCase.expected
+		expected.IsResourceRequest = true
+		expected.Path = request.URL.Path
+
+		info := newRequestInfo(request)
+
+		suite.Equal(&expected, info, testCase.url)
+	}
+}
+
+func (suite *RequestInfoSuite) TestNonResourceRequests() {
+	for _, url := range []string{"/", "/version", "/healthz", "/api", "/api/v1", "/apis", "/apis/apps", "/apis/apps/v1", "/openapi/v2"} {
+		request := httptest.NewRequest("GET", "http://foo-bar"+url, nil)
+
+		info := newRequestInfo(request)
+
+		suite.False(info.IsResourceRequest, url)
+		suite.Equal("get", info.Verb, url)
+		suite.Equal(request.URL.Path, info.Path, url)
+	}
+}
+
+func (suite *RequestInfoSuite) TestResourceName() {
+	suite.Equal("pods", (&RequestInfo{Resource: "pods"}).ResourceName())
+	suite.Equal("pods/exec", (&RequestInfo{Resource: "pods", Subresource: "exec"}).ResourceName())
+}
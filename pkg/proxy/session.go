@@ -0,0 +1,314 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+	"github.com/aws/amazon-eks-connector/pkg/recording"
+)
+
+// Types of interactive sessions, named after their pod subresource.
+const (
+	SessionExec        = "exec"
+	SessionAttach      = "attach"
+	SessionPortForward = "portforward"
+
+	// LimitReasonSessions is the reason of requests rejected by the per identity session cap.
+	LimitReasonSessions = "sessions"
+	// sessionRetryAfter is the Retry-After advertised when an identity is at its session cap.
+	sessionRetryAfter = 10 * time.Second
+
+	// webSocketBearerProtocolPrefix is a WebSocket subprotocol api server accepts as a bearer token.
+	webSocketBearerProtocolPrefix = "base64url.bearer.authorization.k8s.io."
+)
+
+// recordedSessions are the types of sessions whose terminal is recorded.
+var recordedSessions = map[string]bool{
+	SessionExec:   true,
+	SessionAttach: true,
+}
+
+// upgradeHeaders are forwarded to api server for session requests only,
+// as they are needed to negotiate SPDY and WebSocket streams.
+var upgradeHeaders = []string{
+	"Connection",
+	"Upgrade",
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Protocol",
+	"Sec-Websocket-Extensions",
+	"X-Stream-Protocol-Version",
+}
+
+// sessionType returns the type of interactive session requested by info, or an empty string.
+func sessionType(info *RequestInfo) string {
+	if !info.IsResourceRequest || info.APIGroup != "" || info.Resource != "pods" {
+		return ""
+	}
+	switch info.Subresource {
+	case SessionExec, SessionAttach, SessionPortForward:
+		return info.Subresource
+	default:
+		return ""
+	}
+}
+
+// sessionEnabled returns whether sessions of sessionType are allowed by sessionConfig.
+func sessionEnabled(sessionConfig *config.SessionConfig, sessionType string) bool {
+	switch sessionType {
+	case SessionExec:
+		return sessionConfig.EnableExec
+	case SessionAttach:
+		return sessionConfig.EnableAttach
+	case SessionPortForward:
+		return sessionConfig.EnablePortForward
+	default:
+		return false
+	}
+}
+
+// sessionLimiter caps the concurrent sessions of each IAM identity.
+type sessionLimiter struct {
+	maxPerIdentity int
+
+	lock     sync.Mutex
+	sessions map[string]int
+}
+
+func newSessionLimiter(sessionConfig *config.SessionConfig) *sessionLimiter {
+	return &sessionLimiter{
+		maxPerIdentity: sessionConfig.MaxPerIdentity,
+		sessions:       map[string]int{},
+	}
+}
+
+// acquire takes a session slot of identity. The returned release func must be called once the session is over.
+func (l *sessionLimiter) acquire(identity string) (func(), *limitError) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.maxPerIdentity > 0 && l.sessions[identity] >= l.maxPerIdentity {
+		return nil, &limitError{
+			reason:     LimitReasonSessions,
+			retryAfter: sessionRetryAfter,
+		}
+	}
+	l.sessions[identity]++
+	return func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		l.sessions[identity]--
+		if l.sessions[identity] <= 0 {
+			delete(l.sessions, identity)
+		}
+	}, nil
+}
+
+// forwardUpgradeHeaders copies the headers negotiating the session stream from the client request,
+// except WebSocket subprotocols carrying a bearer token, which would override the impersonated identity.
+// WebSocket extensions are not negotiated for recorded sessions, as the recording only decodes uncompressed frames.
+func forwardUpgradeHeaders(from, to http.Header, recorded bool) {
+	for _, name := range upgradeHeaders {
+		to.Del(name)
+		for _, value := range from.Values(name) {
+			to.Add(name, value)
+		}
+	}
+	if recorded {
+		to.Del("Sec-Websocket-Extensions")
+	}
+	protocols := to.Values("Sec-Websocket-Protocol")
+	if len(protocols) == 0 {
+		return
+	}
+	to.Del("Sec-Websocket-Protocol")
+	for _, value := range protocols {
+		for _, protocol := range strings.Split(value, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if protocol != "" && !strings.HasPrefix(protocol, webSocketBearerProtocolPrefix) {
+				to.Add("Sec-Websocket-Protocol", protocol)
+			}
+		}
+	}
+}
+
+// startSession checks that session is enabled and within the limits of the requester,
+// and prepares the recording and timeouts of its upgraded connection.
+// If it returns true, the returned func must be called once the session is over.
+func (p *proxy) startSession(res *responseRecorder, req *http.Request, attributes *Attributes, session string) (func(), bool) {
+	if !sessionEnabled(&p.ProxyConfig.Sessions, session) {
+		p.sessionDisabledError(res, attributes, session)
+		return nil, false
+	}
+	release, limitErr := p.sessions.acquire(attributes.Principal.CanonicalARN)
+	if limitErr != nil {
+		p.limitError(res, attributes, limitErr)
+		return nil, false
+	}
+
+	var sessionRecording recording.Recording
+	if p.isRecorded(session) && isUpgradeRequest(req) {
+		var err error
+		sessionRecording, err = p.Recorder.Record(newRecordedSession(req, attributes, session))
+		if err != nil {
+			release()
+			p.recordingError(res, attributes, session, err)
+			return nil, false
+		}
+	}
+	res.onHijack = func(conn net.Conn) net.Conn {
+		if sessionRecording != nil {
+			conn = &recordedConn{Conn: conn, recording: sessionRecording}
+		}
+		return newSessionConn(conn, session, &p.ProxyConfig.Sessions, p.watchdogs)
+	}
+	return func() {
+		if sessionRecording != nil {
+			if err := sessionRecording.Close(); err != nil {
+				sessionRecordingErrorsTotal.Inc()
+				klog.Errorf("failed to close %s session recording: %v", session, err)
+			}
+		}
+		release()
+	}, true
+}
+
+// isRecorded returns whether sessions of sessionType are recorded.
+func (p *proxy) isRecorded(sessionType string) bool {
+	return p.Recorder.Enabled() && recordedSessions[sessionType]
+}
+
+func newRecordedSession(req *http.Request, attributes *Attributes, session string) *recording.Session {
+	query := req.URL.Query()
+	requestID, _ := requestIDFrom(req.Context())
+	tty := query.Get("tty")
+	return &recording.Session{
+		RequestID:   requestID,
+		IAMIdentity: attributes.Principal.ARN,
+		User:        attributes.User.Username,
+		Type:        session,
+		Namespace:   attributes.Request.Namespace,
+		Pod:         attributes.Request.Name,
+		Container:   query.Get("container"),
+		Command:     query["command"],
+		TTY:         tty == "true" || tty == "1",
+		Protocol:    req.Header.Get("Upgrade"),
+		Start:       time.Now(),
+	}
+}
+
+func (p *proxy) sessionDisabledError(res http.ResponseWriter, attributes *Attributes, sessionType string) {
+	klog.Infof("eks connector rejected %s session to %s for %s: %s sessions are disabled",
+		sessionType, attributes.Request.Path, attributes.Principal.ARN, sessionType)
+	writeStatus(res, http.StatusForbidden, metav1.StatusReasonForbidden,
+		fmt.Sprintf("%s is disabled in eks connector", sessionType))
+}
+
+func (p *proxy) recordingError(res http.ResponseWriter, attributes *Attributes, sessionType string, err error) {
+	sessionRecordingErrorsTotal.Inc()
+	klog.Errorf("eks connector rejected %s session to %s for %s: failed to start recording: %v",
+		sessionType, attributes.Request.Path, attributes.Principal.ARN, err)
+	writeStatus(res, http.StatusInternalServerError, metav1.StatusReasonInternalError,
+		"eks connector failed to start the session recording. check eks connector logs for details.")
+}
+
+// recordedConn passes the traffic of a session connection to its recording before forwarding it,
+// and closes the connection if the traffic cannot be recorded.
+type recordedConn struct {
+	net.Conn
+	recording recording.Recording
+	closed    int32
+}
+
+func (c *recordedConn) Read(data []byte) (int, error) {
+	n, err := c.Conn.Read(data)
+	if n > 0 {
+		if recordErr := c.recording.ClientData(data[:n]); recordErr != nil {
+			c.recordingFailed(recordErr)
+			return 0, recordErr
+		}
+	}
+	return n, err
+}
+
+func (c *recordedConn) Write(data []byte) (int, error) {
+	if err := c.recording.ServerData(data); err != nil {
+		c.recordingFailed(err)
+		return 0, err
+	}
+	return c.Conn.Write(data)
+}
+
+func (c *recordedConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return c.Conn.Close()
+}
+
+func (c *recordedConn) recordingFailed(err error) {
+	// the recording is closed with the session, so late traffic of a closed connection cannot be recorded.
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return
+	}
+	sessionRecordingErrorsTotal.Inc()
+	klog.Errorf("closing session that cannot be recorded: %v", err)
+	_ = c.Close()
+}
+
+// sessionConn is the upgraded client connection of a session.
+// It closes itself once it is idle or open for too long, which ends both directions of the session
+// as the reverse proxy then closes the api server connection.
+type sessionConn struct {
+	net.Conn
+	sessionType string
+	watchdog    *watchdog
+	closeOnce   sync.Once
+}
+
+// newSessionConn returns conn watched for the timeouts of sessionConfig, and closed when watchdogs drain.
+func newSessionConn(conn net.Conn, sessionType string, sessionConfig *config.SessionConfig, watchdogs *watchdogSet) net.Conn {
+	session := &sessionConn{
+		Conn:        conn,
+		sessionType: sessionType,
+	}
+	sessionsActive.Inc(sessionType)
+	session.watchdog = newWatchdog(sessionConfig.IdleTimeout, sessionConfig.MaxDuration, func(timeout string) {
+		klog.Infof("closing %s session on %s timeout", sessionType, timeout)
+		sessionTimeoutsTotal.Inc(sessionType, timeout)
+		_ = session.Close()
+	})
+	watchdogs.run(session.watchdog)
+	return session
+}
+
+func (c *sessionConn) Read(data []byte) (int, error) {
+	n, err := c.Conn.Read(data)
+	if n > 0 {
+		c.watchdog.touch()
+	}
+	return n, err
+}
+
+func (c *sessionConn) Write(data []byte) (int, error) {
+	n, err := c.Conn.Write(data)
+	if n > 0 {
+		c.watchdog.touch()
+	}
+	return n, err
+}
+
+func (c *sessionConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		sessionsActive.Dec(c.sessionType)
+		c.watchdog.stop()
+	})
+	return err
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+func TestSessionSuite(t *testing.T) {
+	suite.Run(t, new(SessionSuite))
+}
+
+type SessionSuite struct {
+	suite.Suite
+}
+
+func (suite *SessionSuite) TestSessionType() {
+	testCases := []struct {
+		info     *RequestInfo
+		expected string
+	}{
+		{&RequestInfo{IsResourceRequest: true, Resource: "pods", Subresource: "exec"}, SessionExec},
+		{&RequestInfo{IsResourceRequest: true, Resource: "pods", Subresource: "attach"}, SessionAttach},
+		{&RequestInfo{IsResourceRequest: true, Resource: "pods", Subresource: "portforward"}, SessionPortForward},
+		{&RequestInfo{IsResourceRequest: true, Resource: "pods", Subresource: "log"}, ""},
+		{&RequestInfo{IsResourceRequest: true, Resource: "pods"}, ""},
+		{&RequestInfo{IsResourceRequest: true, APIGroup: "example.com", Resource: "pods", Subresource: "exec"}, ""},
+		{&RequestInfo{Path: "/api/v1/namespaces/default/pods/web/exec"}, ""},
+	}
+	for _, testCase := range testCases {
+		suite.Equal(testCase.expected, sessionType(testCase.info), "%+v", testCase.info)
+	}
+}
+
+func (suite *SessionSuite) TestAcquirePerIdentity() {
+	// prepare
+	limiter := newSessionLimiter(&config.SessionConfig{
+		MaxPerIdentity: 1,
+	})
+
+	// test
+	release, err1 := limiter.acquire(testIAMIdentity)
+	_, err2 := limiter.acquire(testIAMIdentity)
+	_, otherErr := limiter.acquire(testAssumedRoleIdentity)
+	release()
+	_, err3 := limiter.acquire(testIAMIdentity)
+
+	// verify
+	suite.Nil(err1)
+	suite.NotNil(err2)
+	suite.Equal(LimitReasonSessions, err2.reason)
+	suite.Nil(otherErr, "other identities have their own sessions")
+	suite.Nil(err3, "released sessions are available again")
+}
+
+func (suite *SessionSuite) TestAcquireUnlimited() {
+	// prepare
+	limiter := newSessionLimiter(&config.SessionConfig{})
+
+	for i := 0; i < 100; i++ {
+		// test
+		_, err := limiter.acquire(testIAMIdentity)
+
+		// verify
+		suite.Nil(err)
+	}
+}
+
+func (suite *SessionSuite) TestForwardUpgradeHeaders() {
+	// prepare
+	from := http.Header{}
+	from.Set("Connection", "Upgrade")
+	from.Set("Upgrade", "websocket")
+	from.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	from.Set("Sec-WebSocket-Version", "13")
+	from.Set("Sec-WebSocket-Protocol", "base64url.bearer.authorization.k8s.io.dG9rZW4, v4.channel.k8s.io")
+	from.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+	from.Set(testCustomRequestHeader, "value")
+	to := http.Header{}
+	to.Set(HeaderAuthorization, bearer(testServiceAccountToken))
+
+	// test
+	forwardUpgradeHeaders(from, to, false)
+
+	// verify
+	suite.Equal("Upgrade", to.Get("Connection"))
+	suite.Equal("websocket", to.Get("Upgrade"))
+	suite.Equal("dGhlIHNhbXBsZSBub25jZQ==", to.Get("Sec-WebSocket-Key"))
+	suite.Equal("13", to.Get("Sec-WebSocket-Version"))
+	suite.Equal([]string{"v4.channel.k8s.io"}, to.Values("Sec-WebSocket-Protocol"), "bearer token subprotocol is not forwarded")
+	suite.Equal("permessage-deflate", to.Get("Sec-WebSocket-Extensions"))
+	suite.Empty(to.Get(testCustomRequestHeader))
+	suite.Equal(bearer(testServiceAccountToken), to.Get(HeaderAuthorization))
+}
+
+func (suite *SessionSuite) TestForwardUpgradeHeadersRecorded() {
+	// prepare
+	from := http.Header{}
+	from.Set("Connection", "Upgrade")
+	from.Set("Upgrade", "websocket")
+	from.Set("Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits")
+	to := http.Header{}
+
+	// test
+	forwardUpgradeHeaders(from, to, true)
+
+	// verify
+	suite.Equal("websocket", to.Get("Upgrade"))
+	suite.Empty(to.Values("Sec-WebSocket-Extensions"), "compressed frames could not be recorded")
+}
+
+func (suite *SessionSuite) TestSessionConnIdleTimeout() {
+	// prepare
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := newSessionConn(server, SessionExec, &config.SessionConfig{
+		IdleTimeout: 50 * time.Millisecond,
+	}, newWatchdogSet())
+
+	// test
+	_, err := conn.Read(make([]byte, 1))
+
+	// verify
+	suite.Error(err, "idle connection is closed")
+	_, err = client.Write([]byte("a"))
+	suite.Error(err)
+}
+
+func (suite *SessionSuite) TestSessionConnActivity() {
+	// prepare
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := newSessionConn(server, SessionAttach, &config.SessionConfig{
+		IdleTimeout: 100 * time.Millisecond,
+	}, newWatchdogSet())
+	defer conn.Close()
+	go func() {
+		for i := 0; i < 5; i++ {
+			time.Sleep(40 * time.Millisecond)
+			_, _ = client.Write([]byte("a"))
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		// test
+		_, err := conn.Read(make([]byte, 1))
+
+		// verify
+		suite.NoError(err, "traffic keeps the connection open past the idle timeout")
+	}
+}
+
+func (suite *SessionSuite) TestSessionConnDrained() {
+	// prepare
+	client, server := net.Pipe()
+	defer client.Close()
+	watchdogs := newWatchdogSet()
+	conn := newSessionConn(server, SessionExec, &config.SessionConfig{}, watchdogs)
+
+	// test
+	watchdogs.drain()
+
+	// verify
+	_, err := conn.Read(make([]byte, 1))
+	suite.Error(err, "draining closes open sessions")
+	lateClient, lateServer := net.Pipe()
+	defer lateClient.Close()
+	_, err = newSessionConn(lateServer, SessionExec, &config.SessionConfig{}, watchdogs).Read(make([]byte, 1))
+	suite.Error(err, "sessions opened while draining are closed at once")
+	suite.Empty(watchdogs.watchdogs)
+}
+
+func (suite *SessionSuite) TestSessionConnMaxDuration() {
+	// prepare
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := newSessionConn(server, SessionPortForward, &config.SessionConfig{
+		IdleTimeout: time.Hour,
+		MaxDuration: 50 * time.Millisecond,
+	}, newWatchdogSet())
+	go func() {
+		for {
+			if _, err := client.Write([]byte("a")); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	// test
+	start := time.Now()
+	var err error
+	for err == nil {
+		_, err = conn.Read(make([]byte, 1))
+	}
+
+	// verify
+	suite.Less(time.Since(start), time.Second, "active connection is closed after the max duration")
+}
@@ -0,0 +1,316 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+// Algorithms of the signature of the requester IAM identity.
+const (
+	SignatureHMACSHA256  = "hmac-sha256"
+	SignatureECDSASHA256 = "ecdsa-sha256"
+	SignatureEd25519     = "ed25519"
+)
+
+// Headers of a signed requester IAM identity, next to HeaderIamArn.
+const (
+	// HeaderIdentityTimestamp is the RFC 3339 time at which the identity was signed.
+	HeaderIdentityTimestamp = "x-aws-eks-identity-timestamp"
+	// HeaderIdentityNonce is a unique value of each signed request, which cannot be replayed.
+	HeaderIdentityNonce = "x-aws-eks-identity-nonce"
+	// HeaderIdentityContentHash is the hex-encoded SHA-256 of the request body, the hash of no body if not set.
+	HeaderIdentityContentHash = "x-aws-eks-identity-content-sha256"
+	// HeaderIdentitySignature is the base64-encoded signature of the string to sign.
+	HeaderIdentitySignature = "x-aws-eks-identity-signature"
+
+	signatureVersion = "EKS-CONNECTOR-IDENTITY-SHA256"
+	// minHMACKeySize is the size of the SHA-256 output, as recommended by RFC 2104.
+	minHMACKeySize = sha256.Size
+)
+
+// Reasons of signature verification failures.
+const (
+	signatureFailureMalformed   = "malformed"
+	signatureFailureClockSkew   = "clock_skew"
+	signatureFailureMismatch    = "mismatch"
+	signatureFailureReplay      = "replay"
+	signatureFailureContentHash = "content_hash"
+)
+
+var (
+	// errSignature is returned when the requester IAM identity is not signed as configured.
+	errSignature = errors.New("invalid IAM identity signature")
+	// errContentHash is returned while reading a request body that does not match its signed content hash.
+	errContentHash = errors.New("request body does not match its signed content hash")
+
+	emptyContentHash = hex.EncodeToString(sha256.New().Sum(nil))
+)
+
+// IdentityVerifier verifies that the requester IAM identity was asserted by a trusted signer,
+// before it is validated and impersonated.
+type IdentityVerifier interface {
+	// Verify returns an error if the identity of req cannot be trusted.
+	// It may wrap req.Body to verify the body while it is proxied.
+	Verify(req *http.Request, arn string) error
+}
+
+// NewTrustedIdentityVerifier returns an IdentityVerifier trusting the identity header as is,
+// i.e. anything that can connect to the proxy socket can impersonate any IAM identity.
+func NewTrustedIdentityVerifier() IdentityVerifier {
+	return &trustedIdentityVerifier{}
+}
+
+type trustedIdentityVerifier struct {
+}
+
+func (v *trustedIdentityVerifier) Verify(req *http.Request, arn string) error {
+	return nil
+}
+
+// NewSignatureVerifier returns an IdentityVerifier requiring the identity to be signed with the key of signatureConfig.
+// It returns a trusted verifier if no algorithm is configured.
+//
+// The signature covers the string to sign
+//
+//	EKS-CONNECTOR-IDENTITY-SHA256
+//	<x-aws-eks-identity-arn>
+//	<x-aws-eks-identity-timestamp>
+//	<x-aws-eks-identity-nonce>
+//	<hex SHA-256 of the canonical request>
+//
+// where the canonical request is
+//
+//	<method>
+//	<escaped path>
+//	<raw query>
+//	<x-aws-eks-identity-content-sha256>
+//
+// A timestamp further than MaxClockSkew from the proxy clock, and a nonce seen within that window, are rejected.
+func NewSignatureVerifier(signatureConfig *config.SignatureConfig) (IdentityVerifier, error) {
+	if signatureConfig.Algorithm == "" {
+		return NewTrustedIdentityVerifier(), nil
+	}
+	if signatureConfig.KeyFile == "" {
+		return nil, fmt.Errorf("a key file is required to verify %s signatures", signatureConfig.Algorithm)
+	}
+	if signatureConfig.MaxClockSkew <= 0 {
+		return nil, fmt.Errorf("invalid maximum clock skew %s, expected a positive duration", signatureConfig.MaxClockSkew)
+	}
+	key, err := os.ReadFile(signatureConfig.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	verify, err := newSignatureFunc(signatureConfig.Algorithm, key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", signatureConfig.KeyFile, err)
+	}
+	return &signatureVerifier{
+		verify:       verify,
+		maxClockSkew: signatureConfig.MaxClockSkew,
+		nonces:       newNonceCache(signatureConfig.MaxClockSkew),
+	}, nil
+}
+
+// newSignatureFunc returns a function verifying signatures of algorithm with key,
+// a shared secret for hmac-sha256 and a PEM public key otherwise.
+func newSignatureFunc(algorithm string, key []byte) (func(message, signature []byte) bool, error) {
+	if algorithm == SignatureHMACSHA256 {
+		// tolerate the trailing newline of keys written with echo.
+		key = bytes.TrimSpace(key)
+		if len(key) < minHMACKeySize {
+			return nil, fmt.Errorf("%s keys must have at least %d bytes", algorithm, minHMACKeySize)
+		}
+		return func(message, signature []byte) bool {
+			mac := hmac.New(sha256.New, key)
+			mac.Write(message)
+			return hmac.Equal(mac.Sum(nil), signature)
+		}, nil
+	}
+
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("no PEM public key")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch algorithm {
+	case SignatureECDSASHA256:
+		ecdsaKey, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an ECDSA public key, got %T", algorithm, publicKey)
+		}
+		return func(message, signature []byte) bool {
+			digest := sha256.Sum256(message)
+			return ecdsa.VerifyASN1(ecdsaKey, digest[:], signature)
+		}, nil
+	case SignatureEd25519:
+		ed25519Key, ok := publicKey.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an Ed25519 public key, got %T", algorithm, publicKey)
+		}
+		return func(message, signature []byte) bool {
+			return ed25519.Verify(ed25519Key, message, signature)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown signature algorithm %q, expected %s, %s or %s",
+			algorithm, SignatureHMACSHA256, SignatureECDSASHA256, SignatureEd25519)
+	}
+}
+
+type signatureVerifier struct {
+	verify       func(message, signature []byte) bool
+	maxClockSkew time.Duration
+	nonces       *nonceCache
+}
+
+func (v *signatureVerifier) Verify(req *http.Request, arn string) error {
+	signature, err := base64.StdEncoding.DecodeString(req.Header.Get(HeaderIdentitySignature))
+	if err != nil || len(signature) == 0 {
+		return signatureError(signatureFailureMalformed, "missing or malformed %s header", HeaderIdentitySignature)
+	}
+	timestamp, err := time.Parse(time.RFC3339, req.Header.Get(HeaderIdentityTimestamp))
+	if err != nil {
+		return signatureError(signatureFailureMalformed, "missing or malformed %s header", HeaderIdentityTimestamp)
+	}
+	nonce := req.Header.Get(HeaderIdentityNonce)
+	if nonce == "" {
+		return signatureError(signatureFailureMalformed, "missing %s header", HeaderIdentityNonce)
+	}
+	contentHash := strings.ToLower(req.Header.Get(HeaderIdentityContentHash))
+	if contentHash == "" {
+		contentHash = emptyContentHash
+	}
+	if decoded, err := hex.DecodeString(contentHash); err != nil || len(decoded) != sha256.Size {
+		return signatureError(signatureFailureMalformed, "malformed %s header", HeaderIdentityContentHash)
+	}
+
+	now := time.Now()
+	if skew := now.Sub(timestamp); skew > v.maxClockSkew || skew < -v.maxClockSkew {
+		return signatureError(signatureFailureClockSkew, "timestamp %s is more than %s away from %s",
+			timestamp.Format(time.RFC3339), v.maxClockSkew, now.Format(time.RFC3339))
+	}
+	if !v.verify([]byte(stringToSign(req, arn, contentHash)), signature) {
+		return signatureError(signatureFailureMismatch, "signature does not match the request")
+	}
+	// only record nonces of valid signatures, so that unsigned requests cannot fill the cache.
+	if !v.nonces.add(nonce, timestamp.Add(v.maxClockSkew), now) {
+		return signatureError(signatureFailureReplay, "nonce %s was already used", nonce)
+	}
+
+	if req.ContentLength == 0 || req.Body == nil || req.Body == http.NoBody {
+		if contentHash != emptyContentHash {
+			return signatureError(signatureFailureContentHash, "empty body does not match %s header", HeaderIdentityContentHash)
+		}
+		return nil
+	}
+	req.Body = &contentHashBody{
+		ReadCloser: req.Body,
+		hash:       sha256.New(),
+		expected:   contentHash,
+		length:     req.ContentLength,
+	}
+	return nil
+}
+
+func signatureError(reason string, format string, args ...interface{}) error {
+	signatureFailuresTotal.Inc(reason)
+	return fmt.Errorf("%w: %s", errSignature, fmt.Sprintf(format, args...))
+}
+
+// stringToSign returns the string signed by the requester, see NewSignatureVerifier.
+func stringToSign(req *http.Request, arn string, contentHash string) string {
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		contentHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{
+		signatureVersion,
+		arn,
+		req.Header.Get(HeaderIdentityTimestamp),
+		req.Header.Get(HeaderIdentityNonce),
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+}
+
+// contentHashBody fails the read of the last bytes of a request body that does not match its signed hash,
+// so that api server never receives the whole body.
+type contentHashBody struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected string
+	// length of the body, -1 if unknown.
+	length int64
+	read   int64
+}
+
+func (b *contentHashBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	b.read += int64(n)
+	if err == io.EOF || (b.length > 0 && b.read >= b.length) {
+		if hex.EncodeToString(b.hash.Sum(nil)) != b.expected {
+			signatureFailuresTotal.Inc(signatureFailureContentHash)
+			return 0, errContentHash
+		}
+	}
+	return n, err
+}
+
+// nonceCache remembers nonces until the timestamp they were signed with is too old to be accepted.
+type nonceCache struct {
+	pruneInterval time.Duration
+
+	lock      sync.Mutex
+	nonces    map[string]time.Time
+	nextPrune time.Time
+}
+
+func newNonceCache(pruneInterval time.Duration) *nonceCache {
+	return &nonceCache{
+		pruneInterval: pruneInterval,
+		nonces:        map[string]time.Time{},
+	}
+}
+
+// add records nonce until expiry, and returns false if it was already recorded.
+func (c *nonceCache) add(nonce string, expiry time.Time, now time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if now.After(c.nextPrune) {
+		for recorded, recordedExpiry := range c.nonces {
+			if now.After(recordedExpiry) {
+				delete(c.nonces, recorded)
+			}
+		}
+		c.nextPrune = now.Add(c.pruneInterval)
+	}
+	if recordedExpiry, ok := c.nonces[nonce]; ok && !now.After(recordedExpiry) {
+		return false
+	}
+	c.nonces[nonce] = expiry
+	return true
+}
@@ -0,0 +1,294 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+const testHMACKey = "0123456789abcdef0123456789abcdef"
+
+func TestSignatureSuite(t *testing.T) {
+	suite.Run(t, new(SignatureSuite))
+}
+
+type SignatureSuite struct {
+	suite.Suite
+
+	dirName string
+}
+
+func (suite *SignatureSuite) SetupTest() {
+	suite.dirName = suite.T().TempDir()
+}
+
+func (suite *SignatureSuite) TestTrustedWithoutAlgorithm() {
+	// prepare
+	verifier, err := NewSignatureVerifier(&config.SignatureConfig{})
+	suite.NoError(err)
+	request := httptest.NewRequest("GET", "http://foo-bar/api/v1/pods", nil)
+
+	// test
+	err = verifier.Verify(request, testIAMIdentity)
+
+	// verify
+	suite.NoError(err)
+}
+
+func (suite *SignatureSuite) TestHMAC() {
+	// prepare
+	verifier := suite.verifier(SignatureHMACSHA256, testHMACKey+"\n")
+	request := httptest.NewRequest("GET", "http://foo-bar/api/v1/namespaces/default/pods?limit=500", nil)
+	signIdentity(request, testIAMIdentity, nil, hmacSigner([]byte(testHMACKey)))
+
+	// test
+	err := verifier.Verify(request, testIAMIdentity)
+
+	// verify
+	suite.NoError(err)
+}
+
+func (suite *SignatureSuite) TestECDSA() {
+	// prepare
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Require().NoError(err)
+	verifier := suite.verifier(SignatureECDSASHA256, suite.publicKeyPEM(&privateKey.PublicKey))
+	request := httptest.NewRequest("GET", "http://foo-bar/api/v1/pods", nil)
+	signIdentity(request, testIAMIdentity, nil, func(message []byte) []byte {
+		digest := sha256.Sum256(message)
+		signature, err := ecdsa.SignASN1(rand.Reader, privateKey, digest[:])
+		suite.Require().NoError(err)
+		return signature
+	})
+
+	// test
+	err = verifier.Verify(request, testIAMIdentity)
+
+	// verify
+	suite.NoError(err)
+}
+
+func (suite *SignatureSuite) TestEd25519() {
+	// prepare
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	suite.Require().NoError(err)
+	verifier := suite.verifier(SignatureEd25519, suite.publicKeyPEM(publicKey))
+	request := httptest.NewRequest("GET", "http://foo-bar/api/v1/pods", nil)
+	signIdentity(request, testIAMIdentity, nil, func(message []byte) []byte {
+		return ed25519.Sign(privateKey, message)
+	})
+
+	// test
+	err = verifier.Verify(request, testIAMIdentity)
+
+	// verify
+	suite.NoError(err)
+}
+
+func (suite *SignatureSuite) TestRejectsTamperedRequest() {
+	// prepare
+	verifier := suite.verifier(SignatureHMACSHA256, testHMACKey)
+	request := httptest.NewRequest("GET", "http://foo-bar/api/v1/namespaces/default/pods", nil)
+	signIdentity(request, testIAMIdentity, nil, hmacSigner([]byte(testHMACKey)))
+
+	// test
+	errIdentity := verifier.Verify(request, "arn:aws:iam::123456789012:role/Admin")
+	request.URL.Path = "/api/v1/namespaces/kube-system/secrets"
+	errPath := verifier.Verify(request, testIAMIdentity)
+
+	// verify
+	suite.True(errors.Is(errIdentity, errSignature))
+	suite.True(errors.Is(errPath, errSignature))
+}
+
+func (suite *SignatureSuite) TestRejectsWrongKey() {
+	// prepare
+	verifier := suite.verifier(SignatureHMACSHA256, testHMACKey)
+	request := httptest.NewRequest("GET", "http://foo-bar/api/v1/pods", nil)
+	signIdentity(request, testIAMIdentity, nil, hmacSigner([]byte("fedcba9876543210fedcba9876543210")))
+
+	// test
+	err := verifier.Verify(request, testIAMIdentity)
+
+	// verify
+	suite.True(errors.Is(err, errSignature))
+}
+
+func (suite *SignatureSuite) TestRejectsMissingHeaders() {
+	verifier := suite.verifier(SignatureHMACSHA256, testHMACKey)
+	for _, header := range []string{HeaderIdentitySignature, HeaderIdentityTimestamp, HeaderIdentityNonce} {
+		// prepare
+		request := httptest.NewRequest("GET", "http://foo-bar/api/v1/pods", nil)
+		signIdentity(request, testIAMIdentity, nil, hmacSigner([]byte(testHMACKey)))
+		request.Header.Del(header)
+
+		// test
+		err := verifier.Verify(request, testIAMIdentity)
+
+		// verify
+		suite.True(errors.Is(err, errSignature), header)
+	}
+}
+
+func (suite *SignatureSuite) TestRejectsClockSkew() {
+	// prepare
+	verifier := suite.verifier(SignatureHMACSHA256, testHMACKey)
+	request := httptest.NewRequest("GET", "http://foo-bar/api/v1/pods", nil)
+	request.Header.Set(HeaderIdentityTimestamp, time.Now().Add(-10*time.Minute).UTC().Format(time.RFC3339))
+	signIdentity(request, testIAMIdentity, nil, hmacSigner([]byte(testHMACKey)))
+
+	// test
+	err := verifier.Verify(request, testIAMIdentity)
+
+	// verify
+	suite.True(errors.Is(err, errSignature))
+	suite.Contains(err.Error(), "away from")
+}
+
+func (suite *SignatureSuite) TestRejectsReplay() {
+	// prepare
+	verifier := suite.verifier(SignatureHMACSHA256, testHMACKey)
+	request := httptest.NewRequest("GET", "http://foo-bar/api/v1/pods", nil)
+	signIdentity(request, testIAMIdentity, nil, hmacSigner([]byte(testHMACKey)))
+
+	// test
+	err1 := verifier.Verify(request, testIAMIdentity)
+	err2 := verifier.Verify(request, testIAMIdentity)
+
+	// verify
+	suite.NoError(err1)
+	suite.True(errors.Is(err2, errSignature))
+	suite.Contains(err2.Error(), "already used")
+}
+
+func (suite *SignatureSuite) TestVerifiesBody() {
+	// prepare
+	verifier := suite.verifier(SignatureHMACSHA256, testHMACKey)
+	signedBody := []byte(`{"kind":"Pod"}`)
+	request := httptest.NewRequest("POST", "http://foo-bar/api/v1/namespaces/default/pods", bytes.NewReader(signedBody))
+	signIdentity(request, testIAMIdentity, signedBody, hmacSigner([]byte(testHMACKey)))
+	tampered := httptest.NewRequest("POST", "http://foo-bar/api/v1/namespaces/default/pods",
+		bytes.NewReader([]byte(`{"kind":"Pod","spec":{"hostPID":true}}`)))
+	signIdentity(tampered, testIAMIdentity, signedBody, hmacSigner([]byte(testHMACKey)))
+
+	// test
+	suite.NoError(verifier.Verify(request, testIAMIdentity))
+	suite.NoError(verifier.Verify(tampered, testIAMIdentity), "the body is verified while it is read")
+	body, err := io.ReadAll(request.Body)
+	_, tamperedErr := io.ReadAll(tampered.Body)
+
+	// verify
+	suite.NoError(err)
+	suite.Equal(signedBody, body)
+	suite.True(errors.Is(tamperedErr, errContentHash))
+}
+
+func (suite *SignatureSuite) TestInvalidKeys() {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Require().NoError(err)
+	for _, test := range []struct {
+		algorithm string
+		key       string
+	}{
+		{SignatureHMACSHA256, "too-short"},
+		{SignatureEd25519, "not a PEM key"},
+		{SignatureEd25519, suite.publicKeyPEM(&ecdsaKey.PublicKey)},
+		{"rsa-sha1", suite.publicKeyPEM(&ecdsaKey.PublicKey)},
+	} {
+		// prepare
+		keyFile := suite.writeKey(test.key)
+
+		// test
+		_, err := NewSignatureVerifier(&config.SignatureConfig{
+			Algorithm:    test.algorithm,
+			KeyFile:      keyFile,
+			MaxClockSkew: 5 * time.Minute,
+		})
+
+		// verify
+		suite.Error(err, test.algorithm)
+	}
+}
+
+func (suite *SignatureSuite) TestNonceCachePrunes() {
+	// prepare
+	cache := newNonceCache(time.Minute)
+	now := time.Now()
+	suite.True(cache.add("nonce-1", now.Add(time.Minute), now))
+
+	// test
+	later := now.Add(2 * time.Minute)
+	added := cache.add("nonce-2", later.Add(time.Minute), later)
+
+	// verify
+	suite.True(added)
+	suite.Len(cache.nonces, 1)
+	suite.True(cache.add("nonce-1", later.Add(time.Minute), later), "expired nonces are forgotten")
+}
+
+func (suite *SignatureSuite) verifier(algorithm, key string) IdentityVerifier {
+	verifier, err := NewSignatureVerifier(&config.SignatureConfig{
+		Algorithm:    algorithm,
+		KeyFile:      suite.writeKey(key),
+		MaxClockSkew: 5 * time.Minute,
+	})
+	suite.Require().NoError(err)
+	return verifier
+}
+
+func (suite *SignatureSuite) writeKey(key string) string {
+	keyFile, err := os.CreateTemp(suite.dirName, "key")
+	suite.Require().NoError(err)
+	defer keyFile.Close()
+	_, err = keyFile.WriteString(key)
+	suite.Require().NoError(err)
+	return keyFile.Name()
+}
+
+func (suite *SignatureSuite) publicKeyPEM(publicKey interface{}) string {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	suite.Require().NoError(err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// signIdentity sets the identity signature headers of request like a signing client,
+// keeping the timestamp of request if it is already set.
+func signIdentity(request *http.Request, arn string, body []byte, sign func(message []byte) []byte) {
+	request.Header.Set(HeaderIamArn, arn)
+	if request.Header.Get(HeaderIdentityTimestamp) == "" {
+		request.Header.Set(HeaderIdentityTimestamp, time.Now().UTC().Format(time.RFC3339))
+	}
+	request.Header.Set(HeaderIdentityNonce, uuid.New().String())
+	contentHash := sha256.Sum256(body)
+	request.Header.Set(HeaderIdentityContentHash, hex.EncodeToString(contentHash[:]))
+	signature := sign([]byte(stringToSign(request, arn, hex.EncodeToString(contentHash[:]))))
+	request.Header.Set(HeaderIdentitySignature, base64.StdEncoding.EncodeToString(signature))
+}
+
+func hmacSigner(key []byte) func(message []byte) []byte {
+	return func(message []byte) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(message)
+		return mac.Sum(nil)
+	}
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// writeStatus responds with a kubernetes Status object,
+// so that the error can be handled like any other api server error by clients.
+func writeStatus(res http.ResponseWriter, code int, reason metav1.StatusReason, message string) {
+	writeStatusDetails(res, code, reason, message, nil)
+}
+
+// writeStatusDetails responds with a kubernetes Status object with details about the error.
+func writeStatusDetails(res http.ResponseWriter, code int, reason metav1.StatusReason, message string,
+	details *metav1.StatusDetails) {
+	status := &metav1.Status{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Status",
+			APIVersion: "v1",
+		},
+		Status:  metav1.StatusFailure,
+		Message: message,
+		Reason:  reason,
+		Details: details,
+		Code:    int32(code),
+	}
+	body, err := json.Marshal(status)
+	if err != nil {
+		// metav1.Status is always serializable.
+		klog.Errorf("eks connector proxy failed to serialize status: %v", err)
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(code)
+	if _, err = res.Write(body); err != nil {
+		klog.Errorf("eks connector proxy failed to write status response: %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+const (
+	// FailoverRoundRobin spreads requests over the healthy api server endpoints.
+	FailoverRoundRobin = "roundrobin"
+	// FailoverPriority sends requests to the first healthy api server endpoint, in configured order.
+	FailoverPriority = "priority"
+)
+
+// ValidateFailoverPolicy returns an error if policy is not a known failover policy.
+func ValidateFailoverPolicy(policy string) error {
+	switch policy {
+	case "", FailoverRoundRobin, FailoverPriority:
+		return nil
+	default:
+		return fmt.Errorf("unknown failover policy %q", policy)
+	}
+}
+
+// target is an api server endpoint.
+type target struct {
+	host string
+	// healthy is 1 unless the last health check of the endpoint failed.
+	healthy int32
+	// ejectedUntil is when an endpoint that refused a connection is used again, in unix nanoseconds.
+	ejectedUntil int64
+}
+
+// available returns true if requests may be sent to the endpoint at now.
+func (t *target) available(now time.Time) bool {
+	return atomic.LoadInt32(&t.healthy) == 1 && now.UnixNano() >= atomic.LoadInt64(&t.ejectedUntil)
+}
+
+// targetPool picks the api server endpoint of each request.
+// Endpoints are skipped while their health check fails, and for a while after they refused a connection.
+type targetPool struct {
+	targets          []*target
+	policy           string
+	ejectionDuration time.Duration
+	next             uint32
+}
+
+func newTargetPool(proxyConfig *config.ProxyConfig) *targetPool {
+	hosts := proxyConfig.TargetHosts
+	if len(hosts) == 0 {
+		hosts = []string{proxyConfig.TargetHost}
+	}
+	pool := &targetPool{
+		policy:           proxyConfig.Failover.Policy,
+		ejectionDuration: proxyConfig.Failover.EjectionDuration,
+	}
+	for _, host := range hosts {
+		pool.targets = append(pool.targets, &target{
+			host:    host,
+			healthy: 1,
+		})
+		targetHealthy.Set(1, host)
+	}
+	return pool
+}
+
+// pick returns the endpoint of a request, other than the endpoints already tried.
+// If none of them is available, it still returns one, so that requests fail with the actual upstream error
+// rather than being rejected on stale health information.
+// It returns false once all endpoints were tried.
+func (p *targetPool) pick(tried map[string]bool) (string, bool) {
+	start := 0
+	if p.policy != FailoverPriority {
+		start = int(atomic.AddUint32(&p.next, 1) - 1)
+	}
+	now := time.Now()
+	fallback := ""
+	for i := range p.targets {
+		t := p.targets[(start+i)%len(p.targets)]
+		if tried[t.host] {
+			continue
+		}
+		if t.available(now) {
+			return t.host, true
+		}
+		if fallback == "" {
+			fallback = t.host
+		}
+	}
+	return fallback, fallback != ""
+}
+
+// eject skips host for the ejection duration.
+func (p *targetPool) eject(host string) {
+	if len(p.targets) < 2 {
+		return
+	}
+	for _, t := range p.targets {
+		if t.host == host {
+			klog.Infof("api server endpoint %s refused a connection, skipping it for %s", host, p.ejectionDuration)
+			atomic.StoreInt64(&t.ejectedUntil, time.Now().Add(p.ejectionDuration).UnixNano())
+			targetEjectionsTotal.Inc(host)
+			return
+		}
+	}
+}
+
+// setHealthy records the result of a health check of t.
+func (p *targetPool) setHealthy(t *target, err error) {
+	healthy := int32(0)
+	if err == nil {
+		healthy = 1
+	}
+	if atomic.SwapInt32(&t.healthy, healthy) == healthy {
+		return
+	}
+	if err != nil {
+		klog.Warningf("api server endpoint %s is unhealthy: %v", t.host, err)
+	} else {
+		klog.Infof("api server endpoint %s is healthy again", t.host)
+	}
+	targetHealthy.Set(float64(healthy), t.host)
+}
+
+// failoverTransport sends a request to the endpoint picked by the director,
+// and retries it on the other endpoints as long as it could not connect.
+type failoverTransport struct {
+	targets   *targetPool
+	transport http.RoundTripper
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := forwardErrorFrom(req.Context()); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	tried := map[string]bool{}
+	for {
+		res, err := t.transport.RoundTrip(req)
+		if err == nil || !isDialError(err) {
+			return res, err
+		}
+		t.targets.eject(req.URL.Host)
+		tried[req.URL.Host] = true
+		// the transport closed the body of the failed request, only requests without body can be sent again.
+		if req.Body != nil && req.Body != http.NoBody {
+			return nil, err
+		}
+		host, ok := t.targets.pick(tried)
+		if !ok {
+			return nil, err
+		}
+		klog.Infof("failed to connect to api server endpoint %s, retrying on %s: %v", req.URL.Host, host, err)
+		req = req.Clone(req.Context())
+		req.URL.Host = host
+	}
+}
+
+// isDialError returns true if err happened before the request was sent, while connecting to api server.
+func isDialError(err error) bool {
+	var opError *net.OpError
+	return errors.As(err, &opError) && opError.Op == "dial"
+}
+
+// checkTargets checks /readyz of each endpoint every health check interval, until ctx is done.
+func (p *proxy) checkTargets(ctx context.Context) {
+	failoverConfig := &p.ProxyConfig.Failover
+	ticker := time.NewTicker(failoverConfig.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		for _, t := range p.targets.targets {
+			checkCtx, cancel := context.WithTimeout(ctx, failoverConfig.HealthCheckTimeout)
+			err := p.checkTarget(checkCtx, t.host)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			p.targets.setHealthy(t, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckUpstream requests api server /readyz through the shared upstream transport,
+// so that it fails whenever proxied requests would fail to reach api server.
+// With several endpoints, it only fails if none of them is ready.
+func (p *proxy) CheckUpstream(ctx context.Context) error {
+	var failures []string
+	for _, t := range p.targets.targets {
+		err := p.checkTarget(ctx, t.host)
+		if err == nil {
+			return nil
+		}
+		if len(p.targets.targets) == 1 || errors.Is(err, errServiceAccount) {
+			return err
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", t.host, err))
+	}
+	return fmt.Errorf("no api server endpoint is ready: %s", strings.Join(failures, "; "))
+}
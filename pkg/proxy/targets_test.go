@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+func TestTargetsSuite(t *testing.T) {
+	suite.Run(t, new(TargetsSuite))
+}
+
+type TargetsSuite struct {
+	suite.Suite
+}
+
+func (suite *TargetsSuite) TestValidateFailoverPolicy() {
+	suite.NoError(ValidateFailoverPolicy(""))
+	suite.NoError(ValidateFailoverPolicy(FailoverRoundRobin))
+	suite.NoError(ValidateFailoverPolicy(FailoverPriority))
+	suite.Error(ValidateFailoverPolicy("random"))
+}
+
+func (suite *TargetsSuite) TestPickTargetHost() {
+	// prepare
+	pool := newTargetPool(&config.ProxyConfig{
+		TargetHost: "kubernetes.default.svc:443",
+	})
+
+	// test
+	host, ok := pool.pick(nil)
+
+	// verify
+	suite.True(ok)
+	suite.Equal("kubernetes.default.svc:443", host)
+}
+
+func (suite *TargetsSuite) TestPickRoundRobin() {
+	// prepare
+	pool := newTestTargetPool(FailoverRoundRobin)
+
+	// test
+	var hosts []string
+	for i := 0; i < 4; i++ {
+		host, _ := pool.pick(nil)
+		hosts = append(hosts, host)
+	}
+
+	// verify
+	suite.Equal([]string{"10.0.0.1:6443", "10.0.0.2:6443", "10.0.0.3:6443", "10.0.0.1:6443"}, hosts)
+}
+
+func (suite *TargetsSuite) TestPickPriority() {
+	// prepare
+	pool := newTestTargetPool(FailoverPriority)
+	pool.setHealthy(pool.targets[0], errors.New("connection refused"))
+
+	// test
+	var hosts []string
+	for i := 0; i < 2; i++ {
+		host, _ := pool.pick(nil)
+		hosts = append(hosts, host)
+	}
+
+	// verify
+	suite.Equal([]string{"10.0.0.2:6443", "10.0.0.2:6443"}, hosts, "the first healthy endpoint is used")
+}
+
+func (suite *TargetsSuite) TestPickSkipsEjected() {
+	// prepare
+	pool := newTestTargetPool(FailoverPriority)
+
+	// test
+	pool.eject("10.0.0.1:6443")
+	host, _ := pool.pick(nil)
+
+	// verify
+	suite.Equal("10.0.0.2:6443", host)
+}
+
+func (suite *TargetsSuite) TestPickEjectionExpires() {
+	// prepare
+	pool := newTestTargetPool(FailoverPriority)
+	pool.ejectionDuration = 10 * time.Millisecond
+
+	// test
+	pool.eject("10.0.0.1:6443")
+	time.Sleep(20 * time.Millisecond)
+	host, _ := pool.pick(nil)
+
+	// verify
+	suite.Equal("10.0.0.1:6443", host)
+}
+
+func (suite *TargetsSuite) TestPickNoneAvailable() {
+	// prepare
+	pool := newTestTargetPool(FailoverPriority)
+	for _, t := range pool.targets {
+		pool.setHealthy(t, errors.New("not ready"))
+	}
+
+	// test
+	host, ok := pool.pick(map[string]bool{"10.0.0.1:6443": true})
+
+	// verify
+	suite.True(ok, "requests are still sent when no endpoint is healthy")
+	suite.Equal("10.0.0.2:6443", host)
+}
+
+func (suite *TargetsSuite) TestPickAllTried() {
+	// prepare
+	pool := newTestTargetPool(FailoverRoundRobin)
+
+	// test
+	_, ok := pool.pick(map[string]bool{"10.0.0.1:6443": true, "10.0.0.2:6443": true, "10.0.0.3:6443": true})
+
+	// verify
+	suite.False(ok)
+}
+
+func (suite *TargetsSuite) TestSetHealthy() {
+	// prepare
+	pool := newTestTargetPool(FailoverPriority)
+
+	// test
+	pool.setHealthy(pool.targets[0], errors.New("not ready"))
+	unhealthy := pool.targets[0].available(time.Now())
+	pool.setHealthy(pool.targets[0], nil)
+	healthy := pool.targets[0].available(time.Now())
+
+	// verify
+	suite.False(unhealthy)
+	suite.True(healthy)
+}
+
+func newTestTargetPool(policy string) *targetPool {
+	return newTargetPool(&config.ProxyConfig{
+		TargetHosts: []string{"10.0.0.1:6443", "10.0.0.2:6443", "10.0.0.3:6443"},
+		Failover: config.FailoverConfig{
+			Policy:           policy,
+			EjectionDuration: time.Minute,
+		},
+	})
+}
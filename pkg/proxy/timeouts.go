@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+// Timeouts of long-running requests.
+const (
+	timeoutIdle     = "idle"
+	timeoutDuration = "duration"
+	// timeoutShutdown ends long-running requests when the proxy shuts down.
+	timeoutShutdown = "shutdown"
+)
+
+// withRequestTimeout bounds a short request by the request timeout of timeoutsConfig.
+// The returned cancel func must be called once the request is served.
+func withRequestTimeout(req *http.Request, timeoutsConfig *config.TimeoutsConfig) (*http.Request, context.CancelFunc) {
+	if timeoutsConfig.Request <= 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeoutsConfig.Request)
+	return req.WithContext(ctx), cancel
+}
+
+// watchdog calls expire once there was no activity for idleTimeout, or once maxDuration elapsed,
+// unless it is stopped before. A timeout of zero disables it.
+// It starts watching once run is called, so that expire may use what holds the watchdog.
+type watchdog struct {
+	idleTimeout time.Duration
+	maxDuration time.Duration
+	expire      func(timeout string)
+
+	start        time.Time
+	lastActivity int64
+	expireOnce   sync.Once
+	stopOnce     sync.Once
+	stopped      chan struct{}
+	// onStop is called once the watchdog is stopped, if set.
+	onStop func()
+}
+
+func newWatchdog(idleTimeout, maxDuration time.Duration, expire func(timeout string)) *watchdog {
+	now := time.Now()
+	return &watchdog{
+		idleTimeout:  idleTimeout,
+		maxDuration:  maxDuration,
+		expire:       expire,
+		start:        now,
+		lastActivity: now.UnixNano(),
+		stopped:      make(chan struct{}),
+	}
+}
+
+func (w *watchdog) run() {
+	if w.idleTimeout > 0 || w.maxDuration > 0 {
+		go w.watch()
+	}
+}
+
+// touch records activity, which resets the idle timeout.
+func (w *watchdog) touch() {
+	atomic.StoreInt64(&w.lastActivity, time.Now().UnixNano())
+}
+
+func (w *watchdog) stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopped)
+		if w.onStop != nil {
+			w.onStop()
+		}
+	})
+}
+
+// fire calls expire for timeout, unless the watchdog is stopped or expired already.
+func (w *watchdog) fire(timeout string) {
+	select {
+	case <-w.stopped:
+		return
+	default:
+	}
+	w.expireOnce.Do(func() {
+		w.expire(timeout)
+	})
+}
+
+func (w *watchdog) watch() {
+	timer := time.NewTimer(w.nextCheck(time.Now()))
+	defer timer.Stop()
+	for {
+		select {
+		case <-w.stopped:
+			return
+		case now := <-timer.C:
+			if timeout := w.expired(now); timeout != "" {
+				w.fire(timeout)
+				return
+			}
+			timer.Reset(w.nextCheck(now))
+		}
+	}
+}
+
+// expired returns which timeout expired at now, if any.
+func (w *watchdog) expired(now time.Time) string {
+	if w.maxDuration > 0 && !now.Before(w.start.Add(w.maxDuration)) {
+		return timeoutDuration
+	}
+	lastActivity := time.Unix(0, atomic.LoadInt64(&w.lastActivity))
+	if w.idleTimeout > 0 && !now.Before(lastActivity.Add(w.idleTimeout)) {
+		return timeoutIdle
+	}
+	return ""
+}
+
+// nextCheck returns the time until the earliest timeout could expire.
+func (w *watchdog) nextCheck(now time.Time) time.Duration {
+	var next time.Time
+	if w.maxDuration > 0 {
+		next = w.start.Add(w.maxDuration)
+	}
+	if w.idleTimeout > 0 {
+		idleDeadline := time.Unix(0, atomic.LoadInt64(&w.lastActivity)).Add(w.idleTimeout)
+		if next.IsZero() || idleDeadline.Before(next) {
+			next = idleDeadline
+		}
+	}
+	return next.Sub(now)
+}
+
+// watchdogSet tracks the watchdogs of open long-running requests, so that they all end when the proxy shuts down.
+type watchdogSet struct {
+	lock      sync.Mutex
+	watchdogs map[*watchdog]struct{}
+	draining  bool
+}
+
+func newWatchdogSet() *watchdogSet {
+	return &watchdogSet{
+		watchdogs: map[*watchdog]struct{}{},
+	}
+}
+
+// run runs w until it is stopped, or expires it at once if the set is draining.
+func (s *watchdogSet) run(w *watchdog) {
+	w.onStop = func() {
+		s.lock.Lock()
+		delete(s.watchdogs, w)
+		s.lock.Unlock()
+	}
+	s.lock.Lock()
+	draining := s.draining
+	if !draining {
+		s.watchdogs[w] = struct{}{}
+	}
+	s.lock.Unlock()
+	if draining {
+		w.fire(timeoutShutdown)
+		return
+	}
+	w.run()
+}
+
+// drain expires the running watchdogs, and those run afterwards, with the shutdown timeout.
+func (s *watchdogSet) drain() {
+	s.lock.Lock()
+	s.draining = true
+	watchdogs := make([]*watchdog, 0, len(s.watchdogs))
+	for w := range s.watchdogs {
+		watchdogs = append(watchdogs, w)
+	}
+	s.lock.Unlock()
+	for _, w := range watchdogs {
+		w.fire(timeoutShutdown)
+	}
+}
+
+// streamBody is the body of a watch or log follow response, which it ends once idle or open for too long.
+// Like api server at the end of a watch, it ends the body cleanly, so that clients simply start a new request.
+type streamBody struct {
+	io.ReadCloser
+	watchdog *watchdog
+	expired  int32
+}
+
+func newStreamBody(body io.ReadCloser, timeoutsConfig *config.TimeoutsConfig, watchdogs *watchdogSet) *streamBody {
+	stream := &streamBody{
+		ReadCloser: body,
+	}
+	stream.watchdog = newWatchdog(timeoutsConfig.LongRunningIdleTimeout, timeoutsConfig.LongRunningMaxDuration,
+		func(timeout string) {
+			klog.V(2).Infof("ending streamed response on %s timeout", timeout)
+			streamTimeoutsTotal.Inc(timeout)
+			atomic.StoreInt32(&stream.expired, 1)
+			// closing the body unblocks the pending read.
+			_ = body.Close()
+		})
+	watchdogs.run(stream.watchdog)
+	return stream
+}
+
+func (b *streamBody) Read(data []byte) (int, error) {
+	n, err := b.ReadCloser.Read(data)
+	if n > 0 {
+		b.watchdog.touch()
+	}
+	if err != nil && atomic.LoadInt32(&b.expired) == 1 {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func (b *streamBody) Close() error {
+	b.watchdog.stop()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+	"github.com/aws/amazon-eks-connector/pkg/serviceaccount"
+)
+
+const (
+	dialTimeout   = 30 * time.Second
+	dialKeepAlive = 30 * time.Second
+
+	pathReadyz = "/readyz"
+	// maxCheckBodySize is the part of a failed api server /readyz response reported by health checks.
+	maxCheckBodySize = 1024
+)
+
+// upstream is a reverse proxy bound to one service account secret.
+// It is shared by all requests until the secret changes,
+// so that keep-alive connections to api server are reused across requests.
+// Sessions go through a separate HTTP/1.1 transport, as connections cannot be upgraded over HTTP/2.
+type upstream struct {
+	secret           *serviceaccount.Secret
+	transport        *http.Transport
+	reverseProxy     *httputil.ReverseProxy
+	streamProxy      *httputil.ReverseProxy
+	sessionTransport *http.Transport
+	sessionProxy     *httputil.ReverseProxy
+}
+
+// matches returns true if the upstream was built from a secret identical to the given one.
+func (u *upstream) matches(secret *serviceaccount.Secret) bool {
+	return u.secret.Token == secret.Token && u.secret.RootCAs.Equal(secret.RootCAs) &&
+		u.secret.ServerName == secret.ServerName &&
+		sameCertificate(u.secret.ClientCertificate, secret.ClientCertificate)
+}
+
+func sameCertificate(a, b *tls.Certificate) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if len(a.Certificate) != len(b.Certificate) {
+		return false
+	}
+	for i := range a.Certificate {
+		if !bytes.Equal(a.Certificate[i], b.Certificate[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// upstream returns the shared upstream for secret,
+// building a new one only if the CA bundle or the token changed since the last request.
+func (p *proxy) upstream(secret *serviceaccount.Secret) *upstream {
+	p.upstreamLock.RLock()
+	current := p.current
+	p.upstreamLock.RUnlock()
+	if current != nil && current.matches(secret) {
+		return current
+	}
+
+	p.upstreamLock.Lock()
+	defer p.upstreamLock.Unlock()
+	// another request may have rebuilt the upstream while we were waiting for the lock.
+	if p.current != nil && p.current.matches(secret) {
+		return p.current
+	}
+	if p.current != nil {
+		klog.Infof("service account secret changed, rebuilding upstream transport")
+		// in-flight requests keep their connections, only idle ones are dropped.
+		p.current.transport.CloseIdleConnections()
+		p.current.sessionTransport.CloseIdleConnections()
+	}
+	p.current = p.newUpstream(secret)
+	return p.current
+}
+
+func (p *proxy) newUpstream(secret *serviceaccount.Secret) *upstream {
+	transport := newTransport(&p.ProxyConfig.Transport, secret)
+	sessionTransport := newSessionTransport(&p.ProxyConfig.Transport, secret)
+	failover := &failoverTransport{targets: p.targets, transport: transport}
+	sessionFailover := &failoverTransport{targets: p.targets, transport: sessionTransport}
+	return &upstream{
+		secret:           secret,
+		transport:        transport,
+		reverseProxy:     p.newReverseProxy(secret, failover, false),
+		streamProxy:      p.newStreamProxy(secret, failover),
+		sessionTransport: sessionTransport,
+		sessionProxy:     p.newReverseProxy(secret, sessionFailover, true),
+	}
+}
+
+// newReverseProxy returns a reverse proxy to api server through transport.
+// If session is set, it also forwards the headers that upgrade the connection.
+func (p *proxy) newReverseProxy(secret *serviceaccount.Secret, transport http.RoundTripper, session bool) *httputil.ReverseProxy {
+	director := func(req *http.Request) {
+		target := p.proxyUrl(req)
+
+		// override the scheme and host.
+		req.URL.Scheme = target.Scheme
+		req.URL.Host = target.Host
+		req.URL.RawQuery = target.RawQuery
+		req.URL.Path = target.Path
+		req.URL.RawPath = target.RawPath
+
+		klog.V(2).Infof("rewritten URL to %s", req.URL)
+
+		originalHeader := req.Header
+		if err := p.proxyHeader(req, secret); err != nil {
+			// the director cannot fail the request, failoverTransport returns the error instead of sending it.
+			*req = *req.WithContext(withForwardError(req.Context(), err))
+			return
+		}
+		if session {
+			attributes, _ := attributesFrom(req.Context())
+			forwardUpgradeHeaders(originalHeader, req.Header, p.isRecorded(sessionType(attributes.Request)))
+		}
+	}
+	return &httputil.ReverseProxy{
+		Director:     director,
+		Transport:    transport,
+		ErrorHandler: p.proxyError,
+		// ServeHTTP already set the Audit-ID response header that api server echoes.
+		ModifyResponse: func(res *http.Response) error {
+			res.Header.Del(HeaderAuditID)
+			if res.StatusCode == http.StatusUnauthorized {
+				p.reloadServiceAccount()
+			}
+			return nil
+		},
+	}
+}
+
+// reloadServiceAccount reloads a cached service account secret after api server rejected its token,
+// which was likely rotated since it was cached. Requests are never authenticated with requester credentials,
+// so a 401 always means that the token of eks connector is not valid anymore.
+func (p *proxy) reloadServiceAccount() {
+	if reloader, ok := p.ServiceAccount.(serviceaccount.ReloadableSecretProvider); ok {
+		klog.Infof("api server rejected the service account token, reloading it")
+		_ = reloader.Reload(serviceaccount.ReloadUnauthorized)
+	}
+}
+
+// newStreamProxy returns the reverse proxy of watch and log follow requests,
+// which flushes every write and ends responses once idle or open for too long.
+func (p *proxy) newStreamProxy(secret *serviceaccount.Secret, transport http.RoundTripper) *httputil.ReverseProxy {
+	streamProxy := p.newReverseProxy(secret, transport, false)
+	streamProxy.FlushInterval = -1
+	modifyResponse := streamProxy.ModifyResponse
+	streamProxy.ModifyResponse = func(res *http.Response) error {
+		// the body of an upgraded response is the connection itself.
+		if res.StatusCode != http.StatusSwitchingProtocols {
+			res.Body = newStreamBody(res.Body, &p.ProxyConfig.Timeouts, p.watchdogs)
+		}
+		return modifyResponse(res)
+	}
+	return streamProxy
+}
+
+func newTransport(transportConfig *config.TransportConfig, secret *serviceaccount.Secret) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: dialKeepAlive,
+	}
+	return &http.Transport{
+		DialContext:       dialer.DialContext,
+		TLSClientConfig:   newTLSConfig(secret),
+		ForceAttemptHTTP2: transportConfig.EnableHTTP2,
+		// requests usually go to the same api server, so the per host limit is the pool limit.
+		MaxIdleConns:        transportConfig.MaxIdleConns,
+		MaxIdleConnsPerHost: transportConfig.MaxIdleConns,
+		IdleConnTimeout:     transportConfig.IdleConnTimeout,
+		TLSHandshakeTimeout: transportConfig.TLSHandshakeTimeout,
+	}
+}
+
+func newTLSConfig(secret *serviceaccount.Secret) *tls.Config {
+	tlsConfig := &tls.Config{
+		RootCAs:    secret.RootCAs,
+		ServerName: secret.ServerName,
+	}
+	if secret.ClientCertificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*secret.ClientCertificate}
+	}
+	return tlsConfig
+}
+
+// newSessionTransport returns a transport that only speaks HTTP/1.1 to api server, so that connections can be upgraded.
+// Upgraded connections are not reused, so it keeps no idle connection.
+func newSessionTransport(transportConfig *config.TransportConfig, secret *serviceaccount.Secret) *http.Transport {
+	transport := newTransport(transportConfig, secret)
+	transport.ForceAttemptHTTP2 = false
+	transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	transport.DisableKeepAlives = true
+	return transport
+}
+
+// checkTarget requests /readyz of the api server endpoint host through the shared upstream transport.
+func (p *proxy) checkTarget(ctx context.Context, host string) error {
+	secret, err := p.ServiceAccount.Get()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errServiceAccount, err)
+	}
+	target := &url.URL{
+		Scheme: p.ProxyConfig.TargetProtocol,
+		Host:   host,
+		Path:   pathReadyz,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	if secret.Token != "" {
+		req.Header.Set(HeaderAuthorization, "Bearer "+secret.Token)
+	}
+	req.Header.Set(HeaderUserAgent, HeaderValueUserAgent)
+
+	res, err := p.upstream(secret).transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusUnauthorized {
+		p.reloadServiceAccount()
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, maxCheckBodySize))
+		return fmt.Errorf("api server %s returned %d: %s", pathReadyz, res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	// drain the body so that the connection is reused.
+	_, _ = io.Copy(io.Discard, res.Body)
+	return nil
+}
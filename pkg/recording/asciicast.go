@@ -0,0 +1,194 @@
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	castVersion = 2
+	// the terminal size until the client sends its own.
+	defaultWidth  = 80
+	defaultHeight = 24
+
+	eventInput  = "i"
+	eventOutput = "o"
+	eventResize = "r"
+)
+
+// castHeader is the first line of an asciicast v2 file.
+type castHeader struct {
+	Version   int    `json:"version"`
+	Width     uint16 `json:"width"`
+	Height    uint16 `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Command   string `json:"command,omitempty"`
+	Title     string `json:"title"`
+
+	// the following fields are specific to eks connector, and ignored by asciicast players.
+	Session *Session `json:"session"`
+	// Part numbers the files of a recording larger than the maximum file size, from 0.
+	Part int `json:"part"`
+}
+
+// castWriter writes the events of a session to asciicast files,
+// continuing in a new file whenever the current one would exceed the maximum file size.
+type castWriter struct {
+	recorder *fileRecorder
+	name     string
+	session  *Session
+
+	lock   sync.Mutex
+	file   *os.File
+	size   int64
+	part   int
+	width  uint16
+	height uint16
+	// incomplete holds the trailing bytes of a utf-8 sequence split across frames, by channel.
+	incomplete map[byte][]byte
+}
+
+func newCastWriter(recorder *fileRecorder, name string, session *Session) (*castWriter, error) {
+	w := &castWriter{
+		recorder:   recorder,
+		name:       name,
+		session:    session,
+		width:      defaultWidth,
+		height:     defaultHeight,
+		incomplete: map[byte][]byte{},
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// event records data of channel as an event of eventType.
+func (w *castWriter) event(eventType string, channel byte, data []byte) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	data = append(w.incomplete[channel], data...)
+	data, w.incomplete[channel] = splitIncompleteRune(data)
+	if len(data) == 0 {
+		return nil
+	}
+	return w.write(eventType, string(data))
+}
+
+// resize records a change of the terminal size.
+func (w *castWriter) resize(width, height uint16) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.width, w.height = width, height
+	return w.write(eventResize, fmt.Sprintf("%dx%d", width, height))
+}
+
+func (w *castWriter) close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	for channel, data := range w.incomplete {
+		if len(data) > 0 {
+			eventType := eventOutput
+			if channel == channelStdin {
+				eventType = eventInput
+			}
+			_ = w.write(eventType, string(data))
+		}
+	}
+	err := w.recorder.release(w.file)
+	w.file = nil
+	return err
+}
+
+// write writes an event line. It must be called with the lock held.
+func (w *castWriter) write(eventType, data string) error {
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	elapsed := json.Number(strconv.FormatFloat(time.Since(w.session.Start).Seconds(), 'f', 6, 64))
+	line, err := json.Marshal([]interface{}{elapsed, eventType, data})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if w.size+int64(len(line)) > w.recorder.maxFileSize {
+		if err = w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+// open creates the file of the current part and writes its header.
+func (w *castWriter) open() error {
+	name := w.name + recordingFileExt
+	if w.part > 0 {
+		name = fmt.Sprintf("%s.%d%s", w.name, w.part, recordingFileExt)
+	}
+	file, err := w.recorder.create(name)
+	if err != nil {
+		return err
+	}
+	header, err := json.Marshal(&castHeader{
+		Version:   castVersion,
+		Width:     w.width,
+		Height:    w.height,
+		Timestamp: w.session.Start.Unix(),
+		Command:   strings.Join(w.session.Command, " "),
+		Title:     fmt.Sprintf("%s %s/%s by %s", w.session.Type, w.session.Namespace, w.session.Pod, w.session.IAMIdentity),
+		Session:   w.session,
+		Part:      w.part,
+	})
+	if err != nil {
+		_ = w.recorder.release(file)
+		return err
+	}
+	header = append(header, '\n')
+	n, err := file.Write(header)
+	if err != nil {
+		_ = w.recorder.release(file)
+		return err
+	}
+	w.file = file
+	w.size = int64(n)
+	return nil
+}
+
+// rotate continues the recording in the file of the next part. It must be called with the lock held.
+func (w *castWriter) rotate() error {
+	if err := w.recorder.release(w.file); err != nil {
+		return err
+	}
+	w.file = nil
+	w.part++
+	return w.open()
+}
+
+// splitIncompleteRune splits data before a trailing incomplete utf-8 sequence, if any,
+// so that multi-byte characters split across frames are recorded whole.
+func splitIncompleteRune(data []byte) ([]byte, []byte) {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		start := len(data) - i
+		if utf8.RuneStart(data[start]) {
+			if !utf8.FullRune(data[start:]) {
+				return data[:start], append([]byte{}, data[start:]...)
+			}
+			break
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,316 @@
+// Package recording records the exec and attach sessions proxied by eks connector in the asciicast v2 format,
+// so that interactive access to containers can be replayed keystroke by keystroke.
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+const (
+	defaultMaxFileSize = 10 * 1024 * 1024
+	recordingDirMode   = 0700
+	recordingFileMode  = 0600
+	recordingFileExt   = ".cast"
+
+	// maxIdentityNameSize bounds the part of recording file names taken from the IAM identity.
+	maxIdentityNameSize = 100
+)
+
+// Session describes a recorded session.
+type Session struct {
+	// RequestID is also the Audit-ID of the session request, to correlate with audit logs.
+	RequestID   string   `json:"requestID"`
+	IAMIdentity string   `json:"iamIdentity"`
+	User        string   `json:"user,omitempty"`
+	Type        string   `json:"type"`
+	Namespace   string   `json:"namespace"`
+	Pod         string   `json:"pod"`
+	Container   string   `json:"container,omitempty"`
+	Command     []string `json:"command,omitempty"`
+	TTY         bool     `json:"tty"`
+	// Protocol is the protocol the session connection is upgraded to, i.e. SPDY/3.1 or websocket.
+	Protocol string    `json:"protocol"`
+	Start    time.Time `json:"start"`
+}
+
+// Recorder records sessions.
+type Recorder interface {
+	Enabled() bool
+	// Record starts the recording of session.
+	Record(session *Session) (Recording, error)
+}
+
+// Recording receives the raw traffic of a session connection, and records its terminal channels.
+// An error means that the traffic could not be recorded, and should not be forwarded.
+type Recording interface {
+	// ClientData records the bytes read from the client.
+	ClientData(data []byte) error
+	// ServerData records the bytes sent by api server to the client.
+	ServerData(data []byte) error
+	// Close ends the recording.
+	Close() error
+}
+
+// NewRecorder returns a Recorder writing to the directory of recordingConfig.
+func NewRecorder(recordingConfig *config.RecordingConfig) (Recorder, error) {
+	if recordingConfig.Dir == "" {
+		return NewNopRecorder(), nil
+	}
+	if err := os.MkdirAll(recordingConfig.Dir, recordingDirMode); err != nil {
+		return nil, err
+	}
+	maxFileSize := int64(recordingConfig.MaxFileSizeMB) * 1024 * 1024
+	if maxFileSize <= 0 {
+		maxFileSize = defaultMaxFileSize
+	}
+
+	klog.Infof("recording exec and attach sessions to %s", recordingConfig.Dir)
+	return &fileRecorder{
+		dir:          recordingConfig.Dir,
+		maxFileSize:  maxFileSize,
+		maxTotalSize: int64(recordingConfig.MaxTotalSizeMB) * 1024 * 1024,
+		open:         map[string]bool{},
+	}, nil
+}
+
+// NewNopRecorder returns a Recorder that records nothing.
+func NewNopRecorder() Recorder {
+	return &nopRecorder{}
+}
+
+type nopRecorder struct {
+}
+
+func (r *nopRecorder) Enabled() bool {
+	return false
+}
+
+func (r *nopRecorder) Record(session *Session) (Recording, error) {
+	return &nopRecording{}, nil
+}
+
+type nopRecording struct {
+}
+
+func (r *nopRecording) ClientData(data []byte) error {
+	return nil
+}
+
+func (r *nopRecording) ServerData(data []byte) error {
+	return nil
+}
+
+func (r *nopRecording) Close() error {
+	return nil
+}
+
+// fileRecorder writes each session to its own files in dir,
+// deleting the oldest files once dir exceeds maxTotalSize.
+type fileRecorder struct {
+	dir          string
+	maxFileSize  int64
+	maxTotalSize int64
+
+	lock sync.Mutex
+	// open are the files being written, which are never deleted.
+	open map[string]bool
+}
+
+func (r *fileRecorder) Enabled() bool {
+	return true
+}
+
+func (r *fileRecorder) Record(session *Session) (Recording, error) {
+	recording := &sessionRecording{}
+	var err error
+	switch {
+	case strings.EqualFold(session.Protocol, "websocket"):
+		recording.client = &websocketDecoder{write: recording.channel}
+		recording.server = &websocketDecoder{write: recording.channel}
+	case strings.HasPrefix(strings.ToUpper(session.Protocol), "SPDY/3"):
+		streams := newSPDYStreams()
+		recording.client = &spdyDecoder{write: recording.channel, streams: streams}
+		recording.server = &spdyDecoder{write: recording.channel, streams: streams}
+	default:
+		return nil, fmt.Errorf("cannot record sessions over protocol %q", session.Protocol)
+	}
+	recording.writer, err = newCastWriter(r, fileName(session), session)
+	if err != nil {
+		return nil, err
+	}
+	return recording, nil
+}
+
+// create creates a recording file, after making room for it in dir.
+func (r *fileRecorder) create(name string) (*os.File, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.prune()
+	path := filepath.Join(r.dir, name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, recordingFileMode)
+	if err != nil {
+		return nil, err
+	}
+	r.open[path] = true
+	return file, nil
+}
+
+// release marks a recording file as complete, so that it can be pruned.
+func (r *fileRecorder) release(file *os.File) error {
+	r.lock.Lock()
+	delete(r.open, file.Name())
+	r.lock.Unlock()
+	return file.Close()
+}
+
+// prune deletes the oldest complete recording files until dir is below maxTotalSize.
+// It must be called with the lock held.
+func (r *fileRecorder) prune() {
+	if r.maxTotalSize <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		klog.Errorf("failed to list session recordings: %v", err)
+		return
+	}
+	var files []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != recordingFileExt {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+		if !r.open[filepath.Join(r.dir, info.Name())] {
+			files = append(files, info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, info := range files {
+		if total <= r.maxTotalSize {
+			return
+		}
+		if err := os.Remove(filepath.Join(r.dir, info.Name())); err != nil {
+			klog.Errorf("failed to delete session recording %s: %v", info.Name(), err)
+			continue
+		}
+		klog.Infof("deleted session recording %s, recordings exceed %d bytes", info.Name(), r.maxTotalSize)
+		total -= info.Size()
+	}
+}
+
+// fileName keys the recording of session by start time, IAM identity and pod.
+func fileName(session *Session) string {
+	identity := sanitize(session.IAMIdentity)
+	if len(identity) > maxIdentityNameSize {
+		identity = identity[:maxIdentityNameSize]
+	}
+	return strings.Join([]string{
+		session.Start.UTC().Format("20060102T150405Z"),
+		identity,
+		sanitize(session.Namespace),
+		sanitize(session.Pod),
+		sanitize(session.RequestID),
+	}, "_")
+}
+
+// sanitize replaces the characters of name that are not safe in file names.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// sessionRecording decodes the stream protocol of a session connection into terminal events.
+// Client and server data are decoded concurrently, by their own decoder.
+type sessionRecording struct {
+	writer *castWriter
+	client decoder
+	server decoder
+
+	// resize holds an incomplete terminal size sent by the client.
+	resize []byte
+}
+
+func (r *sessionRecording) ClientData(data []byte) error {
+	if err := r.client.decode(data); err != nil {
+		return fmt.Errorf("failed to decode client data: %w", err)
+	}
+	return nil
+}
+
+func (r *sessionRecording) ServerData(data []byte) error {
+	if err := r.server.decode(data); err != nil {
+		return fmt.Errorf("failed to decode server data: %w", err)
+	}
+	return nil
+}
+
+func (r *sessionRecording) Close() error {
+	return r.writer.close()
+}
+
+// terminalSize is the payload of the resize channel.
+type terminalSize struct {
+	Width  uint16
+	Height uint16
+}
+
+// channel records data received on a channel of the session.
+func (r *sessionRecording) channel(channel byte, data []byte) error {
+	switch channel {
+	case channelStdin:
+		return r.writer.event(eventInput, channel, data)
+	case channelStdout, channelStderr:
+		return r.writer.event(eventOutput, channel, data)
+	case channelResize:
+		return r.resizeData(data)
+	default:
+		// the error channel carries the exit status, which is already in api server audit logs.
+		return nil
+	}
+}
+
+// resizeData records the terminal sizes of data, a stream of json objects.
+func (r *sessionRecording) resizeData(data []byte) error {
+	r.resize = append(r.resize, data...)
+	decoder := json.NewDecoder(strings.NewReader(string(r.resize)))
+	var consumed int64
+	for {
+		size := terminalSize{}
+		if err := decoder.Decode(&size); err != nil {
+			break
+		}
+		consumed = decoder.InputOffset()
+		if err := r.writer.resize(size.Width, size.Height); err != nil {
+			return err
+		}
+	}
+	r.resize = r.resize[consumed:]
+	if len(r.resize) > maxResizeSize {
+		return fmt.Errorf("invalid terminal size %q", r.resize)
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+const (
+	testIAMIdentity = "arn:aws:iam::123456789012:role/coder"
+	testRequestID   = "2c3f7a52-0fd4-4b36-9d3c-9a3e6c9b9c11"
+)
+
+func TestRecordingSuite(t *testing.T) {
+	suite.Run(t, new(RecordingSuite))
+}
+
+type RecordingSuite struct {
+	suite.Suite
+
+	dir string
+}
+
+func (suite *RecordingSuite) SetupTest() {
+	suite.dir = suite.T().TempDir()
+}
+
+func (suite *RecordingSuite) TestNewRecorderDisabled() {
+	// test
+	recorder, err := NewRecorder(&config.RecordingConfig{})
+
+	// verify
+	suite.NoError(err)
+	suite.False(recorder.Enabled())
+}
+
+func (suite *RecordingSuite) TestRecordSPDY() {
+	// prepare
+	recorder := suite.newRecorder(&config.RecordingConfig{})
+	client := newTestSPDYClient()
+
+	// test
+	recording, err := recorder.Record(testSession("SPDY/3.1"))
+	suite.Require().NoError(err)
+	suite.NoError(recording.ClientData(client.synStream(1, "error")))
+	suite.NoError(recording.ClientData(client.synStream(3, "stdin")))
+	suite.NoError(recording.ClientData(client.synStream(5, "stdout")))
+	suite.NoError(recording.ClientData(client.synStream(7, "resize")))
+	suite.NoError(recording.ClientData(spdyDataFrame(7, []byte(`{"Width":120,"Height":40}`+"\n"))))
+	stdin := spdyDataFrame(3, []byte("ls\r"))
+	// frames may be split across reads.
+	suite.NoError(recording.ClientData(stdin[:5]))
+	suite.NoError(recording.ClientData(stdin[5:]))
+	suite.NoError(recording.ServerData(spdyDataFrame(5, []byte("bin  etc\r\n"))))
+	suite.NoError(recording.ServerData(spdyDataFrame(1, []byte(`{"status":"Success"}`))))
+	suite.NoError(recording.Close())
+
+	// verify
+	header, events := suite.readRecording(suite.onlyFile())
+	suite.Equal(2, header.Version)
+	suite.Equal(testIAMIdentity, header.Session.IAMIdentity)
+	suite.Equal("web", header.Session.Pod)
+	suite.Equal([][2]string{
+		{eventResize, "120x40"},
+		{eventInput, "ls\r"},
+		{eventOutput, "bin  etc\r\n"},
+	}, events)
+}
+
+func (suite *RecordingSuite) TestRecordWebsocket() {
+	// prepare
+	recorder := suite.newRecorder(&config.RecordingConfig{})
+
+	// test
+	recording, err := recorder.Record(testSession("websocket"))
+	suite.Require().NoError(err)
+	suite.NoError(recording.ClientData(newWebsocketFrame(websocketBinary, true, append([]byte{channelStdin}, "whoami\r"...))))
+	suite.NoError(recording.ServerData(newWebsocketFrame(websocketBinary, false, append([]byte{channelStdout}, "root\r\n"...))))
+	suite.NoError(recording.ServerData(newWebsocketFrame(websocketText, false, []byte("2"+base64.StdEncoding.EncodeToString([]byte("warning\n"))))))
+	suite.NoError(recording.ServerData(newWebsocketFrame(websocketClose, false, nil)))
+	suite.NoError(recording.Close())
+
+	// verify
+	_, events := suite.readRecording(suite.onlyFile())
+	suite.Equal([][2]string{
+		{eventInput, "whoami\r"},
+		{eventOutput, "root\r\n"},
+		{eventOutput, "warning\n"},
+	}, events)
+}
+
+func (suite *RecordingSuite) TestRecordSplitsMultiByteCharacters() {
+	// prepare
+	recorder := suite.newRecorder(&config.RecordingConfig{})
+	euro := []byte("€")
+
+	// test
+	recording, err := recorder.Record(testSession("websocket"))
+	suite.Require().NoError(err)
+	suite.NoError(recording.ServerData(newWebsocketFrame(websocketBinary, false, append([]byte{channelStdout}, euro[:1]...))))
+	suite.NoError(recording.ServerData(newWebsocketFrame(websocketBinary, false, append([]byte{channelStdout}, euro[1:]...))))
+	suite.NoError(recording.Close())
+
+	// verify
+	_, events := suite.readRecording(suite.onlyFile())
+	suite.Equal([][2]string{{eventOutput, "€"}}, events)
+}
+
+func (suite *RecordingSuite) TestRecordInvalidFrame() {
+	// prepare
+	recorder := suite.newRecorder(&config.RecordingConfig{})
+	recording, err := recorder.Record(testSession("websocket"))
+	suite.Require().NoError(err)
+	defer recording.Close()
+
+	// test
+	err = recording.ClientData([]byte{websocketFinal | websocketBinary, 127, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	// verify
+	suite.Error(err)
+}
+
+func (suite *RecordingSuite) TestRecordUnsupportedProtocol() {
+	// prepare
+	recorder := suite.newRecorder(&config.RecordingConfig{})
+
+	// test
+	_, err := recorder.Record(testSession("h2c"))
+
+	// verify
+	suite.Error(err)
+	entries, _ := os.ReadDir(suite.dir)
+	suite.Empty(entries)
+}
+
+func (suite *RecordingSuite) TestRecordContinuesInNewFile() {
+	// prepare
+	recorder := suite.newRecorder(&config.RecordingConfig{})
+	recorder.(*fileRecorder).maxFileSize = 1024
+	recording, err := recorder.Record(testSession("websocket"))
+	suite.Require().NoError(err)
+	output := bytes.Repeat([]byte("a"), 400)
+
+	// test
+	for i := 0; i < 4; i++ {
+		suite.NoError(recording.ServerData(newWebsocketFrame(websocketBinary, false, append([]byte{channelStdout}, output...))))
+	}
+	suite.NoError(recording.Close())
+
+	// verify
+	name := fileName(testSession("websocket"))
+	for part, file := range []string{name + ".cast", name + ".1.cast", name + ".2.cast"} {
+		header, events := suite.readRecording(filepath.Join(suite.dir, file))
+		suite.Equal(part, header.Part)
+		suite.NotEmpty(events)
+	}
+}
+
+func (suite *RecordingSuite) TestRecordDeletesOldestRecordings() {
+	// prepare
+	old := filepath.Join(suite.dir, "old.cast")
+	suite.NoError(os.WriteFile(old, bytes.Repeat([]byte("a"), 1024*1024), 0600))
+	suite.NoError(os.Chtimes(old, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+	recent := filepath.Join(suite.dir, "recent.cast")
+	suite.NoError(os.WriteFile(recent, bytes.Repeat([]byte("a"), 512*1024), 0600))
+	recorder := suite.newRecorder(&config.RecordingConfig{MaxTotalSizeMB: 1})
+
+	// test
+	recording, err := recorder.Record(testSession("websocket"))
+	suite.Require().NoError(err)
+	suite.NoError(recording.Close())
+
+	// verify
+	suite.NoFileExists(old)
+	suite.FileExists(recent)
+}
+
+func (suite *RecordingSuite) TestFileName() {
+	// test
+	name := fileName(testSession("websocket"))
+
+	// verify
+	suite.Equal("20231018T093000Z_arn_aws_iam__123456789012_role_coder_default_web_"+testRequestID, name)
+}
+
+func (suite *RecordingSuite) newRecorder(recordingConfig *config.RecordingConfig) Recorder {
+	recordingConfig.Dir = suite.dir
+	recorder, err := NewRecorder(recordingConfig)
+	suite.Require().NoError(err)
+	suite.True(recorder.Enabled())
+	return recorder
+}
+
+func (suite *RecordingSuite) onlyFile() string {
+	entries, err := os.ReadDir(suite.dir)
+	suite.Require().NoError(err)
+	suite.Require().Len(entries, 1)
+	return filepath.Join(suite.dir, entries[0].Name())
+}
+
+// readRecording returns the header and the type and data of the events of an asciicast file.
+func (suite *RecordingSuite) readRecording(path string) (*castHeader, [][2]string) {
+	file, err := os.Open(path)
+	suite.Require().NoError(err)
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	suite.Require().True(scanner.Scan())
+	header := &castHeader{}
+	suite.Require().NoError(json.Unmarshal(scanner.Bytes(), header))
+	var events [][2]string
+	for scanner.Scan() {
+		var event []interface{}
+		suite.Require().NoError(json.Unmarshal(scanner.Bytes(), &event))
+		suite.Require().Len(event, 3)
+		events = append(events, [2]string{event[1].(string), event[2].(string)})
+	}
+	return header, events
+}
+
+func testSession(protocol string) *Session {
+	return &Session{
+		RequestID:   testRequestID,
+		IAMIdentity: testIAMIdentity,
+		Type:        "exec",
+		Namespace:   "default",
+		Pod:         "web",
+		Command:     []string{"sh"},
+		TTY:         true,
+		Protocol:    protocol,
+		Start:       time.Date(2023, 10, 18, 9, 30, 0, 0, time.UTC),
+	}
+}
+
+// testSPDYClient opens streams like a kubernetes client, with header blocks sharing one zlib stream.
+type testSPDYClient struct {
+	compressed *bytes.Buffer
+	compressor *zlib.Writer
+}
+
+func newTestSPDYClient() *testSPDYClient {
+	compressed := &bytes.Buffer{}
+	compressor, err := zlib.NewWriterLevelDict(compressed, zlib.BestCompression, spdyHeaderDictionary)
+	if err != nil {
+		panic(err)
+	}
+	return &testSPDYClient{
+		compressed: compressed,
+		compressor: compressor,
+	}
+}
+
+func (c *testSPDYClient) synStream(streamID uint32, streamType string) []byte {
+	block := &bytes.Buffer{}
+	for _, value := range []interface{}{uint32(1), uint32(len(spdyStreamType)), []byte(spdyStreamType), uint32(len(streamType)), []byte(streamType)} {
+		_ = binary.Write(block, binary.BigEndian, value)
+	}
+	c.compressed.Reset()
+	_, _ = c.compressor.Write(block.Bytes())
+	_ = c.compressor.Flush()
+
+	payload := &bytes.Buffer{}
+	_ = binary.Write(payload, binary.BigEndian, streamID)
+	_ = binary.Write(payload, binary.BigEndian, uint32(0))
+	payload.Write([]byte{0, 0})
+	payload.Write(c.compressed.Bytes())
+	return append(spdyFrameHeader(0x8000|spdyVersion, spdySynStream, payload.Len()), payload.Bytes()...)
+}
+
+func spdyDataFrame(streamID uint32, data []byte) []byte {
+	return append(spdyFrameHeader(uint16(streamID>>16), uint16(streamID), len(data)), data...)
+}
+
+func spdyFrameHeader(first, second uint16, length int) []byte {
+	header := make([]byte, spdyFrameHeaderLen)
+	binary.BigEndian.PutUint16(header[0:2], first)
+	binary.BigEndian.PutUint16(header[2:4], second)
+	header[5], header[6], header[7] = byte(length>>16), byte(length>>8), byte(length)
+	return header
+}
+
+// newWebsocketFrame returns a final frame, masked like the frames sent by clients.
+func newWebsocketFrame(opcode byte, masked bool, payload []byte) []byte {
+	frame := []byte{websocketFinal | opcode}
+	maskBit := byte(0)
+	if masked {
+		maskBit = websocketMasked
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	default:
+		frame = append(frame, maskBit|126, byte(len(payload)>>8), byte(len(payload)))
+	}
+	if !masked {
+		return append(frame, payload...)
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
@@ -0,0 +1,243 @@
+package recording
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// SPDY/3 frame types, see https://www.chromium.org/spdy/spdy-protocol/spdy-protocol-draft3-1/.
+const (
+	spdyVersion        = 3
+	spdyControl        = 0x80
+	spdyFrameHeaderLen = 8
+	spdySynStream      = 1
+	spdySynReply       = 2
+	spdyHeaders        = 8
+
+	// spdyStreamType is the header of SYN_STREAM frames that kubernetes sets to the channel of the stream.
+	spdyStreamType = "streamtype"
+	// maxSPDYHeadersSize bounds the compressed headers of a session, which only opens a few streams.
+	maxSPDYHeadersSize = 64 * 1024
+)
+
+// spdyStreamChannels maps the values of the streamtype header to channels.
+var spdyStreamChannels = map[string]byte{
+	"stdin":  channelStdin,
+	"stdout": channelStdout,
+	"stderr": channelStderr,
+	"error":  channelError,
+	"resize": channelResize,
+}
+
+// spdyHeaderDictionary primes the zlib compression of SPDY/3 header blocks.
+var spdyHeaderDictionary = []byte("" +
+	"\x00\x00\x00\x07options\x00\x00\x00\x04head\x00\x00\x00\x04post\x00\x00\x00\x03put" +
+	"\x00\x00\x00\x06delete\x00\x00\x00\x05trace\x00\x00\x00\x06accept" +
+	"\x00\x00\x00\x0eaccept-charset\x00\x00\x00\x0faccept-encoding" +
+	"\x00\x00\x00\x0faccept-language\x00\x00\x00\x0daccept-ranges\x00\x00\x00\x03age" +
+	"\x00\x00\x00\x05allow\x00\x00\x00\x0dauthorization\x00\x00\x00\x0dcache-control" +
+	"\x00\x00\x00\x0aconnection\x00\x00\x00\x0ccontent-base\x00\x00\x00\x10content-encoding" +
+	"\x00\x00\x00\x10content-language\x00\x00\x00\x0econtent-length" +
+	"\x00\x00\x00\x10content-location\x00\x00\x00\x0bcontent-md5\x00\x00\x00\x0dcontent-range" +
+	"\x00\x00\x00\x0ccontent-type\x00\x00\x00\x04date\x00\x00\x00\x04etag\x00\x00\x00\x06expect" +
+	"\x00\x00\x00\x07expires\x00\x00\x00\x04from\x00\x00\x00\x04host\x00\x00\x00\x08if-match" +
+	"\x00\x00\x00\x11if-modified-since\x00\x00\x00\x0dif-none-match\x00\x00\x00\x08if-range" +
+	"\x00\x00\x00\x13if-unmodified-since\x00\x00\x00\x0dlast-modified\x00\x00\x00\x08location" +
+	"\x00\x00\x00\x0cmax-forwards\x00\x00\x00\x06pragma\x00\x00\x00\x12proxy-authenticate" +
+	"\x00\x00\x00\x13proxy-authorization\x00\x00\x00\x05range\x00\x00\x00\x07referer" +
+	"\x00\x00\x00\x0bretry-after\x00\x00\x00\x06server\x00\x00\x00\x02te\x00\x00\x00\x07trailer" +
+	"\x00\x00\x00\x11transfer-encoding\x00\x00\x00\x07upgrade\x00\x00\x00\x0auser-agent" +
+	"\x00\x00\x00\x04vary\x00\x00\x00\x03via\x00\x00\x00\x07warning" +
+	"\x00\x00\x00\x10www-authenticate\x00\x00\x00\x06method\x00\x00\x00\x03get" +
+	"\x00\x00\x00\x06status\x00\x00\x00\x06200 OK\x00\x00\x00\x07version" +
+	"\x00\x00\x00\x08HTTP/1.1\x00\x00\x00\x03url\x00\x00\x00\x06public" +
+	"\x00\x00\x00\x0aset-cookie\x00\x00\x00\x0akeep-alive\x00\x00\x00\x06origin" +
+	"10010120120220520630030230330430530630740240540640740840941041141241341441541641" +
+	"7502504505203 Non-Authoritative Information204 No Content301 Moved Permanently40" +
+	"0 Bad Request401 Unauthorized403 Forbidden404 Not Found500 Internal Server Error" +
+	"501 Not Implemented503 Service UnavailableJan Feb Mar Apr May Jun Jul Aug Sept O" +
+	"ct Nov Dec 00:00:00 Mon, Tue, Wed, Thu, Fri, Sat, Sun, GMTchunked,text/html,imag" +
+	"e/png,image/jpg,image/gif,application/xml,application/xhtml+xml,text/plain,text/" +
+	"javascript,publicprivatemax-age=gzip,deflate,sdchcharset=utf-8charset=iso-8859-1" +
+	",utf-,*,enq=0.")
+
+// spdyStreams maps the streams of a session to their channel, as the client announces them.
+type spdyStreams struct {
+	lock     sync.Mutex
+	channels map[uint32]byte
+}
+
+func newSPDYStreams() *spdyStreams {
+	return &spdyStreams{
+		channels: map[uint32]byte{},
+	}
+}
+
+func (s *spdyStreams) set(streamID uint32, channel byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.channels[streamID] = channel
+}
+
+func (s *spdyStreams) channel(streamID uint32) (byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	channel, found := s.channels[streamID]
+	return channel, found
+}
+
+// spdyDecoder decodes the kubernetes SPDY stream protocols,
+// where the client opens one stream per channel, named by the streamtype header.
+type spdyDecoder struct {
+	write   channelWriter
+	streams *spdyStreams
+
+	buffer []byte
+	// headers are the compressed header blocks decoded so far. They form a single zlib stream,
+	// which is decompressed again from the start for each new block: sessions only have a few of them,
+	// and a zlib reader cannot be resumed once it has run out of input.
+	headers []byte
+	// headersSize is the decompressed size of headers.
+	headersSize int64
+}
+
+func (d *spdyDecoder) decode(data []byte) error {
+	d.buffer = append(d.buffer, data...)
+	consumed := 0
+	for {
+		size, err := d.frame(d.buffer[consumed:])
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			break
+		}
+		consumed += size
+	}
+	d.buffer = append(d.buffer[:0], d.buffer[consumed:]...)
+	return nil
+}
+
+// frame decodes the frame at the start of data, and returns its size,
+// or zero if data does not hold a complete frame yet.
+func (d *spdyDecoder) frame(data []byte) (int, error) {
+	if len(data) < spdyFrameHeaderLen {
+		return 0, nil
+	}
+	length := int(data[5])<<16 | int(data[6])<<8 | int(data[7])
+	if length > maxFrameSize {
+		return 0, fmt.Errorf("spdy frame of %d bytes exceeds %d bytes", length, maxFrameSize)
+	}
+	size := spdyFrameHeaderLen + length
+	if len(data) < size {
+		return 0, nil
+	}
+	payload := data[spdyFrameHeaderLen:size]
+
+	if data[0]&spdyControl == 0 {
+		streamID := binary.BigEndian.Uint32(data[0:4])
+		if channel, found := d.streams.channel(streamID); found && len(payload) > 0 {
+			return size, d.write(channel, payload)
+		}
+		return size, nil
+	}
+
+	if version := binary.BigEndian.Uint16(data[0:2]) &^ (spdyControl << 8); version != spdyVersion {
+		return 0, fmt.Errorf("unsupported spdy version %d", version)
+	}
+	switch binary.BigEndian.Uint16(data[2:4]) {
+	case spdySynStream:
+		// stream id, associated stream id, priority and slot precede the header block.
+		if length < 10 {
+			return 0, fmt.Errorf("invalid spdy SYN_STREAM frame of %d bytes", length)
+		}
+		header, err := d.decodeHeaders(payload[10:])
+		if err != nil {
+			return 0, err
+		}
+		if channel, found := spdyStreamChannels[header.Get(spdyStreamType)]; found {
+			d.streams.set(binary.BigEndian.Uint32(payload[0:4]), channel)
+		}
+	case spdySynReply, spdyHeaders:
+		// the header block is decoded to keep track of the zlib stream.
+		if length < 4 {
+			return 0, fmt.Errorf("invalid spdy header frame of %d bytes", length)
+		}
+		if _, err := d.decodeHeaders(payload[4:]); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// decodeHeaders decompresses the next header block of the connection.
+func (d *spdyDecoder) decodeHeaders(block []byte) (http.Header, error) {
+	d.headers = append(d.headers, block...)
+	if len(d.headers) > maxSPDYHeadersSize {
+		return nil, fmt.Errorf("spdy headers exceed %d bytes", maxSPDYHeadersSize)
+	}
+	reader, err := zlib.NewReaderDict(bytes.NewReader(d.headers), spdyHeaderDictionary)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spdy header block: %w", err)
+	}
+	if _, err = io.CopyN(io.Discard, reader, d.headersSize); err != nil {
+		return nil, fmt.Errorf("invalid spdy header block: %w", err)
+	}
+	header, size, err := readSPDYHeaderBlock(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spdy header block: %w", err)
+	}
+	d.headersSize += size
+	return header, nil
+}
+
+// readSPDYHeaderBlock reads a decompressed header block: the number of headers,
+// then the length and bytes of each name and value. Values may hold several NUL separated values.
+func readSPDYHeaderBlock(reader io.Reader) (http.Header, int64, error) {
+	var size int64
+	readUint32 := func() (uint32, error) {
+		var value uint32
+		err := binary.Read(reader, binary.BigEndian, &value)
+		size += 4
+		return value, err
+	}
+	readString := func() (string, error) {
+		length, err := readUint32()
+		if err != nil {
+			return "", err
+		}
+		if length > maxSPDYHeadersSize {
+			return "", fmt.Errorf("header of %d bytes", length)
+		}
+		data := make([]byte, length)
+		_, err = io.ReadFull(reader, data)
+		size += int64(length)
+		return string(data), err
+	}
+
+	count, err := readUint32()
+	if err != nil {
+		return nil, 0, err
+	}
+	header := http.Header{}
+	for i := uint32(0); i < count; i++ {
+		name, err := readString()
+		if err != nil {
+			return nil, 0, err
+		}
+		value, err := readString()
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, v := range strings.Split(value, "\x00") {
+			header.Add(name, v)
+		}
+	}
+	return header, size, nil
+}
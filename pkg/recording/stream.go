@@ -0,0 +1,26 @@
+package recording
+
+// Channels of the kubernetes remote command protocols, numbered like in the websocket protocols.
+const (
+	channelStdin byte = iota
+	channelStdout
+	channelStderr
+	channelError
+	channelResize
+)
+
+const (
+	// maxFrameSize bounds the frames of the stream protocols, far above what kubelet and clients send.
+	maxFrameSize = 1024 * 1024
+	// maxResizeSize bounds an incomplete terminal size.
+	maxResizeSize = 1024
+)
+
+// channelWriter receives the data of a channel. It must not retain data.
+type channelWriter func(channel byte, data []byte) error
+
+// decoder decodes one direction of a session connection into channel data.
+type decoder interface {
+	// decode decodes data, which is the next bytes of the connection, and buffers any incomplete frame.
+	decode(data []byte) error
+}
@@ -0,0 +1,151 @@
+package recording
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// WebSocket opcodes, see RFC 6455 section 5.2.
+const (
+	websocketContinuation = 0x0
+	websocketText         = 0x1
+	websocketBinary       = 0x2
+	websocketClose        = 0x8
+
+	websocketFinal      = 0x80
+	websocketCompressed = 0x40
+	websocketOpcodeMask = 0x0f
+	websocketMasked     = 0x80
+	websocketLengthMask = 0x7f
+)
+
+var errWebsocketCompressed = errors.New("compressed websocket frames cannot be recorded")
+
+// websocketDecoder decodes the kubernetes websocket stream protocols,
+// where each message starts with its channel: a byte in binary messages of channel.k8s.io and v4/v5.channel.k8s.io,
+// or an ascii digit followed by base64 data in text messages of base64.channel.k8s.io and v4.base64.channel.k8s.io.
+type websocketDecoder struct {
+	write channelWriter
+
+	buffer []byte
+	// message is the payload of the current, possibly fragmented, message.
+	message []byte
+	opcode  byte
+}
+
+type websocketFrame struct {
+	final      bool
+	compressed bool
+	opcode     byte
+	payload    []byte
+}
+
+func (d *websocketDecoder) decode(data []byte) error {
+	d.buffer = append(d.buffer, data...)
+	consumed := 0
+	for {
+		frame, size, err := parseWebsocketFrame(d.buffer[consumed:])
+		if err != nil {
+			return err
+		}
+		if frame == nil {
+			break
+		}
+		consumed += size
+		if err = d.frame(frame); err != nil {
+			return err
+		}
+	}
+	d.buffer = append(d.buffer[:0], d.buffer[consumed:]...)
+	return nil
+}
+
+func (d *websocketDecoder) frame(frame *websocketFrame) error {
+	if frame.opcode >= websocketClose {
+		// control frames: close, ping and pong.
+		return nil
+	}
+	if frame.compressed {
+		return errWebsocketCompressed
+	}
+	if frame.opcode == websocketContinuation {
+		d.message = append(d.message, frame.payload...)
+	} else {
+		d.opcode = frame.opcode
+		d.message = append(d.message[:0], frame.payload...)
+	}
+	if len(d.message) > maxFrameSize {
+		return fmt.Errorf("websocket message exceeds %d bytes", maxFrameSize)
+	}
+	if !frame.final || len(d.message) == 0 {
+		return nil
+	}
+
+	switch d.opcode {
+	case websocketBinary:
+		return d.write(d.message[0], d.message[1:])
+	case websocketText:
+		data, err := base64.StdEncoding.DecodeString(string(d.message[1:]))
+		if err != nil {
+			return fmt.Errorf("invalid base64 websocket message: %w", err)
+		}
+		return d.write(d.message[0]-'0', data)
+	default:
+		return fmt.Errorf("unknown websocket opcode %d", d.opcode)
+	}
+}
+
+// parseWebsocketFrame parses the frame at the start of data, and returns it with its size.
+// It returns a nil frame if data does not hold a complete frame yet.
+func parseWebsocketFrame(data []byte) (*websocketFrame, int, error) {
+	if len(data) < 2 {
+		return nil, 0, nil
+	}
+	headerSize := 2
+	length := uint64(data[1] & websocketLengthMask)
+	switch length {
+	case 126:
+		headerSize += 2
+		if len(data) < headerSize {
+			return nil, 0, nil
+		}
+		length = uint64(binary.BigEndian.Uint16(data[2:4]))
+	case 127:
+		headerSize += 8
+		if len(data) < headerSize {
+			return nil, 0, nil
+		}
+		length = binary.BigEndian.Uint64(data[2:10])
+	}
+	var mask []byte
+	if data[1]&websocketMasked != 0 {
+		if len(data) < headerSize+4 {
+			return nil, 0, nil
+		}
+		mask = data[headerSize : headerSize+4]
+		headerSize += 4
+	}
+	if length > maxFrameSize {
+		return nil, 0, fmt.Errorf("websocket frame of %d bytes exceeds %d bytes", length, maxFrameSize)
+	}
+	size := headerSize + int(length)
+	if len(data) < size {
+		return nil, 0, nil
+	}
+
+	payload := make([]byte, length)
+	copy(payload, data[headerSize:size])
+	for i := range mask {
+		for j := i; j < len(payload); j += len(mask) {
+			payload[j] ^= mask[i]
+		}
+	}
+	return &websocketFrame{
+		final:      data[0]&websocketFinal != 0,
+		compressed: data[0]&websocketCompressed != 0,
+		opcode:     data[0] & websocketOpcodeMask,
+		payload:    payload,
+	}, size, nil
+}
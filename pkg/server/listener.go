@@ -11,8 +11,14 @@ func NewListener(proxyConfig *config.ProxyConfig) (net.Listener, error) {
 	switch proxyConfig.SocketType {
 	case config.TCP:
 		return NewTcpListener(proxyConfig.SocketAddress)
+	case config.TLS:
+		return NewTLSListener(proxyConfig.SocketAddress, &proxyConfig.TLS)
 	case config.Unix:
-		return NewUnixListener(proxyConfig.SocketAddress)
+		return NewUnixListener(proxyConfig.SocketAddress, &proxyConfig.UnixSocket)
+	case config.Systemd:
+		return NewSystemdListener(proxyConfig.SocketAddress, &proxyConfig.UnixSocket)
+	case config.FD:
+		return NewFDListener(proxyConfig.SocketAddress, &proxyConfig.UnixSocket)
 	default:
 		return nil, errors.New("unrecognized socket type: " + string(proxyConfig.SocketType))
 	}
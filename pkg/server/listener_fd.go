@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+// Environment of systemd socket activation, see sd_listen_fds(3).
+const (
+	envListenPID     = "LISTEN_PID"
+	envListenFDs     = "LISTEN_FDS"
+	envListenFDNames = "LISTEN_FDNAMES"
+	// listenFDsStart is the first socket passed by systemd, after stdin, stdout and stderr.
+	listenFDsStart = 3
+)
+
+// NewSystemdListener adopts the listening socket named name passed by systemd socket activation,
+// i.e. by its FileDescriptorName, or the first passed socket if name is empty.
+// Inherited unix sockets keep the permissions set by the service manager, which also removes them,
+// and are only restricted by the peer allow lists of unixSocketConfig.
+func NewSystemdListener(name string, unixSocketConfig *config.UnixSocketConfig) (net.Listener, error) {
+	fd, err := systemdFD(name)
+	if err != nil {
+		return nil, err
+	}
+	return adoptListener(fd, unixSocketConfig)
+}
+
+// NewFDListener adopts the listening socket inherited as the file descriptor number addr,
+// like NewSystemdListener for socket activation by other service managers.
+func NewFDListener(addr string, unixSocketConfig *config.UnixSocketConfig) (net.Listener, error) {
+	fd, err := strconv.Atoi(addr)
+	if err != nil || fd < listenFDsStart {
+		return nil, fmt.Errorf("invalid inherited file descriptor %q, expected a number from %d", addr, listenFDsStart)
+	}
+	return adoptListener(fd, unixSocketConfig)
+}
+
+// systemdFD returns the file descriptor of the socket named name passed by systemd, the first one if name is empty.
+func systemdFD(name string) (int, error) {
+	if pid, err := strconv.Atoi(os.Getenv(envListenPID)); err != nil || pid != os.Getpid() {
+		return 0, fmt.Errorf("no socket was passed by systemd socket activation: %s is not the pid of eks connector", envListenPID)
+	}
+	count, err := strconv.Atoi(os.Getenv(envListenFDs))
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("no socket was passed by systemd socket activation: %s is not a positive number", envListenFDs)
+	}
+	if name == "" {
+		return listenFDsStart, nil
+	}
+	names := strings.Split(os.Getenv(envListenFDNames), ":")
+	for i := 0; i < count && i < len(names); i++ {
+		if names[i] == name {
+			return listenFDsStart + i, nil
+		}
+	}
+	return 0, fmt.Errorf("systemd passed no socket named %q, only %q", name, names)
+}
+
+// unsetListenEnv clears the environment of systemd socket activation once the listeners are adopted,
+// so that child processes do not inherit it, like sd_listen_fds(3) does when unset_environment is set.
+func unsetListenEnv() {
+	for _, name := range []string{envListenPID, envListenFDs, envListenFDNames} {
+		_ = os.Unsetenv(name)
+	}
+}
+
+func adoptListener(fd int, unixSocketConfig *config.UnixSocketConfig) (net.Listener, error) {
+	peers, err := newPeerAllowlist(unixSocketConfig)
+	if err != nil {
+		return nil, err
+	}
+	file := os.NewFile(uintptr(fd), "listener-"+strconv.Itoa(fd))
+	if file == nil {
+		return nil, fmt.Errorf("invalid inherited file descriptor %d", fd)
+	}
+	// FileListener duplicates the file descriptor, the inherited one is closed and its number may be reused.
+	l, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("inherited file descriptor %d is not a listening socket: %w", fd, err)
+	}
+	_ = file.Close()
+
+	if _, ok := l.(*net.UnixListener); ok {
+		return &unixListener{Listener: l, peers: peers}, nil
+	}
+	if peers != nil {
+		_ = l.Close()
+		return nil, errors.New("peer allow lists only apply to unix sockets")
+	}
+	return l, nil
+}
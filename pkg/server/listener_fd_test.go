@@ -0,0 +1,226 @@
+//go:build linux
+
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+// Environment of the server spawned by FDListenerSuite.
+const (
+	envHelperSocketType = "EKS_CONNECTOR_TEST_SOCKET_TYPE"
+	envHelperSocketAddr = "EKS_CONNECTOR_TEST_SOCKET_ADDR"
+)
+
+// pathListenEnv is served by the spawned server with its systemd socket activation environment.
+const pathListenEnv = "/listen-env"
+
+// TestFDListenerHelperProcess is the server spawned by FDListenerSuite with inherited sockets.
+func TestFDListenerHelperProcess(t *testing.T) {
+	socketType := os.Getenv(envHelperSocketType)
+	if socketType == "" {
+		t.Skip("only runs as a process spawned by FDListenerSuite")
+	}
+	if os.Getenv(envListenFDs) != "" {
+		// systemd sets the pid of the activated process after forking it.
+		_ = os.Setenv(envListenPID, strconv.Itoa(os.Getpid()))
+	}
+	server := &Server{
+		ProxyConfig: &config.ProxyConfig{
+			SocketType:    config.SocketType(socketType),
+			SocketAddress: os.Getenv(envHelperSocketAddr),
+		},
+		ProxyHandler: http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == pathListenEnv {
+				_, _ = io.WriteString(res, os.Getenv(envListenPID)+os.Getenv(envListenFDs)+os.Getenv(envListenFDNames))
+				return
+			}
+			ok(res, req)
+		}),
+	}
+	server.Run()
+}
+
+func TestFDListenerSuite(t *testing.T) {
+	suite.Run(t, new(FDListenerSuite))
+}
+
+type FDListenerSuite struct {
+	suite.Suite
+
+	dirName string
+}
+
+func (suite *FDListenerSuite) SetupTest() {
+	suite.dirName = suite.T().TempDir()
+}
+
+func (suite *FDListenerSuite) TestSystemdSocketActivation() {
+	// prepare
+	other := suite.listen("other.sock")
+	connector := suite.listen("connector.sock")
+
+	// test
+	suite.spawn(config.Systemd, "connector", []string{
+		envListenFDs + "=2",
+		envListenFDNames + "=other:connector",
+	}, other, connector)
+
+	// verify
+	body, err := suite.get("connector.sock")
+	suite.NoError(err)
+	suite.Equal(testResponseBodyOK, body)
+}
+
+func (suite *FDListenerSuite) TestSystemdSocketActivationUnsetsEnvironment() {
+	// prepare
+	connector := suite.listen("connector.sock")
+
+	// test
+	suite.spawn(config.Systemd, "connector", []string{
+		envListenFDs + "=1",
+		envListenFDNames + "=connector",
+	}, connector)
+
+	// verify
+	env, err := suite.getPath("connector.sock", pathListenEnv)
+	suite.NoError(err)
+	suite.Empty(env, "child processes do not inherit the sockets of systemd socket activation")
+}
+
+func (suite *FDListenerSuite) TestInheritedFD() {
+	// prepare
+	connector := suite.listen("connector.sock")
+
+	// test
+	suite.spawn(config.FD, "3", nil, connector)
+
+	// verify
+	body, err := suite.get("connector.sock")
+	suite.NoError(err)
+	suite.Equal(testResponseBodyOK, body)
+}
+
+func (suite *FDListenerSuite) TestInheritedSocketIsKept() {
+	// prepare
+	listener, err := NewFDListener(suite.dup(suite.listen("connector.sock")), &config.UnixSocketConfig{})
+	suite.Require().NoError(err)
+
+	// test
+	err = listener.Close()
+
+	// verify
+	suite.NoError(err)
+	_, err = os.Stat(filepath.Join(suite.dirName, "connector.sock"))
+	suite.NoError(err, "the socket of the service manager is not removed")
+}
+
+func (suite *FDListenerSuite) TestInvalidFD() {
+	for _, addr := range []string{"", "stdin", "0", "1000"} {
+		// test
+		_, err := NewFDListener(addr, &config.UnixSocketConfig{})
+
+		// verify
+		suite.Error(err, addr)
+	}
+}
+
+func (suite *FDListenerSuite) TestSystemdWithoutSockets() {
+	for _, env := range []map[string]string{
+		{envListenPID: "", envListenFDs: "1"},
+		{envListenPID: strconv.Itoa(os.Getpid() + 1), envListenFDs: "1"},
+		{envListenPID: strconv.Itoa(os.Getpid()), envListenFDs: "0"},
+		{envListenPID: strconv.Itoa(os.Getpid()), envListenFDs: "1", envListenFDNames: "other"},
+	} {
+		// prepare
+		for key, value := range env {
+			suite.T().Setenv(key, value)
+		}
+
+		// test
+		_, err := NewSystemdListener("connector", &config.UnixSocketConfig{})
+
+		// verify
+		suite.Error(err, "%v", env)
+	}
+}
+
+// listen listens on the unix socket name, which is not removed once the listener is closed.
+func (suite *FDListenerSuite) listen(name string) *net.UnixListener {
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(suite.dirName, name), Net: "unix"})
+	suite.Require().NoError(err)
+	listener.SetUnlinkOnClose(false)
+	suite.T().Cleanup(func() { _ = listener.Close() })
+	return listener
+}
+
+// dup returns a new file descriptor of listener, which is not closed by a finalizer once it is adopted.
+func (suite *FDListenerSuite) dup(listener *net.UnixListener) string {
+	rawConn, err := listener.SyscallConn()
+	suite.Require().NoError(err)
+	var fd int
+	var dupErr error
+	suite.Require().NoError(rawConn.Control(func(listenerFD uintptr) {
+		fd, dupErr = syscall.Dup(int(listenerFD))
+	}))
+	suite.Require().NoError(dupErr)
+	return strconv.Itoa(fd)
+}
+
+// spawn runs the server in a new process inheriting listeners from file descriptor 3.
+func (suite *FDListenerSuite) spawn(socketType config.SocketType, socketAddr string, env []string, listeners ...*net.UnixListener) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFDListenerHelperProcess$")
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env, envHelperSocketType+"="+string(socketType), envHelperSocketAddr+"="+socketAddr)
+	for _, listener := range listeners {
+		file, err := listener.File()
+		suite.Require().NoError(err)
+		defer file.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, file)
+	}
+	suite.Require().NoError(cmd.Start())
+	suite.T().Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	// only the spawned server accepts connections from now on.
+	for _, listener := range listeners {
+		_ = listener.Close()
+	}
+}
+
+func (suite *FDListenerSuite) get(name string) (string, error) {
+	return suite.getPath(name, "/")
+}
+
+func (suite *FDListenerSuite) getPath(name, path string) (string, error) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return net.Dial("unix", filepath.Join(suite.dirName, name))
+			},
+		},
+	}
+	res, err := client.Get("http://foo.bar" + path)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return string(body), err
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+	"github.com/aws/amazon-eks-connector/pkg/proxy"
+)
+
+// Values of the identity option of a listener.
+const (
+	ListenerIdentityVerified = "verified"
+	ListenerIdentityTrusted  = "trusted"
+)
+
+// Listener is a listener of the proxy server and the handler options of the requests it receives.
+type Listener struct {
+	SocketType    config.SocketType
+	SocketAddress string
+	Options       proxy.ListenerOptions
+}
+
+// ParseListener parses a listener of proxy.listeners, written <socket type>://<address>[?<options>], e.g.
+//
+//	unix:///var/eks/shared/debug.sock
+//	tcp://127.0.0.1:8080?mode=readonly&identity=trusted
+//	tls://0.0.0.0:8443
+//	systemd://eks-connector-debug.socket
+//	fd://4
+//
+// mode overrides the proxy mode, and identity=trusted skips the verification of identity signatures.
+// unix and tls listeners share the socket permissions and certificates of the proxy,
+// systemd and fd listeners adopt inherited sockets like the socket types of the same name.
+func ParseListener(spec string) (*Listener, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listener %q: %w", spec, err)
+	}
+	listener := &Listener{
+		SocketType: config.SocketType(u.Scheme),
+		Options: proxy.ListenerOptions{
+			Name: spec,
+		},
+	}
+	switch listener.SocketType {
+	case config.Unix:
+		listener.SocketAddress = u.Path
+	case config.TCP, config.TLS, config.Systemd, config.FD:
+		listener.SocketAddress = u.Host
+	default:
+		return nil, fmt.Errorf("invalid listener %q: unrecognized socket type %q", spec, u.Scheme)
+	}
+	if listener.SocketAddress == "" {
+		return nil, fmt.Errorf("invalid listener %q: missing address", spec)
+	}
+
+	for option, values := range u.Query() {
+		value := values[len(values)-1]
+		switch option {
+		case "mode":
+			if err = proxy.ValidateMode(value); err != nil {
+				return nil, fmt.Errorf("invalid listener %q: %w", spec, err)
+			}
+			listener.Options.Mode = value
+		case "identity":
+			switch value {
+			case ListenerIdentityVerified:
+			case ListenerIdentityTrusted:
+				listener.Options.TrustIdentity = true
+			default:
+				return nil, fmt.Errorf("invalid listener %q: unknown identity %q, expected %s or %s",
+					spec, value, ListenerIdentityVerified, ListenerIdentityTrusted)
+			}
+		default:
+			return nil, fmt.Errorf("invalid listener %q: unknown option %q", spec, option)
+		}
+	}
+	return listener, nil
+}
+
+// listeners returns the main listener of proxyConfig followed by the additional listeners of proxy.listeners.
+func listeners(proxyConfig *config.ProxyConfig) ([]*Listener, error) {
+	all := []*Listener{{
+		SocketType:    proxyConfig.SocketType,
+		SocketAddress: proxyConfig.SocketAddress,
+		Options: proxy.ListenerOptions{
+			Name: fmt.Sprintf("%s://%s", proxyConfig.SocketType, proxyConfig.SocketAddress),
+		},
+	}}
+	addresses := map[string]bool{all[0].Options.Name: true}
+	for _, spec := range proxyConfig.Listeners {
+		listener, err := ParseListener(spec)
+		if err != nil {
+			return nil, err
+		}
+		// e.g. an inherited file descriptor can only be adopted once.
+		address := fmt.Sprintf("%s://%s", listener.SocketType, listener.SocketAddress)
+		if addresses[address] {
+			return nil, fmt.Errorf("invalid listener %q: %s is already served", spec, address)
+		}
+		addresses[address] = true
+		all = append(all, listener)
+	}
+	return all, nil
+}
+
+// listen opens listener with the socket permissions and certificates of proxyConfig,
+// or adopts it if the previous process of an upgrade handed it off.
+func (l *Listener) listen(proxyConfig *config.ProxyConfig, inherited *inheritedListeners) (net.Listener, error) {
+	listenerConfig := *proxyConfig
+	listenerConfig.SocketType = l.SocketType
+	listenerConfig.SocketAddress = l.SocketAddress
+	if fd, ok := inherited.take(l.Options.Name); ok {
+		klog.Infof("adopting inherited listener %s", l.Options.Name)
+		return adoptInherited(fd, &listenerConfig)
+	}
+	return NewListener(&listenerConfig)
+}
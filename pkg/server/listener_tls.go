@@ -0,0 +1,190 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+	"github.com/aws/amazon-eks-connector/pkg/filewatch"
+	"github.com/aws/amazon-eks-connector/pkg/metrics"
+)
+
+// tlsVersions are the supported minimum TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var serverCertificateExpiry = metrics.NewGaugeVec(metrics.Opts{
+	Name: "eks_connector_proxy_server_certificate_expiry_timestamp_seconds",
+	Help: "Expiry of the certificate served by the proxy tls listener in unix time.",
+})
+
+// NewTLSListener listens on the tcp address addr and serves TLS with the certificate of serverTLSConfig,
+// which is reloaded whenever it changes on disk.
+// Clients must present a certificate signed by the client CA of serverTLSConfig, if set.
+func NewTLSListener(addr string, serverTLSConfig *config.ServerTLSConfig) (net.Listener, error) {
+	tlsConfig, err := newServerTLSConfig(serverTLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return newTLSListener(l, tlsConfig), nil
+}
+
+// tlsListener serves TLS on a tcp listener, whose socket can still be passed to another process.
+type tlsListener struct {
+	net.Listener
+	tcp net.Listener
+}
+
+func newTLSListener(tcp net.Listener, tlsConfig *tls.Config) net.Listener {
+	return &tlsListener{
+		Listener: tls.NewListener(tcp, tlsConfig),
+		tcp:      tcp,
+	}
+}
+
+// File returns a copy of the tcp socket, e.g. to pass it to another process.
+func (l *tlsListener) File() (*os.File, error) {
+	return listenerFile(l.tcp)
+}
+
+func newServerTLSConfig(serverTLSConfig *config.ServerTLSConfig) (*tls.Config, error) {
+	if serverTLSConfig.CertFile == "" || serverTLSConfig.KeyFile == "" {
+		return nil, errors.New("the tls socket type requires a certificate and key file")
+	}
+	minVersion := uint16(tls.VersionTLS12)
+	if serverTLSConfig.MinVersion != "" {
+		version, ok := tlsVersions[serverTLSConfig.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown minimum tls version %q, expected 1.2 or 1.3", serverTLSConfig.MinVersion)
+		}
+		minVersion = version
+	}
+	cipherSuites, err := parseCipherSuites(serverTLSConfig.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	reloader := &certificateReloader{
+		certFile:     serverTLSConfig.CertFile,
+		keyFile:      serverTLSConfig.KeyFile,
+		clientCAFile: serverTLSConfig.ClientCAFile,
+	}
+	if err = reloader.load(); err != nil {
+		return nil, err
+	}
+	if err = reloader.watch(); err != nil {
+		return nil, err
+	}
+
+	base := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+	}
+	if serverTLSConfig.ClientCAFile != "" {
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return &tls.Config{
+		MinVersion: minVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return reloader.config(base), nil
+		},
+	}, nil
+}
+
+// parseCipherSuites returns the IDs of the named TLS 1.2 cipher suites, nil for the go defaults if names is empty.
+// Only the cipher suites go considers secure are accepted.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	supported := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		supported[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := supported[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// certificateReloader keeps the server certificate and client CA bundle in sync with their files.
+type certificateReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	lock        sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+// config returns a copy of base with the current certificate and client CA bundle.
+func (r *certificateReloader) config(base *tls.Config) *tls.Config {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	clientConfig := base.Clone()
+	clientConfig.Certificates = []tls.Certificate{*r.certificate}
+	clientConfig.ClientCAs = r.clientCAs
+	return clientConfig
+}
+
+func (r *certificateReloader) load() error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		caCerts, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caCerts) {
+			return fmt.Errorf("%s contains no PEM certificate", r.clientCAFile)
+		}
+	}
+
+	r.lock.Lock()
+	r.certificate = &certificate
+	r.clientCAs = clientCAs
+	r.lock.Unlock()
+	serverCertificateExpiry.Set(float64(leaf.NotAfter.Unix()))
+	klog.V(2).Infof("loaded server certificate %s expiring at %s", leaf.Subject, leaf.NotAfter)
+	return nil
+}
+
+func (r *certificateReloader) watch() error {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return filewatch.WatchFiles("server certificate", files, func() {
+		if err := r.load(); err != nil {
+			// the certificate and key are often not written at once, the next event reloads both.
+			klog.Errorf("failed to reload server certificate, keeping the previous one: %v", err)
+		}
+	})
+}
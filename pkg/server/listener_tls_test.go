@@ -0,0 +1,204 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+func TestTLSListenerSuite(t *testing.T) {
+	suite.Run(t, new(TLSListenerSuite))
+}
+
+type TLSListenerSuite struct {
+	suite.Suite
+
+	dirName   string
+	caCert    *x509.Certificate
+	caKey     *ecdsa.PrivateKey
+	tlsConfig *config.ServerTLSConfig
+}
+
+func (suite *TLSListenerSuite) SetupTest() {
+	suite.dirName = suite.T().TempDir()
+	suite.caCert, suite.caKey = suite.newCertificate("eks-connector-test-ca", nil, nil)
+	suite.writePEM("ca.crt", "CERTIFICATE", suite.caCert.Raw)
+	suite.writeServerCertificate("eks-connector")
+	suite.tlsConfig = &config.ServerTLSConfig{
+		CertFile: filepath.Join(suite.dirName, "tls.crt"),
+		KeyFile:  filepath.Join(suite.dirName, "tls.key"),
+	}
+}
+
+func (suite *TLSListenerSuite) TestServerTraffic() {
+	// prepare
+	addr := suite.serve()
+
+	// test
+	res, err := suite.client(nil, 0).Get("https://" + addr)
+
+	// verify
+	suite.Require().NoError(err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	suite.NoError(err)
+	suite.Equal(testResponseBodyOK, string(body))
+	suite.Equal("eks-connector", res.TLS.PeerCertificates[0].Subject.CommonName)
+}
+
+func (suite *TLSListenerSuite) TestMutualTLS() {
+	// prepare
+	suite.tlsConfig.ClientCAFile = filepath.Join(suite.dirName, "ca.crt")
+	addr := suite.serve()
+	clientCert, clientKey := suite.newCertificate("ssm-agent", suite.caCert, suite.caKey)
+	clientCertificate := &tls.Certificate{
+		Certificate: [][]byte{clientCert.Raw},
+		PrivateKey:  clientKey,
+		Leaf:        clientCert,
+	}
+
+	// test
+	_, errAnonymous := suite.client(nil, 0).Get("https://" + addr)
+	res, err := suite.client(clientCertificate, 0).Get("https://" + addr)
+
+	// verify
+	suite.Error(errAnonymous, "clients without certificate are rejected")
+	suite.Require().NoError(err)
+	_ = res.Body.Close()
+	suite.Equal(http.StatusOK, res.StatusCode)
+}
+
+func (suite *TLSListenerSuite) TestMinVersion() {
+	// prepare
+	suite.tlsConfig.MinVersion = "1.3"
+	addr := suite.serve()
+
+	// test
+	_, err := suite.client(nil, tls.VersionTLS12).Get("https://" + addr)
+
+	// verify
+	suite.Error(err)
+}
+
+func (suite *TLSListenerSuite) TestReloadsCertificate() {
+	// prepare
+	addr := suite.serve()
+
+	// test
+	suite.writeServerCertificate("eks-connector-rotated")
+
+	// verify
+	suite.Eventually(func() bool {
+		// a new client for a new handshake.
+		res, err := suite.client(nil, 0).Get("https://" + addr)
+		if err != nil {
+			return false
+		}
+		_ = res.Body.Close()
+		return res.TLS.PeerCertificates[0].Subject.CommonName == "eks-connector-rotated"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (suite *TLSListenerSuite) TestInvalidConfig() {
+	for _, serverTLSConfig := range []*config.ServerTLSConfig{
+		{},
+		{CertFile: suite.tlsConfig.CertFile, KeyFile: filepath.Join(suite.dirName, "missing.key")},
+		{CertFile: suite.tlsConfig.CertFile, KeyFile: suite.tlsConfig.KeyFile, MinVersion: "1.1"},
+		{CertFile: suite.tlsConfig.CertFile, KeyFile: suite.tlsConfig.KeyFile, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{CertFile: suite.tlsConfig.CertFile, KeyFile: suite.tlsConfig.KeyFile, ClientCAFile: suite.tlsConfig.KeyFile},
+	} {
+		// test
+		_, err := NewTLSListener("127.0.0.1:0", serverTLSConfig)
+
+		// verify
+		suite.Error(err, "%+v", serverTLSConfig)
+	}
+}
+
+// serve serves ok on a tls listener with suite.tlsConfig, and returns its address.
+func (suite *TLSListenerSuite) serve() string {
+	listener, err := NewTLSListener("127.0.0.1:0", suite.tlsConfig)
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { _ = listener.Close() })
+	go func() {
+		_ = http.Serve(listener, http.HandlerFunc(ok))
+	}()
+	return listener.Addr().String()
+}
+
+func (suite *TLSListenerSuite) client(certificate *tls.Certificate, maxVersion uint16) *http.Client {
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(suite.caCert)
+	tlsConfig := &tls.Config{
+		RootCAs:    rootCAs,
+		MaxVersion: maxVersion,
+	}
+	if certificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*certificate}
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   tlsConfig,
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+func (suite *TLSListenerSuite) writeServerCertificate(commonName string) {
+	cert, key := suite.newCertificate(commonName, suite.caCert, suite.caKey)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	suite.Require().NoError(err)
+	// write the key first, so that the certificate event reloads a matching pair.
+	suite.writePEM("tls.key", "EC PRIVATE KEY", keyDER)
+	suite.writePEM("tls.crt", "CERTIFICATE", cert.Raw)
+}
+
+func (suite *TLSListenerSuite) writePEM(name, blockType string, der []byte) {
+	content := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	suite.Require().NoError(os.WriteFile(filepath.Join(suite.dirName, name), content, 0600))
+}
+
+// newCertificate returns a certificate for 127.0.0.1 signed by parent, or a self-signed CA if parent is nil.
+func (suite *TLSListenerSuite) newCertificate(commonName string, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Require().NoError(err)
+	serialNumber, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	suite.Require().NoError(err)
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	suite.Require().NoError(err)
+	cert, err := x509.ParseCertificate(der)
+	suite.Require().NoError(err)
+	return cert, key
+}
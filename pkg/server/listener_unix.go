@@ -1,15 +1,51 @@
 package server
 
 import (
+	"fmt"
 	"net"
 	"os"
+	"os/user"
+	"strconv"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
 )
 
+// defaultSocketMode only lets the user of eks connector connect to the socket.
+const defaultSocketMode = 0700
+
 // unixListener copied from
 // https://github.com/etcd-io/etcd/blob/main/client/pkg/transport/unix_listener.go
-type unixListener struct{ net.Listener }
+type unixListener struct {
+	net.Listener
+	// peers is nil if any process may connect.
+	peers *peerAllowlist
+	// removeSocket is 1 if the socket is removed on close, 0 for sockets of the service manager
+	// and sockets handed off to another process.
+	removeSocket int32
+}
+
+// NewUnixListener listens on the unix socket addr with the permissions of unixSocketConfig.
+func NewUnixListener(addr string, unixSocketConfig *config.UnixSocketConfig) (net.Listener, error) {
+	mode, err := parseSocketMode(unixSocketConfig.Mode)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := lookupID(unixSocketConfig.Owner, lookupUser)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket owner: %w", err)
+	}
+	gid, err := lookupID(unixSocketConfig.Group, lookupGroup)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket group: %w", err)
+	}
+	peers, err := newPeerAllowlist(unixSocketConfig)
+	if err != nil {
+		return nil, err
+	}
 
-func NewUnixListener(addr string) (net.Listener, error) {
 	if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
@@ -17,15 +53,97 @@ func NewUnixListener(addr string) (net.Listener, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err = os.Chmod(addr, 0700); err != nil {
+	if err = os.Chmod(addr, mode); err != nil {
+		_ = l.Close()
 		return nil, err
 	}
-	return &unixListener{l}, nil
+	if uid != -1 || gid != -1 {
+		if err = os.Chown(addr, uid, gid); err != nil {
+			_ = l.Close()
+			return nil, err
+		}
+	}
+	// the socket is removed by Close, unless it is handed off.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	return &unixListener{Listener: l, peers: peers, removeSocket: 1}, nil
+}
+
+// Accept returns the next connection of an allowed process, closing the connections of other processes.
+func (ul *unixListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ul.Listener.Accept()
+		if err != nil || ul.peers == nil {
+			return conn, err
+		}
+		if err = ul.peers.check(conn); err != nil {
+			rejectedConnectionsTotal.Inc()
+			klog.Warningf("rejected connection to %s: %v", ul.Addr(), err)
+			_ = conn.Close()
+			continue
+		}
+		return conn, nil
+	}
 }
 
 func (ul *unixListener) Close() error {
+	if atomic.LoadInt32(&ul.removeSocket) == 0 {
+		return ul.Listener.Close()
+	}
 	if err := os.Remove(ul.Addr().String()); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	return ul.Listener.Close()
 }
+
+// File returns a copy of the socket, e.g. to pass it to another process.
+func (ul *unixListener) File() (*os.File, error) {
+	return listenerFile(ul.Listener)
+}
+
+// keepSocket keeps the socket once the listener is closed, e.g. for the process it is handed off to.
+func (ul *unixListener) keepSocket() {
+	atomic.StoreInt32(&ul.removeSocket, 0)
+}
+
+// parseSocketMode parses an octal file mode, defaultSocketMode if mode is empty.
+func parseSocketMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return defaultSocketMode, nil
+	}
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || os.FileMode(parsed)&^os.ModePerm != 0 {
+		return 0, fmt.Errorf("invalid socket mode %q, expected octal permissions such as 0770", mode)
+	}
+	return os.FileMode(parsed), nil
+}
+
+// lookupID returns the numeric ID of a user or group given by name or ID, -1 if it is empty.
+func lookupID(nameOrID string, lookup func(name string) (string, error)) (int, error) {
+	if nameOrID == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(nameOrID); err == nil && id >= 0 {
+		return id, nil
+	}
+	id, err := lookup(nameOrID)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+func lookupUser(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+func lookupGroup(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net"
+	"os"
+)
+
+// envNotifySocket is the socket systemd receives the state notifications of a Type=notify service on,
+// see sd_notify(3).
+const envNotifySocket = "NOTIFY_SOCKET"
+
+// sdNotify sends state to systemd, if eks connector runs as a systemd service expecting notifications.
+// Abstract socket names starting with @ are supported by net.
+func sdNotify(state string) error {
+	socket := os.Getenv(envNotifySocket)
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+	"github.com/aws/amazon-eks-connector/pkg/metrics"
+)
+
+var rejectedConnectionsTotal = metrics.NewCounterVec(metrics.Opts{
+	Name: "eks_connector_proxy_rejected_connections_total",
+	Help: "Connections to the proxy unix socket rejected by the peer credential allow lists.",
+})
+
+// errPeerCredentialsUnsupported is returned where SO_PEERCRED is not available.
+var errPeerCredentialsUnsupported = errors.New("peer credentials of unix socket connections are only supported on linux")
+
+// peerCredentials identify the process on the other end of a unix socket connection.
+type peerCredentials struct {
+	UID uint32
+	GID uint32
+	// PID is 0 if the process is not visible in the pid namespace of eks connector.
+	PID int32
+}
+
+// peerAllowlist decides which processes may connect to the proxy unix socket.
+// A process must match every configured allow list.
+type peerAllowlist struct {
+	uids        map[uint32]bool
+	gids        map[uint32]bool
+	executables map[string]bool
+}
+
+// newPeerAllowlist returns the allow lists of unixSocketConfig, or nil if any process may connect.
+func newPeerAllowlist(unixSocketConfig *config.UnixSocketConfig) (*peerAllowlist, error) {
+	if len(unixSocketConfig.AllowedUIDs) == 0 && len(unixSocketConfig.AllowedGIDs) == 0 &&
+		len(unixSocketConfig.AllowedExecutables) == 0 {
+		return nil, nil
+	}
+	if !peerCredentialsSupported {
+		return nil, errPeerCredentialsUnsupported
+	}
+	allowlist := &peerAllowlist{
+		uids:        map[uint32]bool{},
+		gids:        map[uint32]bool{},
+		executables: map[string]bool{},
+	}
+	for _, uid := range unixSocketConfig.AllowedUIDs {
+		if uid < 0 {
+			return nil, fmt.Errorf("invalid allowed uid %d", uid)
+		}
+		allowlist.uids[uint32(uid)] = true
+	}
+	for _, gid := range unixSocketConfig.AllowedGIDs {
+		if gid < 0 {
+			return nil, fmt.Errorf("invalid allowed gid %d", gid)
+		}
+		allowlist.gids[uint32(gid)] = true
+	}
+	for _, executable := range unixSocketConfig.AllowedExecutables {
+		allowlist.executables[executable] = true
+	}
+	return allowlist, nil
+}
+
+// check returns an error if the process on the other end of conn is not allowed.
+func (a *peerAllowlist) check(conn net.Conn) error {
+	credentials, err := readPeerCredentials(conn)
+	if err != nil {
+		return fmt.Errorf("cannot read peer credentials: %w", err)
+	}
+	if len(a.uids) > 0 && !a.uids[credentials.UID] {
+		return fmt.Errorf("uid %d of pid %d is not allowed", credentials.UID, credentials.PID)
+	}
+	if len(a.gids) > 0 && !a.gids[credentials.GID] {
+		return fmt.Errorf("gid %d of pid %d is not allowed", credentials.GID, credentials.PID)
+	}
+	if len(a.executables) > 0 {
+		// the executable can only be read if the peer process is visible, e.g. containers sharing the pod pid namespace.
+		executable, err := peerExecutable(credentials.PID)
+		if err != nil {
+			return fmt.Errorf("cannot read executable of pid %d: %w", credentials.PID, err)
+		}
+		if !a.executables[executable] {
+			return fmt.Errorf("executable %s of pid %d is not allowed", executable, credentials.PID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const peerCredentialsSupported = true
+
+// readPeerCredentials reads SO_PEERCRED of a unix socket connection,
+// i.e. the credentials of the peer process when it connected.
+func readPeerCredentials(conn net.Conn) (*peerCredentials, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a unix socket connection", conn)
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var ucred *unix.Ucred
+	var ucredErr error
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, ucredErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ucredErr != nil {
+		return nil, ucredErr
+	}
+	return &peerCredentials{
+		UID: ucred.Uid,
+		GID: ucred.Gid,
+		PID: ucred.Pid,
+	}, nil
+}
+
+// peerExecutable returns the executable path of pid.
+// Reading it requires the same uid as pid, or CAP_SYS_PTRACE.
+func peerExecutable(pid int32) (string, error) {
+	if pid <= 0 {
+		return "", errors.New("peer process is not visible in the pid namespace of eks connector")
+	}
+	return os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+}
@@ -0,0 +1,146 @@
+//go:build linux
+
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+func TestPeerCredentialsSuite(t *testing.T) {
+	suite.Run(t, new(PeerCredentialsSuite))
+}
+
+type PeerCredentialsSuite struct {
+	suite.Suite
+
+	socketAddr string
+}
+
+func (suite *PeerCredentialsSuite) SetupTest() {
+	dirName, err := os.MkdirTemp("", "eks_connector")
+	suite.Require().NoError(err)
+	suite.socketAddr = filepath.Join(dirName, "connector.sock")
+}
+
+func (suite *PeerCredentialsSuite) TearDownTest() {
+	_ = os.RemoveAll(filepath.Dir(suite.socketAddr))
+}
+
+func (suite *PeerCredentialsSuite) TestAllowsPeer() {
+	// prepare
+	executable, err := os.Executable()
+	suite.Require().NoError(err)
+	suite.serve(&config.UnixSocketConfig{
+		AllowedUIDs:        []int{os.Getuid()},
+		AllowedGIDs:        []int{os.Getgid()},
+		AllowedExecutables: []string{executable},
+	})
+
+	// test
+	body, err := suite.get()
+
+	// verify
+	suite.NoError(err)
+	suite.Equal(testResponseBodyOK, body)
+}
+
+func (suite *PeerCredentialsSuite) TestRejectsUID() {
+	// prepare
+	suite.serve(&config.UnixSocketConfig{
+		AllowedUIDs: []int{os.Getuid() + 1},
+	})
+
+	// test
+	_, err := suite.get()
+
+	// verify
+	suite.Error(err)
+}
+
+func (suite *PeerCredentialsSuite) TestRejectsExecutable() {
+	// prepare
+	suite.serve(&config.UnixSocketConfig{
+		AllowedUIDs:        []int{os.Getuid()},
+		AllowedExecutables: []string{"/usr/bin/amazon-ssm-agent"},
+	})
+
+	// test
+	_, err := suite.get()
+
+	// verify
+	suite.Error(err)
+}
+
+func (suite *PeerCredentialsSuite) TestSocketPermissions() {
+	// prepare
+	unixSocketConfig := &config.UnixSocketConfig{
+		Mode:  "0770",
+		Owner: strconv.Itoa(os.Getuid()),
+		Group: strconv.Itoa(os.Getgid()),
+	}
+
+	// test
+	listener, err := NewUnixListener(suite.socketAddr, unixSocketConfig)
+
+	// verify
+	suite.Require().NoError(err)
+	defer listener.Close()
+	stat, err := os.Stat(suite.socketAddr)
+	suite.Require().NoError(err)
+	suite.Equal(os.FileMode(0770), stat.Mode()&os.ModePerm)
+	suite.Equal(uint32(os.Getgid()), stat.Sys().(*syscall.Stat_t).Gid)
+}
+
+func (suite *PeerCredentialsSuite) TestInvalidConfig() {
+	for _, unixSocketConfig := range []*config.UnixSocketConfig{
+		{Mode: "rwx"},
+		{Mode: "01777"},
+		{Owner: "no-such-eks-connector-user"},
+		{Group: "no-such-eks-connector-group"},
+		{AllowedUIDs: []int{-1}},
+	} {
+		// test
+		_, err := NewUnixListener(suite.socketAddr, unixSocketConfig)
+
+		// verify
+		suite.Error(err, "%+v", unixSocketConfig)
+	}
+}
+
+func (suite *PeerCredentialsSuite) serve(unixSocketConfig *config.UnixSocketConfig) {
+	listener, err := NewUnixListener(suite.socketAddr, unixSocketConfig)
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { _ = listener.Close() })
+	go func() {
+		_ = http.Serve(listener, http.HandlerFunc(ok))
+	}()
+}
+
+func (suite *PeerCredentialsSuite) get() (string, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return net.Dial("unix", suite.socketAddr)
+			},
+		},
+	}
+	res, err := client.Get("http://foo.bar")
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return string(body), err
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package server
+
+import (
+	"net"
+)
+
+const peerCredentialsSupported = false
+
+func readPeerCredentials(conn net.Conn) (*peerCredentials, error) {
+	return nil, errPeerCredentialsUnsupported
+}
+
+func peerExecutable(pid int32) (string, error) {
+	return "", errPeerCredentialsUnsupported
+}
@@ -1,14 +1,19 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 
 	"k8s.io/klog/v2"
 
 	"github.com/aws/amazon-eks-connector/pkg/config"
+	"github.com/aws/amazon-eks-connector/pkg/proxy"
 )
 
 // Server for eks connector proxy
@@ -17,42 +22,151 @@ type Server struct {
 	ProxyHandler http.Handler
 
 	// private fields visible for testing
-	httpServer  *http.Server
-	listener    net.Listener
+	lock        sync.Mutex
+	httpServers []*http.Server
+	// listener is the main proxy listener, listeners are all of them including proxy.listeners.
+	listener      net.Listener
+	listeners     []net.Listener
+	listenerNames []string
+	// otherListeners are the listeners of ListenTCP.
+	otherListeners []namedListener
+	inheritOnce    sync.Once
+	inherited      *inheritedListeners
+	// command starts the new process of an upgrade, the eks connector executable if nil.
+	command     []string
 	serverReady chan bool
+	// listening is 1 while the proxy listeners are open.
+	listening int32
 }
 
+// drainer is implemented by proxy handlers that end their long-running requests on shutdown.
+type drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// Run serves the proxy on the main listener and the additional ones of proxy.listeners concurrently,
+// until Shutdown or Stop closes all of them.
 func (s *Server) Run() {
-	proxyListener, err := NewListener(s.ProxyConfig)
+	definitions, err := listeners(s.ProxyConfig)
 	if err != nil {
-		klog.Fatalf("could not start listener on %v: %v", s.ProxyConfig, err)
+		klog.Fatalf("invalid configuration: %v", err)
+	}
+
+	inherited := s.inheritedListeners()
+	netListeners := make([]net.Listener, 0, len(definitions))
+	listenerNames := make([]string, 0, len(definitions))
+	httpServers := make([]*http.Server, 0, len(definitions))
+	for _, definition := range definitions {
+		netListener, err := definition.listen(s.ProxyConfig, inherited)
+		if err != nil {
+			klog.Fatalf("could not start listener on %s: %v", definition.Options.Name, err)
+		}
+		defer netListener.Close()
+		klog.Infof("listening on %s", definition.Options.Name)
+
+		options := definition.Options
+		httpServer := &http.Server{
+			ErrorLog: log.New(os.Stdout, "[ProxyServer] ", 0),
+			Handler:  s.createHandler(),
+			BaseContext: func(net.Listener) context.Context {
+				return proxy.WithListenerOptions(context.Background(), &options)
+			},
+		}
+		// http.Server only closes the listeners it serves already, close it even if Serve did not start yet.
+		httpServer.RegisterOnShutdown(func() {
+			_ = netListener.Close()
+		})
+		netListeners = append(netListeners, netListener)
+		listenerNames = append(listenerNames, definition.Options.Name)
+		httpServers = append(httpServers, httpServer)
 	}
-	defer proxyListener.Close()
-	s.listener = proxyListener
+	inherited.closeUnused()
+	unsetListenEnv()
+	s.lock.Lock()
+	s.listener = netListeners[0]
+	s.listeners = netListeners
+	s.listenerNames = listenerNames
+	s.httpServers = httpServers
+	s.lock.Unlock()
+	atomic.StoreInt32(&s.listening, 1)
+	defer atomic.StoreInt32(&s.listening, 0)
 
-	klog.Infof("listening on %v", s.ProxyConfig)
 	if s.serverReady != nil {
 		s.serverReady <- true
 		klog.Infof("notified serverReady channel for readiness")
 	}
+	notifyReady()
 
-	s.httpServer = &http.Server{
-		ErrorLog: log.New(os.Stdout, "[ProxyServer] ", 0),
-		Handler:  s.createHandler(),
+	errs := make(chan error, len(httpServers))
+	for i := range httpServers {
+		go func(httpServer *http.Server, netListener net.Listener) {
+			errs <- httpServer.Serve(netListener)
+		}(httpServers[i], netListeners[i])
 	}
+	for range httpServers {
+		if err = <-errs; err != http.ErrServerClosed {
+			klog.Fatalf("Proxy server exited unexpectedly: %v", err)
+		}
+	}
+	klog.Infof("Proxy server exited gracefully")
+}
 
-	err = s.httpServer.Serve(proxyListener)
-	if err != http.ErrServerClosed {
-		klog.Fatalf("Proxy server exited unexpectedly: %v", err)
-	} else {
-		klog.Infof("Proxy server exited gracefully")
+// Shutdown closes all listeners of the server, removing the unix socket, and drains the proxy handler.
+// It waits for in-flight requests until ctx is done, then closes the connections still open.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	httpServers := s.httpServers
+	s.lock.Unlock()
+
+	pending := len(httpServers)
+	errs := make(chan error, pending+1)
+	for _, httpServer := range httpServers {
+		go func(httpServer *http.Server) {
+			errs <- httpServer.Shutdown(ctx)
+		}(httpServer)
+	}
+	// hijacked session connections are not tracked by http.Server, only the handler ends them.
+	if handler, ok := s.ProxyHandler.(drainer); ok {
+		pending++
+		go func() {
+			errs <- handler.Drain(ctx)
+		}()
 	}
+	var err error
+	for i := 0; i < pending; i++ {
+		if shutdownErr := <-errs; shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	if err != nil {
+		s.Stop()
+	}
+	return err
 }
 
+// Stop closes all listeners and connections of the server.
 func (s *Server) Stop() {
-	s.httpServer.Close()
-	s.httpServer = nil
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, httpServer := range s.httpServers {
+		_ = httpServer.Close()
+	}
+	// the listeners of servers that did not start serving yet are still open.
+	for _, listener := range s.listeners {
+		_ = listener.Close()
+	}
+	s.httpServers = nil
 	s.listener = nil
+	s.listeners = nil
+	s.listenerNames = nil
+}
+
+// CheckListener returns an error if the proxy listeners are not open.
+func (s *Server) CheckListener(ctx context.Context) error {
+	if atomic.LoadInt32(&s.listening) == 0 {
+		return errors.New("proxy listeners are not open")
+	}
+	return nil
 }
 
 func (s *Server) createHandler() http.Handler {
@@ -6,8 +6,12 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 
 	"github.com/aws/amazon-eks-connector/pkg/config"
@@ -37,6 +41,11 @@ func (suite *TCPServerSuite) TestServerTraffic() {
 	suite.Equal(testResponseBodyOK, string(body))
 }
 
+func (suite *TCPServerSuite) TestCheckListener() {
+	suite.NoError(suite.server.CheckListener(context.Background()))
+	suite.Error((&Server{}).CheckListener(context.Background()), "server is not running")
+}
+
 func (suite *TCPServerSuite) Endpoint() string {
 	return "http://" + suite.server.listener.Addr().String()
 }
@@ -118,3 +127,244 @@ func (suite *UnixServerSuite) TearDownTest() {
 func ok(res http.ResponseWriter, req *http.Request) {
 	_, _ = res.Write([]byte(testResponseBodyOK))
 }
+
+func TestMultipleListenersSuite(t *testing.T) {
+	suite.Run(t, new(MultipleListenersSuite))
+}
+
+type MultipleListenersSuite struct {
+	suite.Suite
+	server *Server
+}
+
+func (suite *MultipleListenersSuite) SetupTest() {
+	dirName, err := os.MkdirTemp("", "eks_connector")
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { _ = os.RemoveAll(dirName) })
+
+	serverReady := make(chan bool)
+	suite.server = &Server{
+		ProxyConfig: &config.ProxyConfig{
+			SocketType:    config.Unix,
+			SocketAddress: filepath.Join(dirName, "connector.sock"),
+			Listeners: []string{
+				"tcp://127.0.0.1:0?mode=readonly&identity=trusted",
+				"unix://" + filepath.Join(dirName, "debug.sock"),
+			},
+		},
+		ProxyHandler: http.HandlerFunc(ok),
+		serverReady:  serverReady,
+	}
+	go suite.server.Run()
+	<-serverReady
+}
+
+func (suite *MultipleListenersSuite) TestServerTraffic() {
+	suite.Require().Len(suite.server.listeners, 3)
+	for _, listener := range suite.server.listeners {
+		// prepare
+		addr := listener.Addr()
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					return net.Dial(addr.Network(), addr.String())
+				},
+			},
+		}
+
+		// test
+		res, err := client.Get("http://foo.bar")
+
+		// verify
+		suite.Require().NoError(err, addr.String())
+		body, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		suite.NoError(err)
+		suite.Equal(testResponseBodyOK, string(body))
+	}
+}
+
+func (suite *MultipleListenersSuite) TestStop() {
+	// prepare
+	listeners := suite.server.listeners
+
+	// test
+	suite.server.Stop()
+
+	// verify
+	for _, listener := range listeners {
+		_, err := net.Dial(listener.Addr().Network(), listener.Addr().String())
+		suite.Error(err, listener.Addr().String())
+	}
+}
+
+func (suite *MultipleListenersSuite) TearDownTest() {
+	suite.server.Stop()
+}
+
+func TestParseListener(t *testing.T) {
+	listener, err := ParseListener("tcp://127.0.0.1:8080?mode=readonly&identity=trusted")
+	assert.NoError(t, err)
+	assert.Equal(t, config.TCP, listener.SocketType)
+	assert.Equal(t, "127.0.0.1:8080", listener.SocketAddress)
+	assert.Equal(t, "readonly", listener.Options.Mode)
+	assert.True(t, listener.Options.TrustIdentity)
+
+	listener, err = ParseListener("unix:///var/eks/shared/debug.sock")
+	assert.NoError(t, err)
+	assert.Equal(t, config.Unix, listener.SocketType)
+	assert.Equal(t, "/var/eks/shared/debug.sock", listener.SocketAddress)
+	assert.Empty(t, listener.Options.Mode, "listeners inherit the proxy mode")
+	assert.False(t, listener.Options.TrustIdentity)
+
+	for _, spec := range []string{
+		"udp://127.0.0.1:8080",
+		"tcp://",
+		"tls://0.0.0.0:8443?mode=admin",
+		"tcp://127.0.0.1:8080?identity=none",
+		"tcp://127.0.0.1:8080?debug=true",
+	} {
+		_, err = ParseListener(spec)
+		assert.Error(t, err, spec)
+	}
+
+	_, err = listeners(&config.ProxyConfig{
+		SocketType:    config.FD,
+		SocketAddress: "3",
+		Listeners:     []string{"tcp://127.0.0.1:8080", "fd://3?mode=readonly"},
+	})
+	assert.Error(t, err, "listeners are served once")
+}
+
+func TestShutdownSuite(t *testing.T) {
+	suite.Run(t, new(ShutdownSuite))
+}
+
+type ShutdownSuite struct {
+	suite.Suite
+	socketAddr string
+	handler    *drainingHandler
+	server     *Server
+	stopped    chan struct{}
+}
+
+func (suite *ShutdownSuite) SetupTest() {
+	dirName, err := os.MkdirTemp("", "eks_connector")
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { _ = os.RemoveAll(dirName) })
+	suite.socketAddr = filepath.Join(dirName, "connector.sock")
+
+	suite.handler = &drainingHandler{
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	serverReady := make(chan bool)
+	suite.server = &Server{
+		ProxyConfig: &config.ProxyConfig{
+			SocketType:    config.Unix,
+			SocketAddress: suite.socketAddr,
+		},
+		ProxyHandler: suite.handler,
+		serverReady:  serverReady,
+	}
+	server, stopped := suite.server, make(chan struct{})
+	suite.stopped = stopped
+	go func() {
+		server.Run()
+		close(stopped)
+	}()
+	<-serverReady
+}
+
+func (suite *ShutdownSuite) TearDownTest() {
+	suite.server.Stop()
+}
+
+func (suite *ShutdownSuite) TestDrainsInFlightRequests() {
+	// prepare
+	responses := suite.get()
+	<-suite.handler.started
+
+	// test
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownErr <- suite.server.Shutdown(ctx)
+	}()
+
+	// verify
+	<-suite.stopped
+	_, err := os.Stat(suite.socketAddr)
+	suite.True(os.IsNotExist(err), "the unix socket is removed once the server stops accepting")
+	suite.Eventually(func() bool {
+		return atomic.LoadInt32(&suite.handler.drained) == 1
+	}, time.Second, 10*time.Millisecond, "the proxy handler is drained")
+	close(suite.handler.release)
+	res := <-responses
+	suite.Require().NoError(res.err)
+	suite.Equal(testResponseBodyOK, res.body, "in-flight requests are served")
+	suite.NoError(<-shutdownErr)
+}
+
+func (suite *ShutdownSuite) TestDeadline() {
+	// prepare
+	responses := suite.get()
+	<-suite.handler.started
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// test
+	err := suite.server.Shutdown(ctx)
+
+	// verify
+	suite.ErrorIs(err, context.DeadlineExceeded)
+	suite.Error((<-responses).err, "requests still in flight after the deadline are closed")
+	close(suite.handler.release)
+}
+
+type response struct {
+	body string
+	err  error
+}
+
+// get requests the server over its unix socket in the background.
+func (suite *ShutdownSuite) get() <-chan response {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return net.Dial("unix", suite.socketAddr)
+			},
+		},
+	}
+	responses := make(chan response, 1)
+	go func() {
+		res, err := client.Get("http://foo.bar")
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		responses <- response{body: string(body), err: err}
+	}()
+	return responses
+}
+
+// drainingHandler answers ok once released, and records whether it was drained.
+type drainingHandler struct {
+	started chan struct{}
+	release chan struct{}
+	drained int32
+}
+
+func (h *drainingHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	h.started <- struct{}{}
+	<-h.release
+	ok(res, req)
+}
+
+func (h *drainingHandler) Drain(ctx context.Context) error {
+	atomic.StoreInt32(&h.drained, 1)
+	return nil
+}
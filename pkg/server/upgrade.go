@@ -0,0 +1,281 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+// Environment of the process started by Upgrade.
+const (
+	// envInheritedListeners maps the names of the inherited listeners to their file descriptors, url-encoded.
+	envInheritedListeners = "EKS_CONNECTOR_INHERITED_LISTENERS"
+	// envReadyFD is the pipe the new process writes to once its listeners are open.
+	envReadyFD = "EKS_CONNECTOR_READY_FD"
+)
+
+// fileListener is a listener whose socket can be passed to another process.
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+// namedListener is a listener handed off to the new process of an upgrade under its name.
+type namedListener struct {
+	name     string
+	listener net.Listener
+}
+
+// listenerFile returns a copy of the socket of l.
+func listenerFile(l net.Listener) (*os.File, error) {
+	f, ok := l.(fileListener)
+	if !ok {
+		return nil, fmt.Errorf("the %s listener %s cannot be passed to another process", l.Addr().Network(), l.Addr())
+	}
+	return f.File()
+}
+
+// ListenTCP listens on the tcp address addr for another server of eks connector, e.g. metrics,
+// and hands the listener named name off to the new process of an upgrade.
+// If the previous process handed off a listener named name, it is adopted instead.
+func (s *Server) ListenTCP(name, addr string) (net.Listener, error) {
+	var l net.Listener
+	var err error
+	if fd, ok := s.inheritedListeners().take(name); ok {
+		klog.Infof("adopting inherited listener %s", name)
+		l, err = adoptListener(fd, &config.UnixSocketConfig{})
+	} else {
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	s.otherListeners = append(s.otherListeners, namedListener{name: name, listener: l})
+	s.lock.Unlock()
+	return l, nil
+}
+
+// Upgrade starts a new process of the eks connector executable with the same arguments, which inherits
+// all listeners, and returns once the new process opened them. The caller should then drain the proxy and exit,
+// its unix sockets are kept for the new process.
+// The new process is killed if it is not ready within timeout, and the proxy keeps serving.
+// Under systemd, the new process becomes the main process of the service once it is ready. This requires
+// Type=notify and NotifyAccess=all in the unit, otherwise systemd stops the service when the caller exits.
+func (s *Server) Upgrade(timeout time.Duration) error {
+	s.lock.Lock()
+	listeners := make([]namedListener, 0, len(s.listeners)+len(s.otherListeners))
+	for i, l := range s.listeners {
+		listeners = append(listeners, namedListener{name: s.listenerNames[i], listener: l})
+	}
+	listeners = append(listeners, s.otherListeners...)
+	serving := len(s.listeners) > 0
+	command := s.command
+	s.lock.Unlock()
+	if !serving {
+		return fmt.Errorf("the proxy is not serving")
+	}
+
+	// ExtraFiles become the file descriptors of the new process from 3 on.
+	fds := url.Values{}
+	files := make([]*os.File, 0, len(listeners)+1)
+	defer func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}()
+	for _, l := range listeners {
+		file, err := listenerFile(l.listener)
+		if err != nil {
+			return err
+		}
+		fds.Set(l.name, strconv.Itoa(listenFDsStart+len(files)))
+		files = append(files, file)
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+	readyFD := listenFDsStart + len(files)
+	files = append(files, readyWriter)
+
+	if command == nil {
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		command = append([]string{executable}, os.Args[1:]...)
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		envInheritedListeners+"="+fds.Encode(),
+		envReadyFD+"="+strconv.Itoa(readyFD))
+	cmd.ExtraFiles = files
+	err = cmd.Start()
+	for _, file := range files[:len(listeners)] {
+		if nonblockErr := restoreNonblock(file); nonblockErr != nil {
+			klog.Errorf("failed to restore the non-blocking mode of %s: %v", file.Name(), nonblockErr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start the new process: %w", err)
+	}
+	// only the new process holds the pipe now, so that the read fails if it exits before it is ready.
+	_ = readyWriter.Close()
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyReader.Read(make([]byte, 1))
+		ready <- err
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-ready:
+	case <-timer.C:
+		err = fmt.Errorf("not ready after %s", timeout)
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return fmt.Errorf("the new process %d failed to start serving: %w", cmd.Process.Pid, err)
+	}
+
+	for _, l := range listeners {
+		if ul, ok := l.listener.(*unixListener); ok {
+			ul.keepSocket()
+		}
+	}
+	klog.Infof("handed off listeners to the new process %d", cmd.Process.Pid)
+	return cmd.Process.Release()
+}
+
+// inheritedListeners returns the listeners handed off by the previous process of an upgrade.
+func (s *Server) inheritedListeners() *inheritedListeners {
+	s.inheritOnce.Do(func() {
+		s.inherited = newInheritedListeners()
+	})
+	return s.inherited
+}
+
+// inheritedListeners are the sockets handed off by the previous process of an upgrade,
+// which are adopted by name instead of listening again.
+type inheritedListeners struct {
+	lock sync.Mutex
+	fds  map[string]int
+}
+
+// newInheritedListeners returns the listeners passed in envInheritedListeners, which is then cleared.
+func newInheritedListeners() *inheritedListeners {
+	inherited := &inheritedListeners{
+		fds: map[string]int{},
+	}
+	value := os.Getenv(envInheritedListeners)
+	if value == "" {
+		return inherited
+	}
+	_ = os.Unsetenv(envInheritedListeners)
+	fds, err := url.ParseQuery(value)
+	if err != nil {
+		klog.Errorf("ignoring invalid %s: %v", envInheritedListeners, err)
+		return inherited
+	}
+	for name := range fds {
+		fd, err := strconv.Atoi(fds.Get(name))
+		if err != nil || fd < listenFDsStart {
+			klog.Errorf("ignoring inherited listener %s with invalid file descriptor %q", name, fds.Get(name))
+			continue
+		}
+		inherited.fds[name] = fd
+	}
+	return inherited
+}
+
+// take returns the file descriptor of the listener named name, if it was inherited and not taken yet.
+func (i *inheritedListeners) take(name string) (int, bool) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	fd, ok := i.fds[name]
+	delete(i.fds, name)
+	return fd, ok
+}
+
+// closeUnused closes the inherited listeners that were not taken, e.g. removed from proxy.listeners,
+// so that clients do not wait on sockets nobody accepts connections on.
+func (i *inheritedListeners) closeUnused() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	for name, fd := range i.fds {
+		klog.Infof("closing inherited listener %s, which is not served anymore", name)
+		_ = os.NewFile(uintptr(fd), name).Close()
+	}
+	i.fds = map[string]int{}
+}
+
+// adoptInherited adopts the inherited socket fd of a listener of proxyConfig, as NewListener would have opened it.
+func adoptInherited(fd int, proxyConfig *config.ProxyConfig) (net.Listener, error) {
+	unixSocketConfig := &config.UnixSocketConfig{}
+	if proxyConfig.SocketType == config.Unix || proxyConfig.SocketType == config.Systemd ||
+		proxyConfig.SocketType == config.FD {
+		unixSocketConfig = &proxyConfig.UnixSocket
+	}
+	l, err := adoptListener(fd, unixSocketConfig)
+	if err != nil {
+		return nil, err
+	}
+	switch proxyConfig.SocketType {
+	case config.Unix:
+		// the socket was created by eks connector, which still removes it once it stops.
+		if ul, ok := l.(*unixListener); ok {
+			atomic.StoreInt32(&ul.removeSocket, 1)
+		}
+	case config.TLS:
+		tlsConfig, err := newServerTLSConfig(&proxyConfig.TLS)
+		if err != nil {
+			_ = l.Close()
+			return nil, err
+		}
+		return newTLSListener(l, tlsConfig), nil
+	}
+	return l, nil
+}
+
+// notifyReady tells systemd, and the previous process of an upgrade, that the listeners are open.
+// The new process of an upgrade becomes the main process of the systemd service before the previous one exits,
+// which requires NotifyAccess=all as it is not the main process yet.
+func notifyReady() {
+	value := os.Getenv(envReadyFD)
+	_ = os.Unsetenv(envReadyFD)
+	state := "READY=1"
+	if value != "" {
+		state = fmt.Sprintf("MAINPID=%d\n%s", os.Getpid(), state)
+	}
+	if err := sdNotify(state); err != nil {
+		klog.Errorf("failed to notify systemd: %v", err)
+	}
+	if value == "" {
+		return
+	}
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < listenFDsStart {
+		klog.Errorf("ignoring invalid %s %q", envReadyFD, value)
+		return
+	}
+	ready := os.NewFile(uintptr(fd), "ready")
+	defer ready.Close()
+	if _, err = ready.Write([]byte{1}); err != nil {
+		klog.Errorf("failed to notify the previous process: %v", err)
+	}
+}
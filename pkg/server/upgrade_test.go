@@ -0,0 +1,231 @@
+//go:build linux
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+// Environment of the new process started by UpgradeSuite.
+const (
+	envHelperUpgradeSocket = "EKS_CONNECTOR_TEST_UPGRADE_SOCKET"
+	// envHelperUpgradeFailure is exit to exit at once, or hang to never open the listeners.
+	envHelperUpgradeFailure = "EKS_CONNECTOR_TEST_UPGRADE_FAILURE"
+)
+
+// testDebugListener is the additional listener handed off in UpgradeSuite.
+const testDebugListener = "tcp://127.0.0.1:0?identity=trusted"
+
+// TestUpgradeHelperProcess is the new process started by UpgradeSuite, which answers its pid.
+func TestUpgradeHelperProcess(t *testing.T) {
+	socketAddr := os.Getenv(envHelperUpgradeSocket)
+	if socketAddr == "" {
+		t.Skip("only runs as a process started by UpgradeSuite")
+	}
+	switch os.Getenv(envHelperUpgradeFailure) {
+	case "exit":
+		os.Exit(1)
+	case "hang":
+		select {}
+	}
+	server := newPidServer(socketAddr)
+	metricsListener, err := server.ListenTCP("metrics", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = http.Serve(metricsListener, server.ProxyHandler)
+	}()
+	server.Run()
+}
+
+// newPidServer returns a server answering the pid of the process on the unix socket socketAddr and testDebugListener.
+func newPidServer(socketAddr string) *Server {
+	return &Server{
+		ProxyConfig: &config.ProxyConfig{
+			SocketType:    config.Unix,
+			SocketAddress: socketAddr,
+			Listeners:     []string{testDebugListener},
+		},
+		ProxyHandler: http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			_, _ = res.Write([]byte(strconv.Itoa(os.Getpid())))
+		}),
+	}
+}
+
+func TestUpgradeSuite(t *testing.T) {
+	suite.Run(t, new(UpgradeSuite))
+}
+
+type UpgradeSuite struct {
+	suite.Suite
+
+	socketAddr      string
+	server          *Server
+	metricsListener net.Listener
+	stopped         chan struct{}
+}
+
+func (suite *UpgradeSuite) SetupTest() {
+	suite.socketAddr = filepath.Join(suite.T().TempDir(), "connector.sock")
+	suite.T().Setenv(envHelperUpgradeSocket, suite.socketAddr)
+
+	serverReady := make(chan bool)
+	suite.server = newPidServer(suite.socketAddr)
+	suite.server.serverReady = serverReady
+	suite.server.command = []string{os.Args[0], "-test.run=^TestUpgradeHelperProcess$"}
+	var err error
+	suite.metricsListener, err = suite.server.ListenTCP("metrics", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	server, stopped := suite.server, make(chan struct{})
+	suite.stopped = stopped
+	go func() {
+		server.Run()
+		close(stopped)
+	}()
+	<-serverReady
+}
+
+func (suite *UpgradeSuite) TearDownTest() {
+	suite.server.Stop()
+	_ = suite.metricsListener.Close()
+}
+
+func (suite *UpgradeSuite) TestHandsOffListeners() {
+	// prepare
+	debugAddr := suite.server.listeners[1].Addr().String()
+	metricsAddr := suite.metricsListener.Addr().String()
+
+	// test
+	err := suite.server.Upgrade(10 * time.Second)
+
+	// verify
+	suite.Require().NoError(err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	suite.NoError(suite.server.Shutdown(ctx))
+	_ = suite.metricsListener.Close()
+	<-suite.stopped
+	_, err = os.Stat(suite.socketAddr)
+	suite.NoError(err, "the unix socket is kept for the new process")
+
+	pid, err := suite.get("unix", suite.socketAddr)
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() {
+		// the test process is still the parent of the new process.
+		if process, err := os.FindProcess(pid); err == nil {
+			_ = process.Kill()
+			_, _ = process.Wait()
+		}
+	})
+	suite.NotEqual(os.Getpid(), pid, "the new process serves the unix socket")
+	debugPid, err := suite.get("tcp", debugAddr)
+	suite.NoError(err)
+	suite.Equal(pid, debugPid, "the new process serves proxy.listeners")
+	metricsPid, err := suite.get("tcp", metricsAddr)
+	suite.NoError(err)
+	suite.Equal(pid, metricsPid, "the new process serves the listeners of ListenTCP")
+}
+
+func (suite *UpgradeSuite) TestFailedUpgrade() {
+	for _, failure := range []string{"exit", "hang"} {
+		// prepare
+		suite.T().Setenv(envHelperUpgradeFailure, failure)
+
+		// test
+		err := suite.server.Upgrade(500 * time.Millisecond)
+
+		// verify
+		suite.Error(err, failure)
+		pid, err := suite.get("unix", suite.socketAddr)
+		suite.NoError(err)
+		suite.Equal(os.Getpid(), pid, "the proxy keeps serving if the new process is not ready")
+	}
+}
+
+func (suite *UpgradeSuite) TestNotifiesSystemd() {
+	// prepare
+	notifications := suite.listenNotifySocket()
+
+	// test
+	err := suite.server.Upgrade(10 * time.Second)
+
+	// verify
+	suite.Require().NoError(err)
+	state := suite.readNotification(notifications)
+	var pid int
+	_, err = fmt.Sscanf(state, "MAINPID=%d\nREADY=1", &pid)
+	suite.Require().NoError(err, state)
+	suite.T().Cleanup(func() {
+		if process, err := os.FindProcess(pid); err == nil {
+			_ = process.Kill()
+			_, _ = process.Wait()
+		}
+	})
+	suite.NotEqual(os.Getpid(), pid, "the new process becomes the main process of the service")
+}
+
+func (suite *UpgradeSuite) TestNotifyReady() {
+	// prepare
+	notifications := suite.listenNotifySocket()
+
+	// test
+	notifyReady()
+
+	// verify
+	suite.Equal("READY=1", suite.readNotification(notifications))
+}
+
+// listenNotifySocket listens on the socket of systemd notifications passed to the new process.
+func (suite *UpgradeSuite) listenNotifySocket() *net.UnixConn {
+	socket := filepath.Join(suite.T().TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { _ = conn.Close() })
+	suite.T().Setenv(envNotifySocket, socket)
+	return conn
+}
+
+// readNotification returns the state sent to the notification socket conn.
+func (suite *UpgradeSuite) readNotification(conn *net.UnixConn) string {
+	suite.Require().NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	suite.Require().NoError(err)
+	return string(buf[:n])
+}
+
+// get returns the pid answered on the socket addr.
+func (suite *UpgradeSuite) get(network, addr string) (int, error) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+	}
+	res, err := client.Get("http://foo.bar")
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(body))
+}
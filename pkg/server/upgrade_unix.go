@@ -0,0 +1,14 @@
+//go:build !windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// restoreNonblock makes the socket of file non-blocking again. exec sets the files passed to a process blocking,
+// which also blocks the accept loop of the listener sharing the socket.
+func restoreNonblock(file *os.File) error {
+	return syscall.SetNonblock(int(file.Fd()), true)
+}
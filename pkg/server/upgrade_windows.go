@@ -0,0 +1,10 @@
+package server
+
+import (
+	"os"
+)
+
+// restoreNonblock does nothing, files cannot be passed to another process on windows.
+func restoreNonblock(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,146 @@
+package serviceaccount
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/filewatch"
+	"github.com/aws/amazon-eks-connector/pkg/metrics"
+)
+
+// Reasons of a reload of a cached secret.
+const (
+	ReloadChanged      = "changed"
+	ReloadUnauthorized = "unauthorized"
+	ReloadExpired      = "expired"
+)
+
+// errTokenExpired is returned when the token read again after it expired has expired too.
+var errTokenExpired = errors.New("service account token expired")
+
+var (
+	reloadsTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_service_account_reloads_total",
+		Help: "Reloads of the cached service account secret, by reason.",
+	}, "reason")
+	reloadFailuresTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_service_account_reload_failures_total",
+		Help: "Failed reloads of the cached service account secret, which keep the previous secret.",
+	})
+	tokenExpiry = metrics.NewGaugeVec(metrics.Opts{
+		Name: "eks_connector_service_account_token_expiry_timestamp_seconds",
+		Help: "Expiry of the cached service account token in unix time, 0 if the token has no expiry.",
+	})
+)
+
+// ReloadableSecretProvider is a SecretProvider that caches the secret until it changes.
+type ReloadableSecretProvider interface {
+	SecretProvider
+	// Reload reads the secret again, e.g. after api server rejected the cached token.
+	// The previous secret is kept if the secret cannot be read.
+	Reload(reason string) error
+}
+
+// NewCachedProvider returns a provider caching the secret of provider,
+// which is reloaded whenever a file of dir changes, and once the token expired.
+// Watching dir covers the ..data symlink that kubernetes swaps when it rotates a projected token.
+func NewCachedProvider(provider SecretProvider, dir string) ReloadableSecretProvider {
+	cached := &cachedSecretProvider{
+		provider: provider,
+	}
+	if err := filewatch.WatchDir("service account", dir, func() { _ = cached.Reload(ReloadChanged) }); err != nil {
+		klog.Warningf("cannot watch %s for service account changes, relying on expiry and api server rejections: %v", dir, err)
+	}
+	return cached
+}
+
+type cachedSecretProvider struct {
+	provider SecretProvider
+
+	lock   sync.RWMutex
+	secret *Secret
+}
+
+func (r *cachedSecretProvider) Get() (*Secret, error) {
+	r.lock.RLock()
+	secret := r.secret
+	r.lock.RUnlock()
+	if secret == nil {
+		// nothing could be loaded yet, keep trying on each request.
+		var err error
+		if secret, err = r.load(); err != nil {
+			return nil, err
+		}
+	}
+	if expired(secret) {
+		if err := r.Reload(ReloadExpired); err != nil {
+			return nil, err
+		}
+		r.lock.RLock()
+		secret = r.secret
+		r.lock.RUnlock()
+	}
+	// the credentials on disk are not rotated, e.g. a static kubeconfig token, or kubelet stopped rotating it.
+	if expired(secret) {
+		return nil, fmt.Errorf("%w at %s", errTokenExpired, secret.Expiry.Format(time.RFC3339))
+	}
+	return secret, nil
+}
+
+func (r *cachedSecretProvider) Reload(reason string) error {
+	reloadsTotal.Inc(reason)
+	if _, err := r.load(); err != nil {
+		reloadFailuresTotal.Inc()
+		klog.Errorf("failed to reload service account secret (%s), keeping the previous one: %v", reason, err)
+		return err
+	}
+	klog.V(2).Infof("reloaded service account secret (%s)", reason)
+	return nil
+}
+
+func (r *cachedSecretProvider) load() (*Secret, error) {
+	secret, err := r.provider.Get()
+	if err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	r.secret = secret
+	r.lock.Unlock()
+	expiry := float64(0)
+	if !secret.Expiry.IsZero() {
+		expiry = float64(secret.Expiry.Unix())
+	}
+	tokenExpiry.Set(expiry)
+	return secret, nil
+}
+
+func expired(secret *Secret) bool {
+	return !secret.Expiry.IsZero() && time.Now().After(secret.Expiry)
+}
+
+// parseTokenExpiry returns the expiry of a JWT token, or zero if it is not a JWT or has no expiry.
+// The token is not verified, only api server can tell whether it is valid.
+func parseTokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+	if err = json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
@@ -0,0 +1,181 @@
+package serviceaccount
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCachedSuite(t *testing.T) {
+	suite.Run(t, new(CachedSuite))
+}
+
+type CachedSuite struct {
+	suite.Suite
+
+	dirName string
+}
+
+func (suite *CachedSuite) SetupTest() {
+	suite.dirName = suite.T().TempDir()
+}
+
+func (suite *CachedSuite) TestGetCaches() {
+	// prepare
+	provider := &MockSecretProvider{}
+	provider.On("Get").Return(&Secret{Token: testSAToken}, nil)
+	cached := NewCachedProvider(provider, suite.dirName)
+
+	// test
+	secret1, err1 := cached.Get()
+	secret2, err2 := cached.Get()
+
+	// verify
+	suite.NoError(err1)
+	suite.NoError(err2)
+	suite.Same(secret1, secret2)
+	provider.AssertNumberOfCalls(suite.T(), "Get", 1)
+}
+
+func (suite *CachedSuite) TestGetRetriesUntilLoaded() {
+	// prepare
+	provider := &MockSecretProvider{}
+	provider.On("Get").Return(nil, errors.New("token not found")).Once()
+	provider.On("Get").Return(&Secret{Token: testSAToken}, nil)
+	cached := NewCachedProvider(provider, suite.dirName)
+
+	// test
+	_, err1 := cached.Get()
+	secret, err2 := cached.Get()
+
+	// verify
+	suite.Error(err1)
+	suite.NoError(err2)
+	suite.Equal(testSAToken, secret.Token)
+}
+
+func (suite *CachedSuite) TestGetReloadsExpiredToken() {
+	// prepare
+	provider := &MockSecretProvider{}
+	provider.On("Get").Return(&Secret{Token: "expired", Expiry: time.Now().Add(-time.Minute)}, nil).Once()
+	provider.On("Get").Return(&Secret{Token: testSAToken}, nil)
+	cached := NewCachedProvider(provider, suite.dirName)
+
+	// test
+	_, err := cached.Get()
+	suite.NoError(err)
+	secret, err := cached.Get()
+
+	// verify
+	suite.NoError(err)
+	suite.Equal(testSAToken, secret.Token)
+}
+
+func (suite *CachedSuite) TestGetExpiredTokenOnDisk() {
+	// prepare
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"exp":1697621400}`))
+	suite.writeFile(FileRootCAs, testCACerts)
+	suite.writeFile(FileToken, header+"."+payload+".signature")
+	cached := NewCachedProvider(&mountedSecretProvider{suite.dirName}, suite.dirName)
+
+	// test
+	_, err1 := cached.Get()
+	_, err2 := cached.Get()
+
+	// verify
+	suite.ErrorIs(err1, errTokenExpired)
+	suite.ErrorIs(err2, errTokenExpired, "the token is read again on each request until it is rotated")
+}
+
+func (suite *CachedSuite) TestReloadKeepsPreviousSecret() {
+	// prepare
+	provider := &MockSecretProvider{}
+	provider.On("Get").Return(&Secret{Token: testSAToken}, nil).Once()
+	provider.On("Get").Return(nil, errors.New("token not found"))
+	cached := NewCachedProvider(provider, suite.dirName)
+	_, err := cached.Get()
+	suite.NoError(err)
+
+	// test
+	err = cached.Reload(ReloadUnauthorized)
+
+	// verify
+	suite.Error(err)
+	secret, err := cached.Get()
+	suite.NoError(err)
+	suite.Equal(testSAToken, secret.Token)
+}
+
+func (suite *CachedSuite) TestReloadsOnChange() {
+	// prepare
+	suite.writeFile(FileRootCAs, testCACerts)
+	suite.writeFile(FileToken, "old")
+	cached := NewCachedProvider(&mountedSecretProvider{suite.dirName}, suite.dirName)
+	secret, err := cached.Get()
+	suite.NoError(err)
+	suite.Equal("old", secret.Token)
+
+	// test
+	suite.writeFile(FileToken, testSAToken)
+
+	// verify
+	suite.Eventually(func() bool {
+		secret, err := cached.Get()
+		return err == nil && secret.Token == testSAToken
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (suite *CachedSuite) TestReloadsOnDataSymlinkSwap() {
+	// prepare
+	// lay out files like kubelet does for projected volumes.
+	suite.writeVersion("..2023_10_18_09_30_00.1", "old")
+	suite.NoError(os.Symlink("..2023_10_18_09_30_00.1", path.Join(suite.dirName, "..data")))
+	suite.NoError(os.Symlink(path.Join("..data", FileToken), path.Join(suite.dirName, FileToken)))
+	suite.NoError(os.Symlink(path.Join("..data", FileRootCAs), path.Join(suite.dirName, FileRootCAs)))
+	cached := NewCachedProvider(&mountedSecretProvider{suite.dirName}, suite.dirName)
+	secret, err := cached.Get()
+	suite.NoError(err)
+	suite.Equal("old", secret.Token)
+
+	// test
+	suite.writeVersion("..2023_10_18_09_40_00.2", testSAToken)
+	suite.NoError(os.Symlink("..2023_10_18_09_40_00.2", path.Join(suite.dirName, "..data_tmp")))
+	suite.NoError(os.Rename(path.Join(suite.dirName, "..data_tmp"), path.Join(suite.dirName, "..data")))
+
+	// verify
+	suite.Eventually(func() bool {
+		secret, err := cached.Get()
+		return err == nil && secret.Token == testSAToken
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (suite *CachedSuite) TestParseTokenExpiry() {
+	// prepare
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"exp":1697621400,"sub":"system:serviceaccount:eks-connector:eks-connector"}`))
+
+	// test
+	expiry := parseTokenExpiry(header + "." + payload + ".signature")
+
+	// verify
+	suite.Equal(time.Unix(1697621400, 0), expiry)
+	suite.True(parseTokenExpiry(testSAToken).IsZero(), "tokens that are not JWTs have no expiry")
+	suite.True(parseTokenExpiry(header+"."+header+".signature").IsZero(), "JWTs without exp claim have no expiry")
+}
+
+func (suite *CachedSuite) writeFile(name, content string) {
+	suite.NoError(os.WriteFile(path.Join(suite.dirName, name), []byte(content), 0600))
+}
+
+// writeVersion writes a timestamped directory of the secret files, like kubelet before it swaps the ..data symlink.
+func (suite *CachedSuite) writeVersion(name, token string) {
+	suite.NoError(os.Mkdir(path.Join(suite.dirName, name), 0700))
+	suite.writeFile(path.Join(name, FileToken), token)
+	suite.writeFile(path.Join(name, FileRootCAs), testCACerts)
+}
@@ -0,0 +1,49 @@
+package serviceaccount
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"github.com/aws/amazon-eks-connector/pkg/kubeconfig"
+)
+
+// NewKubeconfigProvider returns a SecretProvider of the credentials of the current context of a kubeconfig file,
+// for eks connector running outside the cluster.
+func NewKubeconfigProvider(path string) SecretProvider {
+	return &kubeconfigSecretProvider{
+		path: path,
+	}
+}
+
+type kubeconfigSecretProvider struct {
+	path string
+}
+
+func (r *kubeconfigSecretProvider) Get() (*Secret, error) {
+	config, err := kubeconfig.Load(r.path)
+	if err != nil {
+		return nil, err
+	}
+	secret := &Secret{
+		Token:      config.Token,
+		ServerName: config.ServerName,
+		Expiry:     parseTokenExpiry(config.Token),
+	}
+	if len(config.CAData) > 0 {
+		secret.RootCAs = x509.NewCertPool()
+		if !secret.RootCAs.AppendCertsFromPEM(config.CAData) {
+			return nil, errors.New("kubeconfig certificate authority contains no PEM certificate")
+		}
+	} else if secret.RootCAs, err = x509.SystemCertPool(); err != nil {
+		return nil, err
+	}
+	if len(config.CertData) > 0 {
+		certificate, err := tls.X509KeyPair(config.CertData, config.KeyData)
+		if err != nil {
+			return nil, err
+		}
+		secret.ClientCertificate = &certificate
+	}
+	return secret, nil
+}
@@ -2,9 +2,12 @@
 package serviceaccount
 
 import (
+	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"os"
 	"path"
+	"time"
 )
 
 const (
@@ -16,6 +19,13 @@ const (
 type Secret struct {
 	RootCAs *x509.CertPool
 	Token   string
+
+	// ClientCertificate authenticates eks connector to api server, if set.
+	ClientCertificate *tls.Certificate
+	// ServerName is verified in the api server certificate instead of the target host, if set.
+	ServerName string
+	// Expiry is when the token expires, parsed from the token if it is a JWT. Zero if unknown.
+	Expiry time.Time
 }
 
 type SecretProvider interface {
@@ -23,8 +33,14 @@ type SecretProvider interface {
 }
 
 func NewProvider() SecretProvider {
+	return NewDirProvider(BaseDir)
+}
+
+// NewDirProvider returns a SecretProvider of the token and CA bundle in baseDir,
+// laid out like the automounted service account.
+func NewDirProvider(baseDir string) SecretProvider {
 	return &mountedSecretProvider{
-		baseDir: BaseDir,
+		baseDir: baseDir,
 	}
 }
 
@@ -46,6 +62,7 @@ func (r *mountedSecretProvider) Get() (*Secret, error) {
 	return &Secret{
 		Token:   token,
 		RootCAs: caCert,
+		Expiry:  parseTokenExpiry(token),
 	}, nil
 }
 
@@ -56,7 +73,9 @@ func (r *mountedSecretProvider) rootCAs() (*x509.CertPool, error) {
 	}
 
 	pool := x509.NewCertPool()
-	pool.AppendCertsFromPEM(caCert)
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("%s contains no PEM certificate", FileRootCAs)
+	}
 
 	return pool, nil
 }
@@ -76,6 +76,23 @@ func (suite *ServiceAccountSuite) TestSecretProviderMissingCACerts() {
 	suite.Error(err)
 }
 
+func (suite *ServiceAccountSuite) TestSecretProviderInvalidCACerts() {
+	// prepare
+	secretProvider := &mountedSecretProvider{
+		suite.dirName,
+	}
+	err := suite.writeSAToken(testSAToken)
+	suite.NoError(err)
+	err = suite.writeCACerts("not a certificate")
+	suite.NoError(err)
+
+	// test
+	_, err = secretProvider.Get()
+
+	// verify
+	suite.Error(err)
+}
+
 func (suite *ServiceAccountSuite) TestSecretProviderMissingSAToken() {
 	// prepare
 	secretProvider := &mountedSecretProvider{
@@ -91,6 +108,56 @@ func (suite *ServiceAccountSuite) TestSecretProviderMissingSAToken() {
 	suite.Error(err)
 }
 
+func (suite *ServiceAccountSuite) TestKubeconfigProvider() {
+	// prepare
+	err := suite.writeCACerts(testCACerts)
+	suite.NoError(err)
+	kubeconfigPath := path.Join(suite.dirName, "kubeconfig")
+	err = os.WriteFile(kubeconfigPath, []byte(`
+current-context: default
+contexts:
+- name: default
+  context:
+    cluster: default
+    user: default
+clusters:
+- name: default
+  cluster:
+    server: https://10.0.0.1:6443
+    certificate-authority: `+FileRootCAs+`
+    tls-server-name: kubernetes.internal
+users:
+- name: default
+  user:
+    token: `+testSAToken+`
+`), 0600)
+	suite.NoError(err)
+	secretProvider := NewKubeconfigProvider(kubeconfigPath)
+
+	// test
+	secret, err := secretProvider.Get()
+
+	// verify
+	suite.NoError(err)
+	suite.Equal(testSAToken, secret.Token)
+	suite.Equal("kubernetes.internal", secret.ServerName)
+	suite.Nil(secret.ClientCertificate)
+	// nolint:staticcheck
+	// ignoring secret.RootCAs.Subjects is deprecated ERR because cert does not come from SystemCertPool.
+	suite.Len(secret.RootCAs.Subjects(), 1)
+}
+
+func (suite *ServiceAccountSuite) TestKubeconfigProviderMissingFile() {
+	// prepare
+	secretProvider := NewKubeconfigProvider(path.Join(suite.dirName, "kubeconfig"))
+
+	// test
+	_, err := secretProvider.Get()
+
+	// verify
+	suite.Error(err)
+}
+
 func (suite *ServiceAccountSuite) SetupTest() {
 	dir, err := os.MkdirTemp("", "eks_connector_sa")
 	suite.NoError(err)
@@ -0,0 +1,203 @@
+package serviceaccount
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationV1 "k8s.io/api/authentication/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+	"github.com/aws/amazon-eks-connector/pkg/metrics"
+)
+
+const (
+	// tokenRequestTimeout bounds a TokenRequest call, which blocks the requests waiting for a token.
+	tokenRequestTimeout = 10 * time.Second
+	// tokenRequestRetryInterval is how long requests use the current or fallback token after a TokenRequest failed,
+	// and the minimum interval between the TokenRequests forced by api server rejections.
+	tokenRequestRetryInterval = 10 * time.Second
+	// tokenRefreshRatio is the part of the token lifetime after which it is refreshed, like kubelet does.
+	tokenRefreshRatio = 0.8
+)
+
+var (
+	tokenRequestsTotal = metrics.NewCounterVec(metrics.Opts{
+		Name: "eks_connector_service_account_token_requests_total",
+		Help: "Tokens requested through the TokenRequest API, by result.",
+	}, "result")
+	requestedTokenExpiry = metrics.NewGaugeVec(metrics.Opts{
+		Name: "eks_connector_service_account_requested_token_expiry_timestamp_seconds",
+		Help: "Expiry of the last token requested through the TokenRequest API in unix time.",
+	})
+)
+
+// NewTokenRequestProvider returns a SecretProvider of short-lived tokens of the service account of tokenRequestConfig,
+// minted through the TokenRequest API and refreshed before they expire.
+// The secret of fallback provides the CA bundle of api server, and its token is used whenever no token can be minted.
+func NewTokenRequestProvider(client kubernetes.Interface, tokenRequestConfig *config.TokenRequestConfig,
+	fallback SecretProvider) (ReloadableSecretProvider, error) {
+	parts := strings.Split(tokenRequestConfig.ServiceAccount, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid service account %q, expected namespace/name", tokenRequestConfig.ServiceAccount)
+	}
+	return &tokenRequestProvider{
+		client:     client,
+		namespace:  parts[0],
+		name:       parts[1],
+		audiences:  tokenRequestConfig.Audiences,
+		expiration: tokenRequestConfig.Expiration,
+		fallback:   fallback,
+	}, nil
+}
+
+type tokenRequestProvider struct {
+	client     kubernetes.Interface
+	namespace  string
+	name       string
+	audiences  []string
+	expiration time.Duration
+	fallback   SecretProvider
+
+	lock    sync.Mutex
+	current *requestedToken
+	retryAt time.Time
+	// requestedAt is when the last TokenRequest started.
+	requestedAt time.Time
+	// pending is closed once the TokenRequest in progress completes, nil if there is none.
+	pending chan struct{}
+}
+
+type requestedToken struct {
+	token     string
+	expiry    time.Time
+	refreshAt time.Time
+}
+
+func (r *tokenRequestProvider) Get() (*Secret, error) {
+	secret, err := r.fallback.Get()
+	if err != nil {
+		return nil, err
+	}
+	token := r.token(false)
+	if token == nil {
+		return secret, nil
+	}
+	requested := *secret
+	requested.Token = token.token
+	requested.Expiry = token.expiry
+	// api server authenticates client certificates before tokens.
+	requested.ClientCertificate = nil
+	return &requested, nil
+}
+
+// Reload requests a new token, and reloads the fallback secret if it is cached.
+// A new token is requested at most once per tokenRequestRetryInterval: every request is rejected
+// while the service account is deleted or its tokens are revoked, which must not flood api server with TokenRequests.
+func (r *tokenRequestProvider) Reload(reason string) error {
+	if reloader, ok := r.fallback.(ReloadableSecretProvider); ok {
+		_ = reloader.Reload(reason)
+	}
+	if r.token(true) == nil {
+		return fmt.Errorf("failed to request a token for service account %s/%s", r.namespace, r.name)
+	}
+	return nil
+}
+
+// token returns a valid requested token, requesting a new one when it is due for refresh or force is set.
+// It returns nil if no valid token could be requested.
+// The lock is not held during the TokenRequest: meanwhile, the current token is used while it is valid,
+// and only callers without a valid token wait for the request to complete.
+func (r *tokenRequestProvider) token(force bool) *requestedToken {
+	r.lock.Lock()
+	now := time.Now()
+	if force && now.Sub(r.requestedAt) < tokenRequestRetryInterval {
+		force = false
+	}
+	if !force && r.current != nil && now.Before(r.current.refreshAt) {
+		defer r.lock.Unlock()
+		return r.current
+	}
+	if !force && now.Before(r.retryAt) {
+		defer r.lock.Unlock()
+		return r.valid(now)
+	}
+	if pending := r.pending; pending != nil {
+		if current := r.valid(now); current != nil && !force {
+			r.lock.Unlock()
+			return current
+		}
+		r.lock.Unlock()
+		<-pending
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return r.valid(time.Now())
+	}
+	pending := make(chan struct{})
+	r.pending = pending
+	r.requestedAt = now
+	r.lock.Unlock()
+
+	token, err := r.request(now)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.pending = nil
+	close(pending)
+	if err != nil {
+		tokenRequestsTotal.Inc("error")
+		r.retryAt = now.Add(tokenRequestRetryInterval)
+		klog.Errorf("failed to request a token for service account %s/%s: %v", r.namespace, r.name, err)
+		current := r.valid(now)
+		if current == nil {
+			klog.Warningf("no valid requested token, falling back to the token of the cluster credentials")
+		}
+		return current
+	}
+	tokenRequestsTotal.Inc("success")
+	requestedTokenExpiry.Set(float64(token.expiry.Unix()))
+	klog.V(2).Infof("requested a token for service account %s/%s expiring at %s", r.namespace, r.name, token.expiry)
+	r.current = token
+	return token
+}
+
+// valid returns the current token if it has not expired at now.
+func (r *tokenRequestProvider) valid(now time.Time) *requestedToken {
+	if r.current == nil || !now.Before(r.current.expiry) {
+		return nil
+	}
+	return r.current
+}
+
+func (r *tokenRequestProvider) request(now time.Time) (*requestedToken, error) {
+	tokenRequest := &authenticationV1.TokenRequest{
+		Spec: authenticationV1.TokenRequestSpec{
+			Audiences: r.audiences,
+		},
+	}
+	if r.expiration > 0 {
+		expirationSeconds := int64(r.expiration.Seconds())
+		tokenRequest.Spec.ExpirationSeconds = &expirationSeconds
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tokenRequestTimeout)
+	defer cancel()
+	response, err := r.client.CoreV1().ServiceAccounts(r.namespace).CreateToken(ctx, r.name, tokenRequest, metaV1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	expiry := response.Status.ExpirationTimestamp.Time
+	if response.Status.Token == "" || !expiry.After(now) {
+		return nil, fmt.Errorf("api server returned an invalid token expiring at %s", expiry)
+	}
+	lifetime := expiry.Sub(now)
+	return &requestedToken{
+		token:     response.Status.Token,
+		expiry:    expiry,
+		refreshAt: now.Add(time.Duration(float64(lifetime) * tokenRefreshRatio)),
+	}, nil
+}
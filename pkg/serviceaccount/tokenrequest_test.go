@@ -0,0 +1,219 @@
+package serviceaccount
+
+import (
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	authenticationV1 "k8s.io/api/authentication/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sTesting "k8s.io/client-go/testing"
+
+	"github.com/aws/amazon-eks-connector/pkg/config"
+)
+
+const testRequestedToken = "eyJhbGciOiJSUzI1NiJ9.requested.signature"
+
+func TestTokenRequestSuite(t *testing.T) {
+	suite.Run(t, new(TokenRequestSuite))
+}
+
+type TokenRequestSuite struct {
+	suite.Suite
+
+	k8sClient *fake.Clientset
+	fallback  *MockSecretProvider
+	provider  ReloadableSecretProvider
+	// requestErr is returned by the TokenRequest API if set.
+	requestErr error
+	// requestStarted and requestBlock, if set, are notified and wait for once the TokenRequest API is called.
+	requestStarted chan struct{}
+	requestBlock   chan struct{}
+}
+
+func (suite *TokenRequestSuite) SetupTest() {
+	suite.requestErr = nil
+	suite.requestStarted, suite.requestBlock = nil, nil
+	suite.k8sClient = fake.NewSimpleClientset()
+	suite.k8sClient.PrependReactor("create", "serviceaccounts",
+		func(action k8sTesting.Action) (bool, runtime.Object, error) {
+			if suite.requestBlock != nil {
+				suite.requestStarted <- struct{}{}
+				<-suite.requestBlock
+			}
+			if suite.requestErr != nil {
+				return true, nil, suite.requestErr
+			}
+			return true, &authenticationV1.TokenRequest{
+				Status: authenticationV1.TokenRequestStatus{
+					Token:               testRequestedToken,
+					ExpirationTimestamp: metaV1.NewTime(time.Now().Add(10 * time.Minute)),
+				},
+			}, nil
+		})
+	suite.fallback = &MockSecretProvider{}
+	suite.fallback.On("Get").Return(&Secret{
+		Token:   testSAToken,
+		RootCAs: x509.NewCertPool(),
+	}, nil)
+	provider, err := NewTokenRequestProvider(suite.k8sClient, &config.TokenRequestConfig{
+		ServiceAccount: "eks-connector/eks-connector",
+		Audiences:      []string{"https://kubernetes.default.svc"},
+		Expiration:     10 * time.Minute,
+	}, suite.fallback)
+	suite.Require().NoError(err)
+	suite.provider = provider
+}
+
+func (suite *TokenRequestSuite) TestGetRequestsToken() {
+	// test
+	secret1, err1 := suite.provider.Get()
+	secret2, err2 := suite.provider.Get()
+
+	// verify
+	suite.NoError(err1)
+	suite.NoError(err2)
+	suite.Equal(testRequestedToken, secret1.Token)
+	suite.NotNil(secret1.RootCAs, "the CA bundle is the one of the fallback secret")
+	suite.WithinDuration(time.Now().Add(10*time.Minute), secret1.Expiry, time.Minute)
+	suite.Equal(testRequestedToken, secret2.Token)
+	actions := suite.k8sClient.Actions()
+	suite.Require().Len(actions, 1, "the token is reused until it is due for refresh")
+	createAction := actions[0].(k8sTesting.CreateAction)
+	suite.Equal("eks-connector", createAction.GetNamespace())
+	suite.Equal("token", createAction.GetSubresource())
+	tokenRequest := createAction.GetObject().(*authenticationV1.TokenRequest)
+	suite.Equal([]string{"https://kubernetes.default.svc"}, tokenRequest.Spec.Audiences)
+	suite.Equal(int64(600), *tokenRequest.Spec.ExpirationSeconds)
+}
+
+func (suite *TokenRequestSuite) TestGetRefreshesToken() {
+	// prepare
+	_, err := suite.provider.Get()
+	suite.NoError(err)
+	suite.provider.(*tokenRequestProvider).current.refreshAt = time.Now().Add(-time.Second)
+
+	// test
+	_, err = suite.provider.Get()
+
+	// verify
+	suite.NoError(err)
+	suite.Len(suite.k8sClient.Actions(), 2)
+}
+
+func (suite *TokenRequestSuite) TestGetDuringRefresh() {
+	// prepare
+	_, err := suite.provider.Get()
+	suite.NoError(err)
+	suite.provider.(*tokenRequestProvider).current.refreshAt = time.Now().Add(-time.Second)
+	suite.requestStarted, suite.requestBlock = make(chan struct{}), make(chan struct{})
+	refreshed := make(chan *Secret)
+	go func() {
+		secret, _ := suite.provider.Get()
+		refreshed <- secret
+	}()
+	<-suite.requestStarted
+
+	// test
+	secret, err := suite.provider.Get()
+
+	// verify
+	suite.NoError(err)
+	suite.Equal(testRequestedToken, secret.Token, "the valid token is used while a slow TokenRequest is in progress")
+	close(suite.requestBlock)
+	suite.Equal(testRequestedToken, (<-refreshed).Token)
+	suite.Len(suite.k8sClient.Actions(), 2, "a single TokenRequest refreshes the token")
+}
+
+func (suite *TokenRequestSuite) TestGetFallsBack() {
+	// prepare
+	suite.requestErr = errors.New("forbidden")
+
+	// test
+	secret1, err1 := suite.provider.Get()
+	secret2, err2 := suite.provider.Get()
+
+	// verify
+	suite.NoError(err1)
+	suite.NoError(err2)
+	suite.Equal(testSAToken, secret1.Token)
+	suite.Equal(testSAToken, secret2.Token)
+	suite.Len(suite.k8sClient.Actions(), 1, "failed requests are retried after an interval")
+}
+
+func (suite *TokenRequestSuite) TestGetKeepsValidTokenOnFailure() {
+	// prepare
+	_, err := suite.provider.Get()
+	suite.NoError(err)
+	suite.provider.(*tokenRequestProvider).current.refreshAt = time.Now().Add(-time.Second)
+	suite.requestErr = errors.New("api server unavailable")
+
+	// test
+	secret, err := suite.provider.Get()
+
+	// verify
+	suite.NoError(err)
+	suite.Equal(testRequestedToken, secret.Token, "the token is used until it expires")
+}
+
+func (suite *TokenRequestSuite) TestReload() {
+	// prepare
+	_, err := suite.provider.Get()
+	suite.NoError(err)
+	suite.provider.(*tokenRequestProvider).requestedAt = time.Now().Add(-tokenRequestRetryInterval)
+
+	// test
+	err = suite.provider.Reload(ReloadUnauthorized)
+
+	// verify
+	suite.NoError(err)
+	suite.Len(suite.k8sClient.Actions(), 2)
+}
+
+func (suite *TokenRequestSuite) TestReloadRateLimited() {
+	// prepare
+	_, err := suite.provider.Get()
+	suite.NoError(err)
+
+	// test
+	for i := 0; i < 5; i++ {
+		err = suite.provider.Reload(ReloadUnauthorized)
+		suite.NoError(err)
+	}
+
+	// verify
+	suite.Len(suite.k8sClient.Actions(), 1, "a token requested within the retry interval is not requested again")
+}
+
+func (suite *TokenRequestSuite) TestReloadRateLimitedOnFailure() {
+	// prepare
+	suite.requestErr = errors.New("serviceaccounts \"eks-connector\" not found")
+
+	// test
+	var errs []error
+	for i := 0; i < 5; i++ {
+		errs = append(errs, suite.provider.Reload(ReloadUnauthorized))
+	}
+
+	// verify
+	for _, err := range errs {
+		suite.Error(err)
+	}
+	suite.Len(suite.k8sClient.Actions(), 1, "rejections of every request do not flood api server with TokenRequests")
+}
+
+func (suite *TokenRequestSuite) TestInvalidServiceAccount() {
+	for _, serviceAccount := range []string{"eks-connector", "eks-connector/", "/eks-connector", "a/b/c"} {
+		// test
+		_, err := NewTokenRequestProvider(suite.k8sClient, &config.TokenRequestConfig{
+			ServiceAccount: serviceAccount,
+		}, suite.fallback)
+
+		// verify
+		suite.Error(err, serviceAccount)
+	}
+}
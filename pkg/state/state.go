@@ -3,8 +3,13 @@ package state
 
 import (
 	"encoding/json"
+	"strings"
+	"time"
 )
 
+// PrivateKeyCreatedDateFormat is the format of PrivateKeyCreatedDate expected by ssm agent.
+const PrivateKeyCreatedDateFormat = "2006-01-02 15:04:05.999999999 -0700 MST"
+
 type State struct {
 	ActivationId          string
 	FingerPrint           string
@@ -40,6 +45,16 @@ func Deserialize(serializedState SerializedState) (state *State, err error) {
 	return state, nil
 }
 
+// PrivateKeyCreatedTime parses PrivateKeyCreatedDate.
+func (state *State) PrivateKeyCreatedTime() (time.Time, error) {
+	date := state.PrivateKeyCreatedDate
+	// ssm agent formats time.Now() as is, which may carry a monotonic clock reading.
+	if index := strings.Index(date, " m="); index >= 0 {
+		date = date[:index]
+	}
+	return time.Parse(PrivateKeyCreatedDateFormat, date)
+}
+
 func (state *State) Serialize() (serializedState SerializedState, err error) {
 	serializedState = SerializedState{}
 
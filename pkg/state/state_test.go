@@ -2,6 +2,7 @@ package state
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -40,6 +41,29 @@ func (suite *StateSuite) TestDeserializeNoEksConnectorConfig() {
 	suite.Equal(expectedState, actualState)
 }
 
+func (suite *StateSuite) TestPrivateKeyCreatedTime() {
+	testCases := map[string]time.Time{
+		"2021-10-05 05:27:47.693369915 +0000 UTC":               time.Date(2021, 10, 5, 5, 27, 47, 693369915, time.UTC),
+		"2021-10-05 05:27:47.693369915 +0000 UTC m=+0.01234567": time.Date(2021, 10, 5, 5, 27, 47, 693369915, time.UTC),
+	}
+	for date, expected := range testCases {
+		state := &State{PrivateKeyCreatedDate: date}
+
+		actual, err := state.PrivateKeyCreatedTime()
+
+		suite.NoError(err)
+		suite.True(expected.Equal(actual), date)
+	}
+}
+
+func (suite *StateSuite) TestPrivateKeyCreatedTimeInvalid() {
+	state := &State{PrivateKeyCreatedDate: "yesterday"}
+
+	_, err := state.PrivateKeyCreatedTime()
+
+	suite.Error(err)
+}
+
 func testState() *State {
 	return &State{
 		ActivationId:          "f4423803-dd4a-4994-8fcd-b7d6105b3c43",